@@ -3,15 +3,65 @@ package cmd
 import (
 	"github.com/Linkinlog/gasible/internal/app"
 	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 func newWriteCurrent(app *app.App) {
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "generate",
-		Short: "Writes the current config to $HOME/.gas/config.yml.",
+		Short: "Writes the current config to $HOME/.gas/config.yml (or --config).",
 		Long:  `This will create a default YAML file using the defaults provided by each module.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return app.ModuleRegistry.WriteRegistryConfigsToYAML()
+			return app.ModuleRegistry.WriteRegistryConfigs()
 		},
 	})
 }
+
+func newConfigCmd(app *app.App) {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the config file.",
+		Long:  `Commands for inspecting and converting the config file, which can be YAML, JSON or TOML.`,
+	}
+
+	var format, output string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the config in another format.",
+		Long: `Converts the current config to YAML, JSON or TOML and prints it, or writes it to --output.
+Encrypted values stay encrypted, in JSON and TOML they are written as "!encrypted ..." strings.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configFormat, err := parseExportFormat(format, output)
+			if err != nil {
+				return err
+			}
+			exported, err := app.Config.Export(configFormat)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				_, err = os.Stdout.Write(exported)
+				return err
+			}
+			return os.WriteFile(output, exported, 0600)
+		},
+	}
+	exportCmd.Flags().StringVarP(&format, "format", "f", "", "format to export: yaml, json or toml (default from --output's extension, or yaml)")
+	exportCmd.Flags().StringVarP(&output, "output", "o", "", "file to write to instead of stdout")
+	configCmd.AddCommand(exportCmd)
+
+	rootCmd.AddCommand(configCmd)
+}
+
+// parseExportFormat picks the export format from the flag, falling back to the output file's extension and then YAML.
+func parseExportFormat(format string, output string) (app.ConfigFormat, error) {
+	if format != "" {
+		return app.ParseFormat(format)
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(output), "."); ext != "" {
+		return app.ParseFormat(ext)
+	}
+	return app.FormatYAML, nil
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/modules"
+	"github.com/spf13/cobra"
+)
+
+func newFreezeCmd(app *app.App) {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "freeze",
+		Short: "Write the explicitly installed packages into the config.",
+		Long: `This asks each package manager which packages were installed on purpose, rather than as dependencies,
+and writes them into the config: the native manager's as the packages, the others' under managers.
+Their exact versions are written to packages.lock next to the config, for 'gasible setup --locked'.
+Nothing is installed or changed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := app.ModuleRegistry.ReadAndSetRegistryConfigs()
+			if err != nil {
+				return err
+			}
+			gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
+			_, err = gpm.Freeze()
+			return err
+		},
+	})
+}
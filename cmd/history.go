@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/journal"
+	"github.com/spf13/cobra"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyFilter narrows down which journal entries `gasible history` shows.
+type historyFilter struct {
+	Module  string
+	Action  string
+	Command string
+	Failed  bool
+	Since   time.Duration
+}
+
+// matches reports whether the entry passes every filter that was set.
+func (f historyFilter) matches(entry journal.Entry, now time.Time) bool {
+	if f.Module != "" && !strings.EqualFold(entry.Module, f.Module) {
+		return false
+	}
+	if f.Action != "" && !strings.EqualFold(entry.Action, f.Action) {
+		return false
+	}
+	if f.Command != "" && !strings.Contains(entry.Command(), f.Command) {
+		return false
+	}
+	if f.Failed && !entry.Failed() {
+		return false
+	}
+	if f.Since > 0 && entry.Time.Before(now.Add(-f.Since)) {
+		return false
+	}
+	return true
+}
+
+func newHistoryCmd(app *app.App) {
+	var filter historyFilter
+	var showOutput, asJSON bool
+	historyCmd := &cobra.Command{
+		Use:   "history [run|last]",
+		Short: "List the commands Gasible ran in past runs.",
+		Long: `Every command Gasible runs is journaled to $HOME/.gas/history, one file per run.
+Without arguments this lists past runs, with a run ID (or "last") it lists the commands in that run.
+Known secrets, like tokens piped into commands, are redacted before anything is written.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := app.HistoryDir()
+			if err != nil {
+				return err
+			}
+			runs, err := journal.Runs(app.FS, dir)
+			if err != nil {
+				return err
+			}
+			if len(runs) == 0 {
+				fmt.Println("No history yet.")
+				return nil
+			}
+			if len(args) == 1 {
+				run := args[0]
+				if run == "last" {
+					run = runs[len(runs)-1]
+				}
+				runs = []string{run}
+			}
+
+			var entries []journal.Entry
+			now := time.Now()
+			for _, run := range runs {
+				runEntries, readErr := journal.Read(app.FS, dir, run)
+				if readErr != nil {
+					if len(args) == 1 && errors.Is(readErr, os.ErrNotExist) {
+						return fmt.Errorf("no history for run %s", run)
+					}
+					return readErr
+				}
+				for _, entry := range runEntries {
+					if filter.matches(entry, now) {
+						entries = append(entries, entry)
+					}
+				}
+			}
+
+			switch {
+			case asJSON:
+				encoder := json.NewEncoder(os.Stdout)
+				for _, entry := range entries {
+					if err = encoder.Encode(entry); err != nil {
+						return err
+					}
+				}
+				return nil
+			case len(args) == 1:
+				return printHistoryEntries(entries, showOutput)
+			default:
+				return printHistoryRuns(entries)
+			}
+		},
+	}
+	historyCmd.Flags().StringVar(&filter.Module, "module", "", "only show commands run by this module")
+	historyCmd.Flags().StringVar(&filter.Action, "action", "", "only show commands run during this action, such as setup or update")
+	historyCmd.Flags().StringVar(&filter.Command, "command", "", "only show commands containing this text")
+	historyCmd.Flags().BoolVar(&filter.Failed, "failed", false, "only show commands that failed")
+	historyCmd.Flags().DurationVar(&filter.Since, "since", 0, "only show commands run within this long, such as 24h")
+	historyCmd.Flags().BoolVar(&showOutput, "output", false, "show the recorded stdout and stderr of each command")
+	historyCmd.Flags().BoolVar(&asJSON, "json", false, "print the matching entries as JSON lines")
+	rootCmd.AddCommand(historyCmd)
+}
+
+// printHistoryRuns prints one line per run, summarising the entries that matched.
+func printHistoryRuns(entries []journal.Entry) error {
+	type runSummary struct {
+		started  time.Time
+		actions  []string
+		commands int
+		failed   int
+	}
+	var order []string
+	summaries := make(map[string]*runSummary)
+	for _, entry := range entries {
+		summary, ok := summaries[entry.Run]
+		if !ok {
+			summary = &runSummary{started: entry.Time}
+			summaries[entry.Run] = summary
+			order = append(order, entry.Run)
+		}
+		if entry.Action != "" && !slices.Contains(summary.actions, entry.Action) {
+			summary.actions = append(summary.actions, entry.Action)
+		}
+		summary.commands++
+		if entry.Failed() {
+			summary.failed++
+		}
+	}
+	if len(order) == 0 {
+		fmt.Println("No matching commands.")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "RUN\tSTARTED\tACTION\tCOMMANDS\tFAILED")
+	for _, run := range order {
+		summary := summaries[run]
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\n", run, summary.started.Format(time.DateTime),
+			strings.Join(summary.actions, ","), summary.commands, summary.failed)
+	}
+	return writer.Flush()
+}
+
+// printHistoryEntries prints one line per command, with its output underneath when asked for.
+func printHistoryEntries(entries []journal.Entry, showOutput bool) error {
+	if len(entries) == 0 {
+		fmt.Println("No matching commands.")
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !showOutput {
+		_, _ = fmt.Fprintln(writer, "TIME\tMODULE\tEXIT\tDURATION\tCOMMAND")
+	}
+	for _, entry := range entries {
+		command := entry.Command()
+		if entry.Sudo {
+			command += " (root)"
+		}
+		exitCode := fmt.Sprint(entry.ExitCode)
+		if entry.Error != "" {
+			exitCode = "error"
+		}
+		if !showOutput {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", entry.Time.Format(time.TimeOnly), entry.Module, exitCode,
+				entry.Duration.Round(time.Millisecond), command)
+			continue
+		}
+		// Output blocks break tabwriter's columns, so each command gets its own header line instead.
+		fmt.Printf("%s %s exit=%s duration=%s: %s\n", entry.Time.Format(time.TimeOnly), entry.Module, exitCode,
+			entry.Duration.Round(time.Millisecond), command)
+		printIndented("error", entry.Error)
+		printIndented("stdout", entry.Stdout)
+		printIndented("stderr", entry.Stderr)
+	}
+	return writer.Flush()
+}
+
+// printIndented prints a labelled block of recorded text, skipping it when empty.
+func printIndented(label string, text string) {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return
+	}
+	fmt.Printf("    %s:\n", label)
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Printf("      %s\n", line)
+	}
+}
@@ -16,10 +16,37 @@ local configs. Read more in the README.md of this package`,
 )
 
 func ExecuteApplication(app *app.App) error {
+	defer func() { _ = app.Close() }()
+	var root string
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&app.Config.FullPath, "config", "", "config file to use, YAML, JSON or TOML by extension (default $HOME/.gas/config.yml)")
+	flags.StringVar(&root, "root", "", "directory to use as the home directory for gasible and the commands it runs, commands run as root still change the real system")
+	flags.BoolVarP(&app.Config.Verbose, "verbose", "v", false, "log at debug level and stream all command output instead of a progress line per command")
+	flags.BoolVarP(&app.Config.LogFlags.Quiet, "quiet", "q", false, "only log warnings and errors")
+	flags.StringVar(&app.Config.LogFlags.Level, "log-level", "", "log level: debug, info, warn or error (overrides -v and -q)")
+	flags.StringVar(&app.Config.LogFlags.Format, "log-format", "", "log format: text or json")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		app.Action = cmd.Name()
+		if root != "" {
+			if err := app.UseRoot(root); err != nil {
+				return err
+			}
+		}
+		if err := app.Config.ResolvePath(); err != nil {
+			return err
+		}
+		return app.SetupLogging()
+	}
 	newVersionCmd(app)
 	newWriteCurrent(app)
 	newSetupCmd(app)
 	newUpdateCmd(app)
+	newUpgradeCmd(app)
 	newTeardown(app)
+	newSecretCmd(app)
+	newConfigCmd(app)
+	newHistoryCmd(app)
+	newStatusCmd(app)
+	newFreezeCmd(app)
 	return rootCmd.Execute()
 }
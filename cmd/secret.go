@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newPassphraseEnvKey is the environment variable that can hold the new passphrase when rekeying.
+const newPassphraseEnvKey = "GASIBLE_NEW_PASSPHRASE"
+
+func newSecretCmd(app *app.App) {
+	var keyFile string
+	secretCmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Encrypt and decrypt values for config.yml.",
+		Long: `Values in config.yml can be encrypted so the config can be shared without leaking tokens.
+Encrypted values are tagged with !encrypted and decrypted when the config is loaded, using the passphrase in
+$GASIBLE_PASSPHRASE, the key file ($HOME/.gas/secret.key by default), or a prompt.`,
+	}
+	secretCmd.PersistentFlags().StringVar(&keyFile, "key-file", "", "key file to use instead of $HOME/.gas/secret.key")
+
+	secretCmd.AddCommand(&cobra.Command{
+		Use:   "encrypt [value]",
+		Short: "Encrypt a value, printing it ready to paste into config.yml.",
+		Long:  `Encrypts the value given, or one read from a prompt (or stdin) when none is given.`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			plaintext, err := valueFromArgs(app.FS, args, "Value to encrypt")
+			if err != nil {
+				return err
+			}
+			cipher, err := cipherFromSource(app.FS, app.Config.PassphraseSource(keyFile))
+			if err != nil {
+				return err
+			}
+			encrypted, err := cipher.Encrypt(plaintext)
+			if err != nil {
+				return err
+			}
+			fmt.Println(secrets.EncryptedTag + " " + encrypted)
+			return nil
+		},
+	})
+
+	secretCmd.AddCommand(&cobra.Command{
+		Use:   "decrypt [value]",
+		Short: "Decrypt a value from config.yml.",
+		Long:  `Decrypts the value given, with or without the !encrypted tag, or one read from a prompt (or stdin).`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			encrypted, err := valueFromArgs(app.FS, args, "Value to decrypt")
+			if err != nil {
+				return err
+			}
+			cipher, err := cipherFromSource(app.FS, app.Config.PassphraseSource(keyFile))
+			if err != nil {
+				return err
+			}
+			plaintext, err := cipher.Decrypt(trimTag(encrypted))
+			if err != nil {
+				return err
+			}
+			fmt.Println(plaintext)
+			return nil
+		},
+	})
+
+	var newKeyFile string
+	rekeyCmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Re-encrypt every value in config.yml with a new passphrase or key file.",
+		Long: `Decrypts every encrypted value in the config with the current key and encrypts it again with the new one.
+The new passphrase is read from $` + newPassphraseEnvKey + ` or a prompt, unless --new-key-file is given,
+in which case a random key is generated into that file if it doesn't exist yet.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := cipherFromSource(app.FS, app.Config.PassphraseSource(keyFile))
+			if err != nil {
+				return err
+			}
+			to, err := newCipher(app.FS, newKeyFile)
+			if err != nil {
+				return err
+			}
+			count, err := app.Config.Rekey(from, to)
+			if err != nil {
+				return err
+			}
+			slog.Info("re-encrypted config values", "count", count, "path", app.Config.FullPath)
+			return nil
+		},
+	}
+	rekeyCmd.Flags().StringVar(&newKeyFile, "new-key-file", "", "key file to encrypt with, generated if it doesn't exist")
+	secretCmd.AddCommand(rekeyCmd)
+
+	rootCmd.AddCommand(secretCmd)
+}
+
+// valueFromArgs returns the first argument, or prompts for the value if there isn't one.
+func valueFromArgs(fsys filesystem.FS, args []string, prompt string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	return secrets.Source{Prompt: prompt}.Resolve(fsys)
+}
+
+// trimTag strips a leading !encrypted tag so values can be copied straight out of config.yml.
+func trimTag(value string) string {
+	return strings.TrimPrefix(strings.TrimSpace(value), secrets.EncryptedTag)
+}
+
+// cipherFromSource resolves a passphrase and returns a cipher for it.
+func cipherFromSource(fsys filesystem.FS, source secrets.Source) (*secrets.Cipher, error) {
+	passphrase, err := source.Resolve(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.NewCipher(passphrase), nil
+}
+
+// newCipher returns the cipher to rekey to, generating the key file first if asked for one that doesn't exist.
+func newCipher(fsys filesystem.FS, keyFile string) (*secrets.Cipher, error) {
+	if keyFile == "" {
+		return cipherFromSource(fsys, secrets.Source{Env: newPassphraseEnvKey, Prompt: "Enter new config passphrase"})
+	}
+
+	_, err := fsys.Stat(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		key, keyErr := secrets.GenerateKey()
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		if mkdirErr := fsys.MkdirAll(filepath.Dir(keyFile), 0750); mkdirErr != nil {
+			return nil, mkdirErr
+		}
+		if writeErr := fsys.WriteFile(keyFile, []byte(key+"\n"), 0600); writeErr != nil {
+			return nil, writeErr
+		}
+		slog.Info("generated new key file, keep it safe and out of version control", "path", keyFile)
+	} else if err != nil {
+		return nil, err
+	}
+	return cipherFromSource(fsys, secrets.Source{File: keyFile})
+}
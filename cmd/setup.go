@@ -7,24 +7,37 @@ import (
 )
 
 func newSetupCmd(app *app.App) {
-	rootCmd.AddCommand(
-		&cobra.Command{
-			Use:   "setup",
-			Short: "Set up all modules.",
-			Long:  `This will run the setup method on all modules.`,
-			RunE: func(cmd *cobra.Command, args []string) error {
-				err := app.ModuleRegistry.ReadAndSetRegistryConfigsFromYAML()
-				if err != nil {
-					panic(err)
+	var locked bool
+	setupCmd := &cobra.Command{
+		Use:   "setup",
+		Short: "Set up all modules.",
+		Long: `This will run the setup method on all modules.
+With --locked, packages without a version of their own are installed at the version in packages.lock.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := app.ModuleRegistry.ReadAndSetRegistryConfigs()
+			if err != nil {
+				return err
+			}
+			gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
+			if locked {
+				if lockErr := gpm.UseLockfile(); lockErr != nil {
+					return lockErr
 				}
-				gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
-				call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall)
-				installErr := gpm.Manager().Install(modules.ToBeInstalled[gpm.Manager()], call)
-				if installErr != nil {
-					return installErr
-				}
-				return app.ModuleRegistry.RunSetup()
-			},
+			}
+			call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall).ForModule(gpm.GetName())
+			release, privilegeErr := call.AcquirePrivileges()
+			if privilegeErr != nil {
+				return privilegeErr
+			}
+			defer release()
+			gpm.RefreshIndex()
+			installErr := gpm.Manager().Install(gpm.Locked(gpm.Manager(), modules.ToBeInstalled[gpm.Manager()]), call)
+			if installErr != nil {
+				return installErr
+			}
+			return app.ModuleRegistry.RunSetup()
 		},
-	)
+	}
+	setupCmd.Flags().BoolVar(&locked, "locked", false, "install the versions in packages.lock, written by 'gasible freeze'")
+	rootCmd.AddCommand(setupCmd)
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/modules"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+func newStatusCmd(app *app.App) {
+	var asJSON bool
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show which configured packages are installed.",
+		Long: `This asks each package manager which of the configured packages are installed, and at which version.
+Nothing is installed or changed. Pinned packages installed at another version are marked as drifted.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := app.ModuleRegistry.ReadAndSetRegistryConfigs()
+			if err != nil {
+				return err
+			}
+			gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
+			statuses := gpm.Status()
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				for _, status := range statuses {
+					if err = encoder.Encode(status); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return printPackageStatuses(statuses)
+		},
+	}
+	statusCmd.Flags().BoolVar(&asJSON, "json", false, "print each package's status as JSON lines")
+	rootCmd.AddCommand(statusCmd)
+}
+
+// printPackageStatuses prints one line per configured package.
+func printPackageStatuses(statuses []modules.PackageStatus) error {
+	if len(statuses) == 0 {
+		fmt.Println("No packages configured.")
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "MANAGER\tPACKAGE\tPINNED\tINSTALLED\t")
+	for _, status := range statuses {
+		installed := status.Installed
+		switch {
+		case !status.Known:
+			installed = "unknown"
+		case installed == "":
+			installed = "not installed"
+		}
+		note := ""
+		if status.Drifted() {
+			note = "drifted"
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", status.Manager, status.Package, status.Pinned, installed, note)
+	}
+	return writer.Flush()
+}
@@ -12,12 +12,17 @@ func newTeardown(app *app.App) {
 		Short: "Teardown all modules.",
 		Long:  `This will run the teardown method on all modules, this can result in data/package loss.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			err := app.ModuleRegistry.ReadAndSetRegistryConfigsFromYAML()
+			err := app.ModuleRegistry.ReadAndSetRegistryConfigs()
 			if err != nil {
-				panic(err)
+				return err
 			}
 			gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
-			call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall)
+			call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall).ForModule(gpm.GetName())
+			release, privilegeErr := call.AcquirePrivileges()
+			if privilegeErr != nil {
+				return privilegeErr
+			}
+			defer release()
 			teardownErr := gpm.Manager().Uninstall(modules.ToBeInstalled[gpm.Manager()], call)
 			if teardownErr != nil {
 				return teardownErr
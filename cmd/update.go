@@ -12,12 +12,18 @@ func newUpdateCmd(app *app.App) {
 		Short: "update packages and configurations.",
 		Long:  `This will run the update command against all modules.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			err := app.ModuleRegistry.ReadAndSetRegistryConfigsFromYAML()
+			err := app.ModuleRegistry.ReadAndSetRegistryConfigs()
 			if err != nil {
-				panic(err)
+				return err
 			}
 			gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
-			call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall)
+			call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall).ForModule(gpm.GetName())
+			release, privilegeErr := call.AcquirePrivileges()
+			if privilegeErr != nil {
+				return privilegeErr
+			}
+			defer release()
+			gpm.RefreshIndex()
 			updateErr := gpm.Manager().Update(modules.ToBeInstalled[gpm.Manager()], call)
 			if updateErr != nil {
 				return updateErr
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/modules"
+	"github.com/spf13/cobra"
+)
+
+func newUpgradeCmd(app *app.App) {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "upgrade",
+		Short: "upgrade everything installed on the system.",
+		Long: `This refreshes each package manager's index and upgrades every package it has installed,
+not only the configured ones. Other modules aren't run.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := app.ModuleRegistry.ReadAndSetRegistryConfigs()
+			if err != nil {
+				return err
+			}
+			gpm := app.ModuleRegistry.GetModule("GenericPackageManager").(*modules.GenericPackageManager)
+			call := app.ModuleRegistry.GetModule("SysCall").(*modules.SysCall).ForModule(gpm.GetName())
+			release, privilegeErr := call.AcquirePrivileges()
+			if privilegeErr != nil {
+				return privilegeErr
+			}
+			defer release()
+			return gpm.Upgrade()
+		},
+	})
+}
@@ -1,10 +1,13 @@
 module github.com/Linkinlog/gasible
 
-go 1.20
+go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/crypto v0.14.0
+	golang.org/x/term v0.13.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
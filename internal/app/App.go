@@ -1,23 +1,150 @@
 package app
 
+import (
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/download"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"github.com/Linkinlog/gasible/internal/journal"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
 // configDir is so that we can specify where to put our config.
 const configDir = ".gas"
 
 // configFileName is so that we can specify which filename our config should use.
 const configFilename = "config.yml"
 
+// historyDir is the directory under the config directory that command journals are written to.
+const historyDir = "history"
+
+// runIDFormat names this run's log and journal files, so they sort by when the run started.
+const runIDFormat = "20060102-150405.000"
+
+// sandboxedDirs are the directories commands are given under the sandbox root, by variable.
+var sandboxedDirs = map[string]string{
+	"HOME":            "",
+	"XDG_CONFIG_HOME": ".config",
+	"XDG_DATA_HOME":   ".local/share",
+	"XDG_STATE_HOME":  ".local/state",
+	"XDG_CACHE_HOME":  ".cache",
+}
+
+// unsandboxedVariables point tools at directories outside of HOME, or at the desktop keyring,
+// so they are left out of a sandboxed command's environment and the tools fall back to files under HOME.
+var unsandboxedVariables = []string{
+	"GOPATH", "GOBIN", "GOMODCACHE", "PIPX_HOME", "PIPX_BIN_DIR", "GEM_HOME", "GEM_PATH",
+	"NPM_CONFIG_PREFIX", "npm_config_prefix", "GH_CONFIG_DIR", "DBUS_SESSION_BUS_ADDRESS",
+}
+
+// configFilenames are the config files we will pick up from the config directory, in order of preference.
+var configFilenames = []string{configFilename, "config.yaml", "config.json", "config.toml"}
+
 // App is to represent the currently running application and its state.
 type App struct {
 	Config         *Config
 	ModuleRegistry *registry
+	FS             filesystem.FS
 	Version        string
+	Action         string
+	RunID          string
+	runLog         *os.File
+	journal        *journal.Journal
+	journalOnce    sync.Once
+	downloader     *download.Downloader
+	downloaderErr  error
+	downloaderOnce sync.Once
 }
 
 // New returns a pointer to an application
 func New() *App {
+	fsys := filesystem.Host()
+	config := NewConfig(fsys)
 	return &App{
-		Config:         NewConfig(),
-		ModuleRegistry: newRegistry(),
+		Config:         config,
+		ModuleRegistry: newRegistry(config),
+		FS:             fsys,
 		Version:        "0.1.3",
+		RunID:          time.Now().Format(runIDFormat),
+	}
+}
+
+// UseRoot sandboxes the application in root, which then stands in for the home directory.
+// The config, logs, history and anything modules write to the home directory end up there instead,
+// and gasible writes none of its own files outside of it. Commands that modules run get root as their
+// home directory too, see CommandEnv, but those run as root, like package managers, still change the system.
+func (a *App) UseRoot(root string) error {
+	fsys, err := filesystem.Sandbox(root)
+	if err != nil {
+		return internal.ErrorAs("App.UseRoot", err)
+	}
+	a.FS = fsys
+	a.Config.fs = fsys
+	return nil
+}
+
+// CommandEnv returns the environment commands run with, nil for our own when the app isn't sandboxed.
+// In a sandbox, HOME and the XDG directories point into the root, so tools like go, pipx, gem and gh keep
+// their files there. Variables that would send them back to the real ones are dropped.
+func (a *App) CommandEnv() []string {
+	root := a.FS.Root()
+	if root == "" {
+		return nil
+	}
+	env := make([]string, 0, len(os.Environ())+len(sandboxedDirs))
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if _, sandboxed := sandboxedDirs[name]; !sandboxed && !slices.Contains(unsandboxedVariables, name) {
+			env = append(env, variable)
+		}
+	}
+	names := make([]string, 0, len(sandboxedDirs))
+	for name := range sandboxedDirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+filepath.Join(root, sandboxedDirs[name]))
+	}
+	return env
+}
+
+// HistoryDir returns where command journals are kept, $HOME/.gas/history.
+func (a *App) HistoryDir() (string, error) {
+	homeDir, err := a.FS.HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configDir, historyDir), nil
+}
+
+// Journal returns the journal for this run, or nil if there is nowhere to keep one.
+func (a *App) Journal() *journal.Journal {
+	a.journalOnce.Do(func() {
+		dir, err := a.HistoryDir()
+		if err != nil {
+			return
+		}
+		a.journal = journal.New(a.FS, dir, a.RunID)
+	})
+	return a.journal
+}
+
+// Close closes the run log and journal.
+func (a *App) Close() error {
+	var err error
+	if a.journal != nil {
+		err = a.journal.Close()
+	}
+	if a.runLog != nil {
+		if closeErr := a.runLog.Close(); closeErr != nil {
+			err = closeErr
+		}
 	}
+	return err
 }
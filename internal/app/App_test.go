@@ -0,0 +1,37 @@
+package app
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommandEnv(t *testing.T) {
+	application := New()
+	if env := application.CommandEnv(); env != nil {
+		t.Fatalf("got %q, want our own environment when not sandboxed", env)
+	}
+
+	t.Setenv("GOPATH", "/home/someone/go")
+	t.Setenv("GASIBLE_TEST", "kept")
+	root := t.TempDir()
+	if err := application.UseRoot(root); err != nil {
+		t.Fatalf("unable to sandbox the app: %v", err)
+	}
+	t.Cleanup(func() { _ = application.Close() })
+	env := application.CommandEnv()
+	for _, want := range []string{"HOME=" + root, "XDG_CONFIG_HOME=" + filepath.Join(root, ".config"), "GASIBLE_TEST=kept"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("environment is missing %s", want)
+		}
+	}
+	for _, variable := range env {
+		if strings.HasPrefix(variable, "GOPATH=") {
+			t.Errorf("got %s, want GOPATH left to default under HOME", variable)
+		}
+		if strings.HasPrefix(variable, "HOME=") && variable != "HOME="+root {
+			t.Errorf("got a second %s", variable)
+		}
+	}
+}
@@ -1,71 +1,282 @@
 package app
 
 import (
+	"fmt"
 	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
+// keyFilename is the local key file used to decrypt !encrypted config values when no passphrase is given.
+const keyFilename = "secret.key"
+
+// PassphraseEnvKey is the environment variable that can hold the passphrase for !encrypted config values.
+const PassphraseEnvKey = "GASIBLE_PASSPHRASE"
+
 // Config is the configuration for the application.
 type Config struct {
-	Version    string   `yaml:"version"`
-	AllModules []Module `yaml:"modules"`
+	Version    string          `yaml:"version"`
+	AllModules []Module        `yaml:"modules"`
+	Logging    LoggingConfig   `yaml:"logging"`
+	Downloads  DownloadsConfig `yaml:"downloads"`
 	FullPath   string
-	// TODO log level/filepath once logging is implemented
+	Verbose    bool
+	LogFlags   LogFlags
+	fs         filesystem.FS
 }
 
-// NewConfig returns a pointer to a Config.
-func NewConfig() *Config {
+// NewConfig returns a pointer to a Config that finds its files through fsys.
+// The config path is left for ResolvePath, so it can still be set or sandboxed from the command line.
+func NewConfig(fsys filesystem.FS) *Config {
 	return &Config{
 		Version:    "0.1.0",
 		AllModules: make([]Module, 0),
-		FullPath:   mustGetConfigPath(),
+		Logging:    defaultLoggingConfig(),
+		Downloads:  defaultDownloadsConfig(),
+		fs:         fsys,
+	}
+}
+
+// ResolvePath sets FullPath to the config in the home directory, creating it if needed,
+// unless a path has already been given.
+func (c *Config) ResolvePath() error {
+	if c.FullPath != "" {
+		return nil
 	}
+	path, err := c.createAndOrGetConfigPath()
+	if err != nil {
+		return err
+	}
+	c.FullPath = path
+	return nil
 }
 
 // createAndOrGetConfigPath
 // Creates the structure for the config if needed.
-// Returns full os compliant path once found.
-func createAndOrGetConfigPath() (string, error) {
+// Returns full os compliant path once found, preferring whichever of configFilenames already exists.
+func (c *Config) createAndOrGetConfigPath() (string, error) {
 	// Find home directory.
-	homeDir, err := os.UserHomeDir()
+	homeDir, err := c.fs.HomeDir()
 	if err != nil {
 		return "", internal.ErrorAs("createAndOrGetConfigPath", err)
 	}
 
 	// Append the AppConfig file directory to the home directory path
 	confDir := filepath.Join(homeDir, configDir)
-	_, err = os.Stat(confDir)
+	_, err = c.fs.Stat(confDir)
 
 	// If the directory doesn't exist, we create it
 	if os.IsNotExist(err) {
-		errDir := os.MkdirAll(confDir, 0750)
+		errDir := c.fs.MkdirAll(confDir, 0750)
 		if errDir != nil {
-			return "", internal.ErrorAs("createAndOrGetConfigPath", err)
+			return "", internal.ErrorAs("createAndOrGetConfigPath", errDir)
+		}
+	}
+
+	// Use an existing config in any of the formats we support
+	for _, filename := range configFilenames {
+		existingPath := filepath.Join(confDir, filename)
+		if _, statErr := c.fs.Stat(existingPath); statErr == nil {
+			return existingPath, nil
 		}
 	}
 
-	// Append the AppConfig file path to the home directory path
+	// If the config doesn't exist, we create it
 	confFilePath := filepath.Join(confDir, configFilename)
+	errFile := c.fs.WriteFile(confFilePath, nil, 0600)
+	if errFile != nil {
+		return "", internal.ErrorAs("createAndOrGetConfigPath", errFile)
+	}
 
-	_, err = os.Stat(confFilePath)
+	return confFilePath, nil
+}
 
-	// If the config doesn't exist, we create it
-	if os.IsNotExist(err) {
-		_, errDir := os.Create(filepath.Clean(confFilePath))
-		if errDir != nil {
-			return "", internal.ErrorAs("createAndOrGetConfigPath", err)
+// DefaultKeyFilePath returns where we expect the local key file to be, $HOME/.gas/secret.key.
+func (c *Config) DefaultKeyFilePath() (string, error) {
+	homeDir, err := c.fs.HomeDir()
+	if err != nil {
+		return "", internal.ErrorAs("DefaultKeyFilePath", err)
+	}
+	return filepath.Join(homeDir, configDir, keyFilename), nil
+}
+
+// PassphraseSource is where we look for the key to !encrypted config values:
+// the GASIBLE_PASSPHRASE environment variable, then the key file, then a prompt.
+// An empty keyFile means the default key file.
+func (c *Config) PassphraseSource(keyFile string) secrets.Source {
+	if keyFile == "" {
+		keyFile, _ = c.DefaultKeyFilePath()
+	}
+	return secrets.Source{
+		Env:    PassphraseEnvKey,
+		File:   keyFile,
+		Prompt: "Enter config passphrase",
+	}
+}
+
+// appSections are the top level sections of the config file that configure Gasible itself, rather than a module.
+func (c *Config) appSections() map[string]interface{} {
+	return map[string]interface{}{
+		loggingKey:   &c.Logging,
+		downloadsKey: &c.Downloads,
+	}
+}
+
+// loadAppSettings reads just Gasible's own sections of the config file, so they are ready before the modules load.
+func (c *Config) loadAppSettings() error {
+	document, err := c.Read()
+	if err != nil || document == nil {
+		return err
+	}
+	mapping := document
+	if mapping.Kind == yaml.DocumentNode && len(mapping.Content) > 0 {
+		mapping = mapping.Content[0]
+	}
+	sections := c.appSections()
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if section, ok := sections[mapping.Content[i].Value]; ok {
+			if err = mapping.Content[i+1].Decode(section); err != nil {
+				return fmt.Errorf("%s: %w", mapping.Content[i].Value, err)
+			}
 		}
 	}
+	return nil
+}
 
-	return confFilePath, nil
+// Read reads the config file into a YAML document, whatever format it is stored in.
+// Encrypted values are left encrypted, and an empty file gives a nil document.
+func (c *Config) Read() (*yaml.Node, error) {
+	format, err := formatFromPath(c.FullPath)
+	if err != nil {
+		return nil, internal.ErrorAs("Config.Read", err)
+	}
+	fileContents, err := c.fs.ReadFile(c.FullPath)
+	if err != nil {
+		return nil, internal.ErrorAs("Config.Read", err)
+	}
+	document, err := decodeConfig(fileContents, format)
+	if err != nil {
+		return nil, internal.ErrorAs("Config.Read", fmt.Errorf("%s: %w", c.FullPath, err))
+	}
+	return document, nil
 }
 
-// mustGetConfigPath is a wrapper for createAndOrGetConfigPath that panics if there is an error.
-func mustGetConfigPath() string {
-	path, err := createAndOrGetConfigPath()
+// Write writes a YAML document to the config file, in the format matching the file's extension.
+func (c *Config) Write(document *yaml.Node) error {
+	format, err := formatFromPath(c.FullPath)
 	if err != nil {
-		panic(err)
+		return internal.ErrorAs("Config.Write", err)
 	}
-	return path
+	encoded, err := encodeConfig(document, format)
+	if err != nil {
+		return internal.ErrorAs("Config.Write", err)
+	}
+	if err = c.fs.WriteFile(c.FullPath, encoded, 0600); err != nil {
+		return internal.ErrorAs("Config.Write", err)
+	}
+	return nil
+}
+
+// Export returns the config file converted to another format, encrypted values stay encrypted.
+func (c *Config) Export(format ConfigFormat) ([]byte, error) {
+	document, err := c.Read()
+	if err != nil {
+		return nil, internal.ErrorAs("Config.Export", err)
+	}
+	if document == nil {
+		document = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	return encodeConfig(document, format)
+}
+
+// Rekey re-encrypts every !encrypted value in the config file from one key to another.
+// It returns how many values were re-encrypted.
+func (c *Config) Rekey(from *secrets.Cipher, to *secrets.Cipher) (int, error) {
+	document, err := c.Read()
+	if err != nil || document == nil {
+		return 0, err
+	}
+	count, err := secrets.RekeyNode(document, from, to)
+	if err != nil {
+		return 0, internal.ErrorAs("Config.Rekey", fmt.Errorf("%s: %w", c.FullPath, err))
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, c.Write(document)
+}
+
+// SetModuleSettings replaces the given settings of a module in the config file, adding the module if it isn't there.
+// Everything else in the file, encrypted values included, is left as it is.
+func (c *Config) SetModuleSettings(module string, settings map[string]interface{}) error {
+	document, err := c.Read()
+	if err != nil {
+		return internal.ErrorAs("Config.SetModuleSettings", err)
+	}
+	if document == nil {
+		document = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	root := document
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) == 0 {
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+		}
+		root = root.Content[0]
+	}
+	moduleNode, err := mappingChild(root, module)
+	if err != nil {
+		return internal.ErrorAs("Config.SetModuleSettings", err)
+	}
+	moduleSettings, err := mappingChild(moduleNode, "settings")
+	if err != nil {
+		return internal.ErrorAs("Config.SetModuleSettings", fmt.Errorf("%s: %w", module, err))
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, childErr := mappingChild(moduleSettings, key)
+		if childErr == nil {
+			childErr = value.Encode(settings[key])
+		}
+		if childErr != nil {
+			return internal.ErrorAs("Config.SetModuleSettings", childErr)
+		}
+	}
+	if err = c.Write(document); err != nil {
+		return internal.ErrorAs("Config.SetModuleSettings", err)
+	}
+	return nil
+}
+
+// mappingChild returns the value for a key in a YAML mapping, adding an empty mapping for it when it isn't there
+// and turning a null one into an empty mapping. The value is only for encoding into when it is a leaf,
+// so it is an error for the mapping itself to be anything but a mapping, rather than overwriting what is there.
+func mappingChild(mapping *yaml.Node, key string) (*yaml.Node, error) {
+	if mapping.Kind != yaml.MappingNode {
+		if !isNull(mapping) {
+			return nil, fmt.Errorf("line %d: want a mapping for %s, got %s", mapping.Line, key, mapping.Tag)
+		}
+		*mapping = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1], nil
+		}
+	}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value, nil
+}
+
+// isNull reports whether a node is an empty or null scalar, such as a key left without a value.
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && (node.Tag == "!!null" || (node.Tag == "" && node.Value == ""))
 }
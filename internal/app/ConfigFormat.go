@@ -0,0 +1,163 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigFormat is a file format the config can be stored in.
+type ConfigFormat string
+
+// The config formats we can read and write.
+const (
+	FormatYAML ConfigFormat = "yaml"
+	FormatJSON ConfigFormat = "json"
+	FormatTOML ConfigFormat = "toml"
+)
+
+// ConfigFormats lists the supported formats, for flag help and error messages.
+var ConfigFormats = []ConfigFormat{FormatYAML, FormatJSON, FormatTOML}
+
+// ParseFormat turns a format name, such as the value of a --format flag, into a ConfigFormat.
+func ParseFormat(name string) (ConfigFormat, error) {
+	switch strings.ToLower(name) {
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "json":
+		return FormatJSON, nil
+	case "toml":
+		return FormatTOML, nil
+	}
+	return "", fmt.Errorf("unsupported config format %q, use one of: %s", name, formatNames())
+}
+
+// formatFromPath picks the format from the config file's extension.
+func formatFromPath(path string) (ConfigFormat, error) {
+	format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return "", fmt.Errorf("config file %s: %w", path, err)
+	}
+	return format, nil
+}
+
+// formatNames is the supported formats joined for display.
+func formatNames() string {
+	names := make([]string, len(ConfigFormats))
+	for i, format := range ConfigFormats {
+		names[i] = string(format)
+	}
+	return strings.Join(names, ", ")
+}
+
+// decodeConfig parses the contents of a config file into a YAML document,
+// so every format goes through the same decrypt and ParseConfig path.
+// JSON and TOML have no tags, so encrypted values are written there as "!encrypted gasible:v1:..." strings.
+func decodeConfig(contents []byte, format ConfigFormat) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(contents)) == 0 {
+		return nil, nil
+	}
+
+	document := &yaml.Node{}
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(contents, document); err != nil {
+			return nil, err
+		}
+		return document, nil
+	case FormatJSON:
+		// JSON is a subset of YAML, so the YAML parser gives us a document directly.
+		if err := yaml.Unmarshal(contents, document); err != nil {
+			return nil, err
+		}
+		clearStyle(document)
+	case FormatTOML:
+		settings := make(map[string]interface{})
+		if err := toml.Unmarshal(contents, &settings); err != nil {
+			return nil, err
+		}
+		if err := document.Encode(settings); err != nil {
+			return nil, err
+		}
+	}
+	secrets.TagEncryptedStrings(document)
+	return document, nil
+}
+
+// encodeConfig writes a YAML document out in the given format.
+func encodeConfig(document *yaml.Node, format ConfigFormat) ([]byte, error) {
+	if format == FormatYAML {
+		return yaml.Marshal(document)
+	}
+
+	secrets.UntagEncrypted(document)
+	var settings map[string]interface{}
+	if err := document.Decode(&settings); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		encoded, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(encoded, '\n'), nil
+	case FormatTOML:
+		// TOML has no null, and the encoder would leave such values out without a word.
+		if err := findNull(settings, ""); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(settings); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported config format %q, use one of: %s", format, formatNames())
+}
+
+// clearStyle drops the flow style and quoting the YAML parser keeps from JSON input,
+// so a document read from JSON is written back out as regular block YAML.
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// findNull returns an error naming the first null value below path, which TOML can't hold.
+func findNull(value interface{}, path string) error {
+	switch typed := value.(type) {
+	case nil:
+		return fmt.Errorf("%s is null, which TOML can't represent, set it or remove it first", path)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			if err := findNull(typed[key], child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range typed {
+			if err := findNull(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTripConfig is a config with something of every kind a config holds, encrypted values included.
+const roundTripConfig = `GenericPackageManager:
+  enabled: true
+  settings:
+    manager: dnf
+    packages: [git, "gnupg@2.4.4"]
+    managers:
+      brew: []
+    refresh-max-age: 1h
+    hold: false
+GitHub:
+  enabled: true
+  settings:
+    token: !encrypted gasible:v1:c2VjcmV0
+    retries: 3
+`
+
+// convert decodes contents in one format and encodes them in another.
+func convert(t *testing.T, contents []byte, from ConfigFormat, to ConfigFormat) []byte {
+	t.Helper()
+	document, err := decodeConfig(contents, from)
+	if err != nil {
+		t.Fatalf("unable to decode %s: %v\n%s", from, err, contents)
+	}
+	encoded, err := encodeConfig(document, to)
+	if err != nil {
+		t.Fatalf("unable to encode %s: %v", to, err)
+	}
+	return encoded
+}
+
+// decoded returns the config as plain values, with encrypted values kept as their tagged strings.
+func decoded(t *testing.T, contents []byte) map[string]interface{} {
+	t.Helper()
+	document, err := decodeConfig(contents, FormatYAML)
+	if err != nil {
+		t.Fatalf("unable to decode: %v", err)
+	}
+	var values map[string]interface{}
+	if err = document.Decode(&values); err != nil {
+		t.Fatalf("unable to decode: %v", err)
+	}
+	return values
+}
+
+// hasEncrypted reports whether the YAML has the value as an !encrypted scalar, however it is quoted.
+func hasEncrypted(t *testing.T, contents []byte, value string) bool {
+	t.Helper()
+	var document yaml.Node
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		t.Fatalf("unable to parse: %v", err)
+	}
+	var find func(*yaml.Node) bool
+	find = func(node *yaml.Node) bool {
+		if node.Tag == secrets.EncryptedTag && node.Value == value {
+			return true
+		}
+		for _, child := range node.Content {
+			if find(child) {
+				return true
+			}
+		}
+		return false
+	}
+	return find(&document)
+}
+
+func TestConfigFormatsRoundTrip(t *testing.T) {
+	want := decoded(t, []byte(roundTripConfig))
+	for _, format := range []ConfigFormat{FormatJSON, FormatTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			converted := convert(t, []byte(roundTripConfig), FormatYAML, format)
+			if !strings.Contains(string(converted), "!encrypted gasible:v1:c2VjcmV0") {
+				t.Fatalf("the encrypted value isn't marked in %s:\n%s", format, converted)
+			}
+			back := convert(t, converted, format, FormatYAML)
+			if !hasEncrypted(t, back, "gasible:v1:c2VjcmV0") {
+				t.Fatalf("the encrypted value didn't come back tagged:\n%s", back)
+			}
+			if got := decoded(t, back); !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v after the round trip through %s, want %v", got, format, want)
+			}
+		})
+	}
+}
+
+func TestConfigNullValues(t *testing.T) {
+	const withNulls = "GenericPackageManager:\n  settings:\n    manager: ~\n    packages: [git, null]\n"
+	back := convert(t, convert(t, []byte(withNulls), FormatYAML, FormatJSON), FormatJSON, FormatYAML)
+	var settings struct {
+		GenericPackageManager struct {
+			Settings map[string]interface{} `yaml:"settings"`
+		} `yaml:"GenericPackageManager"`
+	}
+	if err := yaml.Unmarshal(back, &settings); err != nil {
+		t.Fatalf("unable to parse: %v", err)
+	}
+	if value, ok := settings.GenericPackageManager.Settings["manager"]; !ok || value != nil {
+		t.Fatalf("got %v, want JSON to keep the null:\n%s", settings.GenericPackageManager.Settings, back)
+	}
+
+	for _, contents := range []string{withNulls, "GenericPackageManager:\n  settings:\n    packages: [git, null]\n", "GitHub:\n"} {
+		document, err := decodeConfig([]byte(contents), FormatYAML)
+		if err != nil {
+			t.Fatalf("unable to decode: %v", err)
+		}
+		if _, err = encodeConfig(document, FormatTOML); err == nil || !strings.Contains(err.Error(), "null") {
+			t.Errorf("got error %v for %q, want TOML to refuse the null", err, contents)
+		}
+	}
+}
+
+func TestConfigEmptyValuesInTOML(t *testing.T) {
+	const empty = "GenericPackageManager:\n  settings:\n    manager: \"\"\n    packages: []\n    flatpak: {}\n"
+	back := convert(t, convert(t, []byte(empty), FormatYAML, FormatTOML), FormatTOML, FormatYAML)
+	if got, want := decoded(t, back), decoded(t, []byte(empty)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
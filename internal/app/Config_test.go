@@ -0,0 +1,133 @@
+package app
+
+import (
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestConfig returns a Config sandboxed in a temporary directory, whose file has the given contents.
+func newTestConfig(t *testing.T, name string, contents string) *Config {
+	t.Helper()
+	application := New()
+	if err := application.UseRoot(t.TempDir()); err != nil {
+		t.Fatalf("unable to sandbox the app: %v", err)
+	}
+	t.Cleanup(func() { _ = application.Close() })
+	home, err := application.FS.HomeDir()
+	if err != nil {
+		t.Fatalf("unable to find the sandbox's home directory: %v", err)
+	}
+	application.Config.FullPath = filepath.Join(home, name)
+	if err = os.WriteFile(application.Config.FullPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("unable to write the config: %v", err)
+	}
+	return application.Config
+}
+
+// encryptedValue returns the value of the first !encrypted scalar in the config file.
+func encryptedValue(t *testing.T, c *Config) string {
+	t.Helper()
+	document, err := c.Read()
+	if err != nil {
+		t.Fatalf("unable to read the config: %v", err)
+	}
+	var find func(*yaml.Node) string
+	find = func(node *yaml.Node) string {
+		if node.Tag == secrets.EncryptedTag {
+			return node.Value
+		}
+		for _, child := range node.Content {
+			if value := find(child); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	value := find(document)
+	if value == "" {
+		t.Fatal("the config has no encrypted value")
+	}
+	return value
+}
+
+func TestRekeyKeepsThePlaintext(t *testing.T) {
+	from, to := secrets.NewCipher("old"), secrets.NewCipher("new")
+	encrypted, err := from.Encrypt("ghp_secret")
+	if err != nil {
+		t.Fatalf("Encrypt returned an error: %v", err)
+	}
+	c := newTestConfig(t, "config.yml", "GithubCLI:\n  settings:\n    token: !encrypted "+encrypted+"\n")
+
+	count, err := c.Rekey(from, to)
+	if err != nil || count != 1 {
+		t.Fatalf("got %d values and error %v, want 1 re-encrypted", count, err)
+	}
+	rekeyed := encryptedValue(t, c)
+	if rekeyed == encrypted {
+		t.Fatal("the ciphertext didn't change")
+	}
+	if _, err = from.Decrypt(rekeyed); err == nil {
+		t.Fatal("the old key still decrypts the value")
+	}
+	if plaintext, decryptErr := to.Decrypt(rekeyed); decryptErr != nil || plaintext != "ghp_secret" {
+		t.Fatalf("got %q and error %v, want the same plaintext under the new key", plaintext, decryptErr)
+	}
+}
+
+func TestSetModuleSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"existing settings", "# machines\nGitHub:\n  settings:\n    token: !encrypted gasible:v1:abc\nGenericPackageManager:\n  enabled: true\n  settings:\n    manager: dnf\n    packages: [git]\n"},
+		{"null module", "GenericPackageManager:\nGitHub:\n  enabled: true\n"},
+		{"null settings", "GenericPackageManager:\n  enabled: true\n  settings: ~\n"},
+		{"empty file", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t, "config.yml", tt.contents)
+			settings := map[string]interface{}{"packages": []string{"gcc", "git"}, "managers": map[string][]string{"brew": {"jq"}}}
+			if err := c.SetModuleSettings("GenericPackageManager", settings); err != nil {
+				t.Fatalf("SetModuleSettings returned an error: %v", err)
+			}
+			written, err := os.ReadFile(c.FullPath)
+			if err != nil {
+				t.Fatalf("unable to read the config: %v", err)
+			}
+			var parsed map[string]struct {
+				Settings map[string]interface{} `yaml:"settings"`
+			}
+			if err = yaml.Unmarshal(written, &parsed); err != nil {
+				t.Fatalf("the config doesn't parse any more: %v\n%s", err, written)
+			}
+			got := parsed["GenericPackageManager"].Settings
+			if !reflect.DeepEqual(got["packages"], []interface{}{"gcc", "git"}) ||
+				!reflect.DeepEqual(got["managers"], map[string]interface{}{"brew": []interface{}{"jq"}}) {
+				t.Fatalf("got settings %v, want the packages and managers set:\n%s", got, written)
+			}
+			for _, kept := range []string{"# machines", "manager: dnf", "enabled: true", "token: !encrypted gasible:v1:abc"} {
+				if strings.Contains(tt.contents, kept) && !strings.Contains(string(written), kept) {
+					t.Errorf("lost %q:\n%s", kept, written)
+				}
+			}
+		})
+	}
+}
+
+func TestSetModuleSettingsRefusesToOverwrite(t *testing.T) {
+	for _, contents := range []string{"GenericPackageManager: disabled\n", "GenericPackageManager:\n  settings: [git]\n"} {
+		c := newTestConfig(t, "config.yml", contents)
+		if err := c.SetModuleSettings("GenericPackageManager", map[string]interface{}{"packages": []string{"git"}}); err == nil {
+			t.Errorf("got no error setting settings in %q", contents)
+		}
+		if written, _ := os.ReadFile(c.FullPath); string(written) != contents {
+			t.Errorf("got %q written, want the config left as it was", written)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/download"
+	"path/filepath"
+	"time"
+)
+
+// downloadsKey is the top level key in the config file that holds the DownloadsConfig.
+const downloadsKey = "downloads"
+
+// cacheDir is the directory under the config directory that downloads are cached in.
+const cacheDir = "cache"
+
+// DownloadsConfig is the downloads section of the config file.
+// An empty proxy means the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+type DownloadsConfig struct {
+	Proxy   string        `yaml:"proxy"`
+	Retries int           `yaml:"retries"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// defaultDownloadsConfig retries a failed download 3 times and gives each attempt 2 minutes.
+func defaultDownloadsConfig() DownloadsConfig {
+	return DownloadsConfig{
+		Retries: 3,
+		Timeout: 2 * time.Minute,
+	}
+}
+
+// CacheDir returns where downloads are cached, $HOME/.gas/cache.
+func (a *App) CacheDir() (string, error) {
+	homeDir, err := a.FS.HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configDir, cacheDir), nil
+}
+
+// Downloader returns the download service modules fetch files with, set up from the downloads config.
+func (a *App) Downloader() (*download.Downloader, error) {
+	a.downloaderOnce.Do(func() {
+		dir, err := a.CacheDir()
+		if err != nil {
+			a.downloaderErr = internal.ErrorAs("App.Downloader", err)
+			return
+		}
+		a.downloader, a.downloaderErr = download.New(a.FS, dir, download.Options{
+			Proxy:   a.Config.Downloads.Proxy,
+			Retries: &a.Config.Downloads.Retries,
+			Timeout: a.Config.Downloads.Timeout,
+		})
+	})
+	return a.downloader, a.downloaderErr
+}
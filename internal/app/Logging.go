@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// logDir is the directory under the config directory that run logs are written to.
+const logDir = "logs"
+
+// logFilePrefix is how run logs are named, followed by the time the run started.
+const logFilePrefix = "gasible-"
+
+// loggingKey is the top level key in the config file that holds the LoggingConfig.
+const loggingKey = "logging"
+
+// CommandOutputMsg is the message used to log each line of command output.
+// These go to the run log only, the terminal already shows command output as it runs.
+const CommandOutputMsg = "command output"
+
+// LoggingConfig is the logging section of the config file.
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	Dir    string `yaml:"dir"`
+	Keep   int    `yaml:"keep"`
+}
+
+// LogFlags are the logging options given on the command line, they win over the config file.
+type LogFlags struct {
+	Quiet  bool
+	Level  string
+	Format string
+}
+
+// defaultLoggingConfig logs at info level as text and keeps the last 10 run logs in $HOME/.gas/logs.
+func defaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{
+		Level:  "info",
+		Format: "text",
+		Keep:   10,
+	}
+}
+
+// SetupLogging builds the default slog logger from the command line flags and the config file:
+// a console logger on stderr at the chosen level, and a run log in the log directory that records everything.
+func (a *App) SetupLogging() error {
+	// A config we can't read is reported once logging is up, the command itself will fail on it too.
+	loadErr := a.Config.loadAppSettings()
+	defer func() {
+		if loadErr != nil {
+			slog.Warn("unable to read logging and download settings from config, using defaults", "error", loadErr)
+		}
+	}()
+
+	level, err := a.Config.logLevel()
+	if err != nil {
+		return internal.ErrorAs("SetupLogging", err)
+	}
+	format := a.Config.Logging.Format
+	if a.Config.LogFlags.Format != "" {
+		format = a.Config.LogFlags.Format
+	}
+
+	console, err := newLogHandler(os.Stderr, format, level)
+	if err != nil {
+		return internal.ErrorAs("SetupLogging", err)
+	}
+	handlers := []slog.Handler{withoutCommandOutput{console}}
+
+	if a.Config.Logging.Keep < 1 {
+		slog.SetDefault(slog.New(multiHandler(handlers)))
+		return nil
+	}
+
+	dir, err := a.Config.logDir()
+	if err != nil {
+		return internal.ErrorAs("SetupLogging", err)
+	}
+	runLog, err := a.openRunLog(dir)
+	if err != nil {
+		return internal.ErrorAs("SetupLogging", err)
+	}
+	fileHandler, err := newLogHandler(runLog, format, slog.LevelDebug)
+	if err != nil {
+		return internal.ErrorAs("SetupLogging", err)
+	}
+	slog.SetDefault(slog.New(append(multiHandler(handlers), fileHandler)))
+
+	if pruneErr := pruneRunLogs(a.FS, dir, a.Config.Logging.Keep); pruneErr != nil {
+		slog.Warn("unable to remove old run logs", "dir", dir, "error", pruneErr)
+	}
+	return nil
+}
+
+// logLevel works out the console log level, --log-level wins over -v and -q, which win over the config file.
+func (c *Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	switch {
+	case c.LogFlags.Level != "":
+		if err := level.UnmarshalText([]byte(c.LogFlags.Level)); err != nil {
+			return level, err
+		}
+	case c.Verbose:
+		level = slog.LevelDebug
+	case c.LogFlags.Quiet:
+		level = slog.LevelWarn
+	default:
+		if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+			return level, fmt.Errorf("%s.level: %w", loggingKey, err)
+		}
+	}
+	return level, nil
+}
+
+// openRunLog creates the log file for this run in dir.
+func (a *App) openRunLog(dir string) (io.Writer, error) {
+	if err := a.FS.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	name := logFilePrefix + a.RunID + ".log"
+	file, err := a.FS.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.runLog = file
+	return file, nil
+}
+
+// logDir returns the configured log directory, $HOME/.gas/logs by default.
+func (c *Config) logDir() (string, error) {
+	if c.Logging.Dir != "" {
+		return filesystem.ExpandHome(c.fs, c.Logging.Dir)
+	}
+	homeDir, err := c.fs.HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configDir, logDir), nil
+}
+
+// pruneRunLogs removes all but the newest keep run logs, the timestamped names sort oldest first.
+func pruneRunLogs(fsys filesystem.FS, dir string, keep int) error {
+	logs, err := fsys.Glob(filepath.Join(dir, logFilePrefix+"*.log"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(logs)
+	var errs []error
+	for len(logs) > keep {
+		errs = append(errs, fsys.Remove(logs[0]))
+		logs = logs[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// newLogHandler returns a text or JSON slog handler.
+func newLogHandler(out io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.NewTextHandler(out, options), nil
+	case "json":
+		return slog.NewJSONHandler(out, options), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %q, use text or json", format)
+}
+
+// multiHandler sends each record to every handler that wants it.
+type multiHandler []slog.Handler
+
+// Enabled reports whether any of the handlers want records at this level.
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range m {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle passes the record on to each handler that is enabled for it.
+func (m multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range m {
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithAttrs returns a multiHandler whose handlers all have the attributes.
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, handler := range m {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+// WithGroup returns a multiHandler whose handlers all have the group.
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, handler := range m {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
+
+// withoutCommandOutput keeps command output lines off the console, where they are already being shown.
+type withoutCommandOutput struct {
+	slog.Handler
+}
+
+// Handle drops command output records and passes everything else on.
+func (h withoutCommandOutput) Handle(ctx context.Context, record slog.Record) error {
+	if record.Message == CommandOutputMsg {
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs keeps the filter in place on the derived handler.
+func (h withoutCommandOutput) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return withoutCommandOutput{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the filter in place on the derived handler.
+func (h withoutCommandOutput) WithGroup(name string) slog.Handler {
+	return withoutCommandOutput{h.Handler.WithGroup(name)}
+}
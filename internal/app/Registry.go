@@ -3,10 +3,10 @@ package app
 import (
 	"fmt"
 	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/secrets"
 	"gopkg.in/yaml.v3"
-	"log"
-	"os"
-	"path/filepath"
+	"log/slog"
+	"time"
 )
 
 // registry holds Modules and their respective dependencies.
@@ -16,13 +16,15 @@ import (
 type registry struct {
 	Modules     map[string]Module
 	SettingsMap map[string]interface{}
+	config      *Config
 }
 
-// newRegistry returns a pointer to a registry.
-func newRegistry() *registry {
+// newRegistry returns a pointer to a registry that reads and writes the given config.
+func newRegistry(config *Config) *registry {
 	return &registry{
 		Modules:     make(map[string]Module),
 		SettingsMap: make(map[string]interface{}),
+		config:      config,
 	}
 }
 
@@ -36,61 +38,58 @@ func (r *registry) GetModule(mod string) Module {
 	return r.Modules[mod]
 }
 
-// TODO abstract write/read out to Config.go
-
-// WriteRegistryConfigsToYAML is for writing the config YAML.
-func (r *registry) WriteRegistryConfigsToYAML() error {
+// WriteRegistryConfigs is for writing the config file, in whichever format the config path uses.
+func (r *registry) WriteRegistryConfigs() error {
 	r.updateSettingsMap()
 
-	settingsYAML, err := yaml.Marshal(r.SettingsMap)
-	if err != nil {
-		return err
-	}
-
-	filePath, err := createAndOrGetConfigPath()
+	var document yaml.Node
+	err := document.Encode(r.SettingsMap)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(filePath, settingsYAML, 0600)
+	err = r.config.Write(&document)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Config successfully generated to %s, have fun!\n", filePath)
+	slog.Info("config successfully generated, have fun!", "path", r.config.FullPath)
 	return nil
 }
 
-// readRegistryConfigsFromYAML is for reading the config YAML.
-func (r *registry) readRegistryConfigsFromYAML() error {
-	filePath, err := createAndOrGetConfigPath()
-	if err != nil {
-		return fmt.Errorf("ReadRegistryConfigsFromYAML error: %w", err)
+// readRegistryConfigs is for reading the config file and decrypting any encrypted values.
+func (r *registry) readRegistryConfigs() error {
+	document, readErr := r.config.Read()
+	if readErr != nil {
+		return fmt.Errorf("readRegistryConfigs error: %w", readErr)
+	}
+	if document == nil {
+		return nil // empty config
 	}
 
-	fileContents, readErr := os.ReadFile(filepath.Clean(filePath))
-	if readErr != nil {
-		return fmt.Errorf("ReadRegistryConfigsFromYAML error: %w", readErr)
+	_, decryptErr := secrets.DecryptNode(document, secrets.LazyCipher(r.config.PassphraseSource(""), r.config.fs))
+	if decryptErr != nil {
+		return fmt.Errorf("readRegistryConfigs error: %w", decryptErr)
 	}
 
-	unmarshalErr := yaml.Unmarshal(fileContents, &r.SettingsMap)
-	if unmarshalErr != nil {
-		return fmt.Errorf("ReadRegistryConfigsFromYAML error: %w", unmarshalErr)
+	decodeErr := document.Decode(&r.SettingsMap)
+	if decodeErr != nil {
+		return fmt.Errorf("readRegistryConfigs error: %w", decodeErr)
 	}
 
 	return nil
 }
 
-// ReadAndSetRegistryConfigsFromYAML is for reading the config YAML and marshaling it to the modules' config.
-func (r *registry) ReadAndSetRegistryConfigsFromYAML() error {
-	readErr := r.readRegistryConfigsFromYAML()
+// ReadAndSetRegistryConfigs is for reading the config file and marshaling it to the modules' config.
+func (r *registry) ReadAndSetRegistryConfigs() error {
+	readErr := r.readRegistryConfigs()
 	if readErr != nil {
-		return fmt.Errorf("ReadAndSetRegistryConfigsFromYAML error: %w", readErr)
+		return fmt.Errorf("ReadAndSetRegistryConfigs error: %w", readErr)
 	}
 	for moduleName, module := range r.Modules {
 		setErr := r.setCurrent(moduleName, module)
 		if setErr != nil {
-			return fmt.Errorf("ReadAndSetRegistryConfigsFromYAML error: %w", setErr)
+			return fmt.Errorf("ReadAndSetRegistryConfigs error: %w", setErr)
 		}
 	}
 	return nil
@@ -120,17 +119,17 @@ func (r *registry) setCurrent(moduleName string, module Module) error {
 
 // RunSetup runs the Setup command on all modules.
 func (r *registry) RunSetup() (err error) {
-	return r.execute(Module.Setup)
+	return r.execute("setup", Module.Setup)
 }
 
 // RunUpdate runs the Update command on all modules.
 func (r *registry) RunUpdate() (err error) {
-	return r.execute(Module.Update)
+	return r.execute("update", Module.Update)
 }
 
 // RunTeardown runs the TearDown command on all modules.
 func (r *registry) RunTeardown() (err error) {
-	return r.execute(Module.TearDown)
+	return r.execute("teardown", Module.TearDown)
 }
 
 // updateSettingsMap is used to set the settings of each module based on the YAML config.
@@ -138,21 +137,28 @@ func (r *registry) updateSettingsMap() {
 	for moduleName, mod := range r.Modules {
 		r.SettingsMap[moduleName] = mod.Config()
 	}
+	r.SettingsMap[loggingKey] = r.config.Logging
+	r.SettingsMap[downloadsKey] = r.config.Downloads
 }
 
 // moduleAction is a method on a module.
 type moduleAction func(Module) error
 
 // execute executes the action on each module.
-func (r *registry) execute(action moduleAction) (err error) {
-	for _, module := range r.Modules {
+func (r *registry) execute(name string, action moduleAction) (err error) {
+	for moduleName, module := range r.Modules {
 		if !module.Config().Enabled {
 			return nil
 		}
+		logger := slog.With("module", moduleName, "action", name)
+		logger.Debug("running module action")
+		started := time.Now()
 		err = action(module)
 		if err != nil {
+			logger.Error("module action failed", "error", err)
 			return internal.ErrorAs("registry.execute", err)
 		}
+		logger.Info("module action finished", "duration", time.Since(started).Round(time.Millisecond))
 	}
 	return
 }
@@ -0,0 +1,28 @@
+package app
+
+import (
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/state"
+	"path/filepath"
+)
+
+// stateDir is the directory under the config directory that module state is kept in.
+const stateDir = "state"
+
+// StateDir returns where module state is kept, $HOME/.gas/state.
+func (a *App) StateDir() (string, error) {
+	homeDir, err := a.FS.HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, configDir, stateDir), nil
+}
+
+// State returns the store modules record what they changed on the machine in.
+func (a *App) State() (*state.Store, error) {
+	dir, err := a.StateDir()
+	if err != nil {
+		return nil, internal.ErrorAs("App.State", err)
+	}
+	return state.New(a.FS, dir), nil
+}
@@ -0,0 +1,304 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultRetries is how many times a failed download is retried when no other number is configured.
+const defaultRetries = 3
+
+// defaultTimeout is how long a single attempt may take when no other timeout is configured.
+const defaultTimeout = 2 * time.Minute
+
+// defaultBackoff is how long we wait before the first retry, doubling for each one after.
+const defaultBackoff = time.Second
+
+// maxDownloadBytes is the most we will download, anything bigger is not something a module should fetch into memory.
+const maxDownloadBytes = 256 << 20
+
+// metadataExtension is added to a cached file's name for what we know about it, such as its ETag.
+const metadataExtension = ".json"
+
+// ErrChecksum is returned when a download doesn't match its expected SHA-256.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ErrFingerprint is returned when a downloaded key isn't the one with the expected fingerprint.
+var ErrFingerprint = errors.New("key fingerprint mismatch")
+
+// Options configure a Downloader, the zero value uses the defaults and the proxy from the environment.
+type Options struct {
+	// Proxy is the proxy URL to use, when empty HTTPS_PROXY, HTTP_PROXY and NO_PROXY are honored.
+	Proxy string
+	// Retries is how many times a failed attempt is retried, defaultRetries when nil so that 0 can turn retrying off.
+	Retries *int
+	// Timeout is how long a single attempt may take.
+	Timeout time.Duration
+}
+
+// Request is a file to download and how to check that it is the file we meant.
+type Request struct {
+	URL string
+	// SHA256 is the expected hex SHA-256 of the file, it isn't checked when empty.
+	SHA256 string
+	// Signature is a detached signature to check the file against, it isn't checked when nil.
+	Signature *Signature
+	// Fingerprint is the expected fingerprint of the primary key, for a file that is an OpenPGP public key,
+	// armored or not. It isn't checked when empty.
+	Fingerprint string
+	// NoCache skips the cache, the download isn't cached either.
+	NoCache bool
+}
+
+// Downloader fetches files over HTTP for modules. Failed attempts are retried, files are cached so later runs
+// can revalidate them instead of downloading them again, and they can be verified by checksum and signature.
+type Downloader struct {
+	Client  *http.Client
+	Retries int
+	// Backoff is how long to wait before the first retry, doubling for each one after.
+	Backoff  time.Duration
+	fsys     filesystem.FS
+	cacheDir string
+}
+
+// cacheMetadata is what we remember about a cached file, so we can ask the server whether it has changed.
+type cacheMetadata struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// StatusError is returned when the server answers with a status we can't use.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+// Error describes the status and what was requested.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryable reports whether the same request might succeed later.
+func (e *StatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// New returns a pointer to a Downloader that caches into cacheDir through fsys.
+func New(fsys filesystem.FS, cacheDir string, options Options) (*Downloader, error) {
+	proxy := http.ProxyFromEnvironment
+	if options.Proxy != "" {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, internal.ErrorAs("download.New", fmt.Errorf("proxy: %w", err))
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	retries := defaultRetries
+	if options.Retries != nil {
+		retries = max(*options.Retries, 0)
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return &Downloader{
+		Client:   &http.Client{Transport: transport, Timeout: timeout},
+		Retries:  retries,
+		Backoff:  defaultBackoff,
+		fsys:     fsys,
+		cacheDir: cacheDir,
+	}, nil
+}
+
+// Get downloads the file, or revalidates our cached copy of it, then verifies it.
+// A file that fails verification is dropped from the cache, so the next run downloads it again.
+func (d *Downloader) Get(request Request) ([]byte, error) {
+	// A cached file matching the checksum we want can't have changed, so there is no need to ask.
+	if !request.NoCache && request.SHA256 != "" {
+		if cached, _ := d.cached(request.URL); cached != nil && d.verify(request, cached) == nil {
+			slog.Debug("using cached download", "url", request.URL)
+			return cached, nil
+		}
+	}
+
+	data, err := d.fetch(request.URL, request.NoCache)
+	if err != nil {
+		return nil, internal.ErrorAs("Downloader.Get", err)
+	}
+
+	if verifyErr := d.verify(request, data); verifyErr != nil {
+		if !request.NoCache {
+			d.forget(request.URL)
+		}
+		return nil, internal.ErrorAs("Downloader.Get", fmt.Errorf("%s: %w", request.URL, verifyErr))
+	}
+	return data, nil
+}
+
+// verify checks the file's checksum and signature, whichever of them the request asks for.
+func (d *Downloader) verify(request Request, data []byte) error {
+	if request.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, request.SHA256) {
+			return fmt.Errorf("%w: got sha256 %s, want %s", ErrChecksum, actual, request.SHA256)
+		}
+	}
+	if request.Fingerprint != "" {
+		if err := verifyFingerprint(data, request.Fingerprint); err != nil {
+			return err
+		}
+	}
+	if request.Signature != nil {
+		signature, err := d.fetch(request.Signature.URL, true)
+		if err != nil {
+			return fmt.Errorf("signature: %w", err)
+		}
+		return request.Signature.Verify(data, signature)
+	}
+	return nil
+}
+
+// fetch returns the body at rawURL, from the cache when the server says it hasn't changed.
+func (d *Downloader) fetch(rawURL string, noCache bool) ([]byte, error) {
+	var cached []byte
+	var metadata cacheMetadata
+	if !noCache {
+		cached, metadata = d.cached(rawURL)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		if attempt > 0 {
+			wait := d.Backoff << (attempt - 1)
+			slog.Debug("retrying download", "url", rawURL, "attempt", attempt, "wait", wait, "error", lastErr)
+			time.Sleep(wait)
+		}
+
+		response, err := d.request(rawURL, cached, metadata)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		data, err := d.read(response, cached)
+		if err == nil {
+			if !noCache && response.StatusCode == http.StatusOK {
+				d.store(rawURL, data, response)
+			}
+			return data, nil
+		}
+		lastErr = err
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.retryable() {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// request sends a GET, conditional on our cached copy's ETag or modification time when we have one.
+func (d *Downloader) request(rawURL string, cached []byte, metadata cacheMetadata) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "gasible")
+	if cached != nil {
+		if metadata.ETag != "" {
+			request.Header.Set("If-None-Match", metadata.ETag)
+		}
+		if metadata.LastModified != "" {
+			request.Header.Set("If-Modified-Since", metadata.LastModified)
+		}
+	}
+	slog.Debug("downloading", "url", rawURL, "cached", cached != nil)
+	return d.Client.Do(request)
+}
+
+// read returns the response body, or our cached copy if the server says it hasn't changed.
+func (d *Downloader) read(response *http.Response, cached []byte) ([]byte, error) {
+	defer func() { _ = response.Body.Close() }()
+	switch {
+	case response.StatusCode == http.StatusNotModified && cached != nil:
+		slog.Debug("using cached download", "url", response.Request.URL.String())
+		return cached, nil
+	case response.StatusCode != http.StatusOK:
+		return nil, &StatusError{URL: response.Request.URL.String(), StatusCode: response.StatusCode}
+	}
+
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, io.LimitReader(response.Body, maxDownloadBytes+1)); err != nil {
+		return nil, err
+	}
+	if body.Len() > maxDownloadBytes {
+		return nil, fmt.Errorf("GET %s: larger than %d bytes", response.Request.URL, maxDownloadBytes)
+	}
+	return body.Bytes(), nil
+}
+
+// cachePath returns where the file at rawURL is cached, named by the URL's hash so any URL makes a safe name.
+func (d *Downloader) cachePath(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(d.cacheDir, hex.EncodeToString(sum[:]))
+}
+
+// cached returns our copy of the file at rawURL and what we know about it, nil if we don't have one.
+func (d *Downloader) cached(rawURL string) ([]byte, cacheMetadata) {
+	var metadata cacheMetadata
+	path := d.cachePath(rawURL)
+	rawMetadata, err := d.fsys.ReadFile(path + metadataExtension)
+	if err != nil || json.Unmarshal(rawMetadata, &metadata) != nil || metadata.URL != rawURL {
+		return nil, cacheMetadata{}
+	}
+	data, err := d.fsys.ReadFile(path)
+	if err != nil {
+		return nil, cacheMetadata{}
+	}
+	return data, metadata
+}
+
+// store caches the file, a cache we can't write to only costs us a download next time.
+func (d *Downloader) store(rawURL string, data []byte, response *http.Response) {
+	metadata := cacheMetadata{
+		URL:          rawURL,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}
+	rawMetadata, err := json.Marshal(metadata)
+	if err == nil {
+		err = d.fsys.MkdirAll(d.cacheDir, 0750)
+	}
+	path := d.cachePath(rawURL)
+	if err == nil {
+		err = d.fsys.WriteFile(path, data, 0600)
+	}
+	if err == nil {
+		err = d.fsys.WriteFile(path+metadataExtension, rawMetadata, 0600)
+	}
+	if err != nil {
+		slog.Warn("unable to cache download", "url", rawURL, "error", err)
+	}
+}
+
+// forget removes the file at rawURL from the cache.
+func (d *Downloader) forget(rawURL string) {
+	path := d.cachePath(rawURL)
+	_ = d.fsys.Remove(path + metadataExtension)
+	_ = d.fsys.Remove(path)
+}
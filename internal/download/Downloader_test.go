@@ -0,0 +1,283 @@
+package download
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // matches what the downloader verifies with
+	"golang.org/x/crypto/openpgp/armor"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestDownloader returns a pointer to a Downloader caching into a sandbox, retrying without waiting.
+func newTestDownloader(t *testing.T, options Options) *Downloader {
+	t.Helper()
+	fsys, err := filesystem.Sandbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create sandbox: %v", err)
+	}
+	home, _ := fsys.HomeDir()
+	downloader, err := New(fsys, filepath.Join(home, ".gas", "cache"), options)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	downloader.Backoff = time.Millisecond
+	return downloader
+}
+
+func TestGetRetriesServerErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("keyring"))
+	}))
+	defer server.Close()
+
+	data, err := newTestDownloader(t, Options{}).Get(Request{URL: server.URL, NoCache: true})
+	if err != nil || string(data) != "keyring" {
+		t.Fatalf("got %q and error %v, want the keyring", data, err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("got %d requests, want 3", requests.Load())
+	}
+}
+
+func TestGetDoesNotRetryClientErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	_, err := newTestDownloader(t, Options{}).Get(Request{URL: server.URL})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("got error %v, want a 404 StatusError", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("got %d requests, want 1", requests.Load())
+	}
+}
+
+func TestGetRevalidatesCache(t *testing.T) {
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("keyring"))
+	}))
+	defer server.Close()
+
+	downloader := newTestDownloader(t, Options{})
+	for i := 0; i < 2; i++ {
+		data, err := downloader.Get(Request{URL: server.URL})
+		if err != nil || string(data) != "keyring" {
+			t.Fatalf("download %d: got %q and error %v, want the keyring", i, data, err)
+		}
+	}
+	if downloads.Load() != 1 {
+		t.Errorf("got %d full downloads, want 1 and a revalidation", downloads.Load())
+	}
+}
+
+func TestGetVerifiesChecksum(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("keyring"))
+	}))
+	defer server.Close()
+	sum := sha256.Sum256([]byte("keyring"))
+	downloader := newTestDownloader(t, Options{})
+
+	if _, err := downloader.Get(Request{URL: server.URL, SHA256: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	}
+	if _, err := downloader.Get(Request{URL: server.URL, SHA256: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatalf("Get returned an error from the cache: %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("got %d requests, want the cached copy to be used without asking", requests.Load())
+	}
+
+	_, err := downloader.Get(Request{URL: server.URL, SHA256: "00" + hex.EncodeToString(sum[1:])})
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("got error %v, want ErrChecksum", err)
+	}
+	if cached, _ := downloader.cached(server.URL); cached != nil {
+		t.Error("a download that failed verification was left in the cache")
+	}
+}
+
+func TestGetUsesProxy(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+		_, _ = w.Write([]byte("keyring"))
+	}))
+	defer proxy.Close()
+
+	data, err := newTestDownloader(t, Options{Proxy: proxy.URL}).Get(Request{URL: "http://packages.example/keyring.gpg"})
+	if err != nil || string(data) != "keyring" {
+		t.Fatalf("got %q and error %v, want the keyring", data, err)
+	}
+	if proxied.Load() != "http://packages.example/keyring.gpg" {
+		t.Errorf("proxy got %v, want the request for the keyring", proxied.Load())
+	}
+}
+
+// serveSigned serves the file at /file and its signature at /file.sig.
+func serveSigned(t *testing.T, file []byte, signature []byte) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(file) })
+	mux.HandleFunc("/file.sig", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(signature) })
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestGetVerifiesGPGSignature(t *testing.T) {
+	entity, err := openpgp.NewEntity("Gasible Test", "", "test@gasible.invalid", nil)
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	var publicKey bytes.Buffer
+	keyWriter, _ := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err = entity.Serialize(keyWriter); err != nil {
+		t.Fatalf("unable to serialize key: %v", err)
+	}
+	_ = keyWriter.Close()
+	var signature bytes.Buffer
+	if err = openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader([]byte("release")), nil); err != nil {
+		t.Fatalf("unable to sign: %v", err)
+	}
+
+	url := serveSigned(t, []byte("release"), signature.Bytes())
+	request := Request{URL: url + "/file", NoCache: true, Signature: &Signature{Type: GPG, URL: url + "/file.sig", PublicKey: publicKey.String()}}
+	if _, err = newTestDownloader(t, Options{}).Get(request); err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	}
+
+	url = serveSigned(t, []byte("tampered"), signature.Bytes())
+	request.URL, request.Signature.URL = url+"/file", url+"/file.sig"
+	if _, err = newTestDownloader(t, Options{}).Get(request); !errors.Is(err, ErrSignature) {
+		t.Fatalf("got error %v, want ErrSignature", err)
+	}
+}
+
+func TestGetVerifiesKeyFingerprint(t *testing.T) {
+	entity, err := openpgp.NewEntity("Gasible Test", "", "test@gasible.invalid", nil)
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	var binaryKey, armoredKey bytes.Buffer
+	if err = entity.Serialize(&binaryKey); err != nil {
+		t.Fatalf("unable to serialize key: %v", err)
+	}
+	keyWriter, _ := armor.Encode(&armoredKey, openpgp.PublicKeyType, nil)
+	if err = entity.Serialize(keyWriter); err != nil {
+		t.Fatalf("unable to serialize key: %v", err)
+	}
+	_ = keyWriter.Close()
+	fingerprint := hex.EncodeToString(entity.PrimaryKey.Fingerprint[:])
+
+	for name, key := range map[string][]byte{"binary": binaryKey.Bytes(), "armored": armoredKey.Bytes()} {
+		url := serveSigned(t, key, nil)
+		request := Request{URL: url + "/file", NoCache: true, Fingerprint: fingerprint}
+		if _, err = newTestDownloader(t, Options{}).Get(request); err != nil {
+			t.Fatalf("%s: Get returned an error: %v", name, err)
+		}
+		request.Fingerprint = strings.Repeat("0", len(fingerprint))
+		if _, err = newTestDownloader(t, Options{}).Get(request); !errors.Is(err, ErrFingerprint) {
+			t.Fatalf("%s: got error %v, want ErrFingerprint for another key", name, err)
+		}
+	}
+	url := serveSigned(t, []byte("not a key"), nil)
+	if _, err = newTestDownloader(t, Options{}).Get(Request{URL: url + "/file", NoCache: true, Fingerprint: fingerprint}); !errors.Is(err, ErrFingerprint) {
+		t.Fatalf("got error %v, want ErrFingerprint for a file that isn't a key", err)
+	}
+}
+
+// minisignFixture signs file the way minisign does, returning the public key and the signature file.
+func minisignFixture(t *testing.T, file []byte, prehashed bool) (string, []byte) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	algorithm, message := minisignAlgorithm, file
+	if prehashed {
+		hashed := blake2b.Sum512(file)
+		algorithm, message = minisignHashedAlgorithm, hashed[:]
+	}
+	sig := ed25519.Sign(privateKey, message)
+	trustedComment := "timestamp:1700000000\tfile:release"
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig...), trustedComment...))
+
+	key := "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(minisignAlgorithm), keyID...), publicKey...)) + "\n"
+	signature := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), sig...)) + "\n" +
+		trustedCommentPrefix + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n"
+	return key, []byte(signature)
+}
+
+func TestGetVerifiesMinisignSignature(t *testing.T) {
+	for _, prehashed := range []bool{false, true} {
+		publicKey, signature := minisignFixture(t, []byte("release"), prehashed)
+		url := serveSigned(t, []byte("release"), signature)
+		request := Request{URL: url + "/file", NoCache: true, Signature: &Signature{Type: Minisign, URL: url + "/file.sig", PublicKey: publicKey}}
+		if _, err := newTestDownloader(t, Options{}).Get(request); err != nil {
+			t.Fatalf("prehashed %t: Get returned an error: %v", prehashed, err)
+		}
+
+		tampered := bytes.Replace(signature, []byte("file:release"), []byte("file:evil"), 1)
+		url = serveSigned(t, []byte("release"), tampered)
+		request.URL, request.Signature.URL = url+"/file", url+"/file.sig"
+		if _, err := newTestDownloader(t, Options{}).Get(request); !errors.Is(err, ErrSignature) {
+			t.Fatalf("prehashed %t: got error %v for a tampered trusted comment, want ErrSignature", prehashed, err)
+		}
+	}
+}
+
+func TestGetZeroRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	retries := 0
+	_, err := newTestDownloader(t, Options{Retries: &retries}).Get(Request{URL: server.URL, NoCache: true})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got error %v, want a 502 StatusError", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("got %d requests, want 1", requests.Load())
+	}
+}
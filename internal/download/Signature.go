@@ -0,0 +1,156 @@
+package download
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // still the only pure Go OpenPGP in x/crypto
+	"strings"
+)
+
+// SignatureType is the kind of detached signature a download is checked against.
+type SignatureType string
+
+const (
+	// GPG is an OpenPGP detached signature, armored or binary, checked against an armored public key.
+	GPG SignatureType = "gpg"
+	// Minisign is a minisign signature, checked against a minisign public key.
+	Minisign SignatureType = "minisign"
+)
+
+// minisign's algorithms, a signature of the file itself or of its BLAKE2b-512 hash.
+const (
+	minisignAlgorithm       = "Ed"
+	minisignHashedAlgorithm = "ED"
+)
+
+// minisign's comment line prefixes.
+const (
+	untrustedCommentPrefix = "untrusted comment:"
+	trustedCommentPrefix   = "trusted comment: "
+)
+
+// ErrSignature is returned when a download's signature doesn't check out.
+var ErrSignature = errors.New("bad signature")
+
+// Signature is a detached signature published alongside a download.
+type Signature struct {
+	Type SignatureType
+	// URL is where the signature is downloaded from.
+	URL string
+	// PublicKey is the key itself, not a path: an armored OpenPGP public key block or a minisign public key.
+	PublicKey string
+}
+
+// Verify checks data against the detached signature.
+func (s *Signature) Verify(data []byte, signature []byte) error {
+	switch s.Type {
+	case GPG:
+		return verifyGPG(s.PublicKey, data, signature)
+	case Minisign:
+		return verifyMinisign(s.PublicKey, data, signature)
+	default:
+		return fmt.Errorf("unsupported signature type %q, use %s or %s", s.Type, GPG, Minisign)
+	}
+}
+
+// verifyGPG checks an OpenPGP detached signature, armored or not.
+func verifyGPG(armoredKey string, data []byte, signature []byte) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil {
+		return fmt.Errorf("gpg public key: %w", err)
+	}
+	if bytes.Contains(signature, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(signature))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(signature))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: gpg: %v", ErrSignature, err)
+	}
+	return nil
+}
+
+// verifyFingerprint checks that data is an OpenPGP public key, armored or not, with only the expected primary key.
+// The fingerprint can be written with spaces, in either case.
+func verifyFingerprint(data []byte, fingerprint string) error {
+	read := openpgp.ReadKeyRing
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		read = openpgp.ReadArmoredKeyRing
+	}
+	keyring, err := read(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFingerprint, err)
+	}
+	want := strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	for _, entity := range keyring {
+		if got := fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint); got != want {
+			return fmt.Errorf("%w: got %s, want %s", ErrFingerprint, got, want)
+		}
+	}
+	if len(keyring) == 0 {
+		return fmt.Errorf("%w: no keys", ErrFingerprint)
+	}
+	return nil
+}
+
+// verifyMinisign checks a minisign signature, including its trusted comment.
+func verifyMinisign(publicKey string, data []byte, signature []byte) error {
+	rawKey, err := minisignLine(publicKey)
+	if err != nil {
+		return fmt.Errorf("minisign public key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(rawKey)
+	if err != nil || len(key) != 2+8+ed25519.PublicKeySize || string(key[:2]) != minisignAlgorithm {
+		return errors.New("minisign public key: not an Ed25519 minisign key")
+	}
+	keyID, edKey := key[2:10], ed25519.PublicKey(key[10:])
+
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], untrustedCommentPrefix) || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return fmt.Errorf("%w: minisign: malformed signature file", ErrSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("%w: minisign: malformed signature", ErrSignature)
+	}
+	algorithm, sigKeyID, edSig := string(sig[:2]), sig[2:10], sig[10:]
+	if !bytes.Equal(sigKeyID, keyID) {
+		return fmt.Errorf("%w: minisign: signed by key %X, not %X", ErrSignature, sigKeyID, keyID)
+	}
+
+	message := data
+	switch algorithm {
+	case minisignAlgorithm:
+	case minisignHashedAlgorithm:
+		hashed := blake2b.Sum512(data)
+		message = hashed[:]
+	default:
+		return fmt.Errorf("%w: minisign: unsupported algorithm %q", ErrSignature, algorithm)
+	}
+	if !ed25519.Verify(edKey, message, edSig) {
+		return fmt.Errorf("%w: minisign: signature doesn't match", ErrSignature)
+	}
+
+	// The trusted comment is signed along with the signature, so it can't be swapped out.
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	trustedComment := strings.TrimPrefix(lines[2], trustedCommentPrefix)
+	if err != nil || !ed25519.Verify(edKey, append(append([]byte{}, edSig...), trustedComment...), globalSig) {
+		return fmt.Errorf("%w: minisign: trusted comment signature doesn't match", ErrSignature)
+	}
+	return nil
+}
+
+// minisignLine returns the base64 line of a minisign key, which may be given with or without its comment line.
+func minisignLine(text string) (string, error) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, untrustedCommentPrefix) {
+			return line, nil
+		}
+	}
+	return "", errors.New("empty key")
+}
@@ -0,0 +1,194 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideRoot is returned when a sandboxed FS is asked to change something outside its root.
+var ErrOutsideRoot = errors.New("path is outside the sandbox root")
+
+// FS is how Gasible finds the user's home directory and reads and writes files in it.
+// Everything that touches the user's files goes through an FS, so a run can be pointed at a sandbox instead.
+type FS interface {
+	// HomeDir returns the user's home directory, or the sandbox root when there is one.
+	HomeDir() (string, error)
+	// Root returns the sandbox root, empty when we're using the real home directory.
+	Root() string
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(path string, perm fs.FileMode) error
+	Stat(name string) (fs.FileInfo, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Remove(name string) error
+	Glob(pattern string) ([]string, error)
+}
+
+// osFS implements FS on the host's filesystem, optionally sandboxed under root.
+type osFS struct {
+	root string
+	// resolvedRoot is root with its symlinks resolved, which resolved paths are checked against.
+	resolvedRoot string
+}
+
+// Host returns an FS for the real home directory.
+func Host() FS {
+	return osFS{}
+}
+
+// Sandbox returns an FS that uses root as the home directory and refuses to change anything outside of it.
+// Reading outside the root is still allowed, so things like /etc/os-release can be found.
+func Sandbox(root string) (FS, error) {
+	if root == "" {
+		return nil, internal.ErrorAs("Sandbox", errors.New("sandbox root can't be empty"))
+	}
+	root, err := ExpandHome(Host(), root)
+	if err != nil {
+		return nil, internal.ErrorAs("Sandbox", err)
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return nil, internal.ErrorAs("Sandbox", err)
+	}
+	if err = os.MkdirAll(root, 0750); err != nil {
+		return nil, internal.ErrorAs("Sandbox", err)
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, internal.ErrorAs("Sandbox", err)
+	}
+	return osFS{root: root, resolvedRoot: resolvedRoot}, nil
+}
+
+// HomeDir returns the sandbox root, or the user's home directory when not sandboxed.
+func (o osFS) HomeDir() (string, error) {
+	if o.root != "" {
+		return o.root, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", internal.ErrorAs("HomeDir", err)
+	}
+	return homeDir, nil
+}
+
+// Root returns the sandbox root, empty when not sandboxed.
+func (o osFS) Root() string {
+	return o.root
+}
+
+// ReadFile reads the named file.
+func (o osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Clean(name))
+}
+
+// WriteFile writes data to the named file, creating it with perm if needed.
+func (o osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := o.writable(name); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Clean(name), data, perm)
+}
+
+// MkdirAll creates the directory and any parents that don't exist.
+func (o osFS) MkdirAll(path string, perm fs.FileMode) error {
+	if err := o.writable(path); err != nil {
+		return err
+	}
+	return os.MkdirAll(path, perm)
+}
+
+// Stat returns the file info for the named file.
+func (o osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// OpenFile opens the named file, anything but read-only must be inside the sandbox.
+func (o osFS) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0 {
+		if err := o.writable(name); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(filepath.Clean(name), flag, perm)
+}
+
+// Remove removes the named file or empty directory.
+func (o osFS) Remove(name string) error {
+	// Removing a symlink removes the link rather than what it points to, so only its directory needs to be inside.
+	if err := o.writable(filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := o.writable(name); err != nil && !isSymlink(name) {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Glob returns the names of all files matching pattern.
+func (o osFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// writable checks that a path is inside the sandbox, anything goes when not sandboxed.
+// Symlinks are followed, so a link inside the sandbox can't be used to write outside of it.
+func (o osFS) writable(path string) error {
+	if o.root == "" {
+		return nil
+	}
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveSymlinks(absolute)
+	if err != nil {
+		return err
+	}
+	relative, err := filepath.Rel(o.resolvedRoot, resolved)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return &fs.PathError{Op: "write", Path: path, Err: fmt.Errorf("%w %s", ErrOutsideRoot, o.root)}
+	}
+	return nil
+}
+
+// resolveSymlinks resolves the symlinks in the part of an absolute path that exists, and keeps the rest as it is.
+func resolveSymlinks(path string) (string, error) {
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// isSymlink reports whether the named file is a symlink.
+func isSymlink(name string) bool {
+	info, err := os.Lstat(name)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// ExpandHome replaces a leading ~ with the home directory of fsys.
+func ExpandHome(fsys FS, path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	homeDir, err := fsys.HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~")), nil
+}
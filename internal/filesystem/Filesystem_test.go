@@ -0,0 +1,94 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandboxWritesInsideRoot(t *testing.T) {
+	root := t.TempDir()
+	fsys, err := Sandbox(root)
+	if err != nil {
+		t.Fatalf("Sandbox returned an error: %v", err)
+	}
+
+	home, err := fsys.HomeDir()
+	if err != nil || home != root {
+		t.Fatalf("got home %q and error %v, want %q", home, err, root)
+	}
+	name := filepath.Join(home, ".gas", "config.yml")
+	if err = fsys.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		t.Fatalf("MkdirAll returned an error: %v", err)
+	}
+	if err = fsys.WriteFile(name, []byte("version: 0.1.0\n"), 0600); err != nil {
+		t.Fatalf("WriteFile returned an error: %v", err)
+	}
+	if expanded, _ := ExpandHome(fsys, "~/.gas/config.yml"); expanded != name {
+		t.Errorf("got %q from ExpandHome, want %q", expanded, name)
+	}
+}
+
+func TestSandboxRefusesWritesOutsideRoot(t *testing.T) {
+	outside := t.TempDir()
+	fsys, err := Sandbox(filepath.Join(t.TempDir(), "sandbox"))
+	if err != nil {
+		t.Fatalf("Sandbox returned an error: %v", err)
+	}
+
+	name := filepath.Join(outside, "dotfile")
+	if err = fsys.WriteFile(name, []byte("oops"), 0600); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("got error %v writing outside the root, want ErrOutsideRoot", err)
+	}
+	if _, err = fsys.OpenFile(filepath.Join(fsys.Root(), "..", "escaped"), os.O_CREATE|os.O_WRONLY, 0600); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("got error %v opening outside the root for writing, want ErrOutsideRoot", err)
+	}
+	if _, err = os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file outside the root was written, stat error %v", err)
+	}
+}
+
+func TestSandboxRefusesWritesThroughSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	fsys, err := Sandbox(filepath.Join(t.TempDir(), "sandbox"))
+	if err != nil {
+		t.Fatalf("Sandbox returned an error: %v", err)
+	}
+	linkedDir := filepath.Join(fsys.Root(), ".config")
+	if err = os.Symlink(outside, linkedDir); err != nil {
+		t.Fatalf("unable to link a directory out of the sandbox: %v", err)
+	}
+	dotfile := filepath.Join(outside, "dotfile")
+	if err = os.WriteFile(dotfile, []byte("mine"), 0600); err != nil {
+		t.Fatalf("unable to write the outside file: %v", err)
+	}
+	linkedFile := filepath.Join(fsys.Root(), ".dotfile")
+	if err = os.Symlink(dotfile, linkedFile); err != nil {
+		t.Fatalf("unable to link a file out of the sandbox: %v", err)
+	}
+
+	if err = fsys.WriteFile(filepath.Join(linkedDir, "new", "config"), []byte("oops"), 0600); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("got error %v writing through a linked directory, want ErrOutsideRoot", err)
+	}
+	if err = fsys.MkdirAll(filepath.Join(linkedDir, "new"), 0750); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("got error %v making a directory through a linked directory, want ErrOutsideRoot", err)
+	}
+	if err = fsys.WriteFile(linkedFile, []byte("oops"), 0600); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("got error %v writing through a linked file, want ErrOutsideRoot", err)
+	}
+	if err = fsys.Remove(filepath.Join(linkedDir, "dotfile")); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("got error %v removing through a linked directory, want ErrOutsideRoot", err)
+	}
+	if contents, _ := os.ReadFile(dotfile); string(contents) != "mine" {
+		t.Errorf("the file outside the sandbox was changed to %q", contents)
+	}
+
+	// The link itself is inside the sandbox, so it can be removed.
+	if err = fsys.Remove(linkedFile); err != nil {
+		t.Errorf("Remove returned an error removing a link in the sandbox: %v", err)
+	}
+	if _, err = os.Stat(dotfile); err != nil {
+		t.Errorf("removing the link removed what it points to: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// fileExtension is the extension of each run's journal file, one JSON entry per line.
+const fileExtension = ".jsonl"
+
+// maxOutputBytes is how much of each output stream we keep per command, the end being the interesting part.
+const maxOutputBytes = 4096
+
+// Entry is one command that Gasible ran.
+type Entry struct {
+	Time     time.Time     `json:"time"`
+	Run      string        `json:"run"`
+	Module   string        `json:"module,omitempty"`
+	Action   string        `json:"action,omitempty"`
+	Argv     []string      `json:"argv"`
+	Sudo     bool          `json:"sudo"`
+	Stdin    bool          `json:"stdin,omitempty"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration_ns"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Failed reports whether the command failed to run or exited non-zero.
+func (e Entry) Failed() bool {
+	return e.ExitCode != 0 || e.Error != ""
+}
+
+// Command returns the command line that was run.
+func (e Entry) Command() string {
+	return strings.Join(e.Argv, " ")
+}
+
+// Journal appends entries to the journal file for a single run.
+// The file is only created once the first command runs, so runs that execute nothing leave no trace.
+type Journal struct {
+	mu   sync.Mutex
+	fsys filesystem.FS
+	dir  string
+	run  string
+	file *os.File
+}
+
+// New returns a pointer to a Journal writing the run's entries into dir.
+func New(fsys filesystem.FS, dir string, run string) *Journal {
+	return &Journal{fsys: fsys, dir: dir, run: run}
+}
+
+// Append redacts any known secrets from the entry, truncates its output and writes it to the journal.
+func (j *Journal) Append(entry Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		if err := j.fsys.MkdirAll(j.dir, 0700); err != nil {
+			return internal.ErrorAs("Journal.Append", err)
+		}
+		file, err := j.fsys.OpenFile(filepath.Join(j.dir, j.run+fileExtension), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return internal.ErrorAs("Journal.Append", err)
+		}
+		j.file = file
+	}
+
+	entry.Run = j.run
+	entry.Argv = secrets.RedactArgs(entry.Argv)
+	entry.Stdout = truncate(secrets.Redact(entry.Stdout))
+	entry.Stderr = truncate(secrets.Redact(entry.Stderr))
+	entry.Error = secrets.Redact(entry.Error)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return internal.ErrorAs("Journal.Append", err)
+	}
+	if _, err = j.file.Write(append(line, '\n')); err != nil {
+		return internal.ErrorAs("Journal.Append", err)
+	}
+	return nil
+}
+
+// Close closes the journal file, if one was created.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// Runs returns the IDs of every run with a journal in dir, oldest first.
+func Runs(fsys filesystem.FS, dir string) ([]string, error) {
+	paths, err := fsys.Glob(filepath.Join(dir, "*"+fileExtension))
+	if err != nil {
+		return nil, internal.ErrorAs("Runs", err)
+	}
+	runs := make([]string, len(paths))
+	for i, path := range paths {
+		runs[i] = strings.TrimSuffix(filepath.Base(path), fileExtension)
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// Read returns every entry in a run's journal.
+func Read(fsys filesystem.FS, dir string, run string) ([]Entry, error) {
+	file, err := fsys.OpenFile(filepath.Join(dir, filepath.Base(run)+fileExtension), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, internal.ErrorAs("Read", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, internal.ErrorAs("Read", fmt.Errorf("%s line %d: %w", run, lineNumber, err))
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return entries, internal.ErrorAs("Read", err)
+	}
+	return entries, nil
+}
+
+// truncate keeps the last maxOutputBytes of output, noting how much was dropped.
+// The cut moves forward to the start of a character, so what is kept is still valid UTF-8.
+func truncate(output string) string {
+	if len(output) <= maxOutputBytes {
+		return output
+	}
+	dropped := len(output) - maxOutputBytes
+	for dropped < len(output) && !utf8.RuneStart(output[dropped]) {
+		dropped++
+	}
+	return fmt.Sprintf("[truncated %d bytes]...", dropped) + output[dropped:]
+}
@@ -0,0 +1,63 @@
+package journal
+
+import (
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAppendRedactsAndTruncates(t *testing.T) {
+	fsys, err := filesystem.Sandbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create sandbox: %v", err)
+	}
+	home, _ := fsys.HomeDir()
+	dir := filepath.Join(home, ".gas", "history")
+	secrets.Remember("ghp_journalTestToken")
+
+	journal := New(fsys, dir, "20261019T120000")
+	entry := Entry{
+		Argv:   []string{"gh", "auth", "login", "--with-token=ghp_journalTestToken"},
+		Stdout: strings.Repeat("x", maxOutputBytes) + "done",
+		Stderr: "token ghp_journalTestToken rejected",
+	}
+	if err = journal.Append(entry); err != nil {
+		t.Fatalf("Append returned an error: %v", err)
+	}
+	if err = journal.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	entries, err := Read(fsys, dir, "20261019T120000")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %d entries and error %v, want the one appended", len(entries), err)
+	}
+	got := entries[0]
+	if got.Argv[3] != "--with-token="+secrets.Redacted || strings.Contains(got.Stderr, "ghp_journalTestToken") {
+		t.Errorf("got %q and %q, want the token redacted", got.Argv, got.Stderr)
+	}
+	if !strings.HasPrefix(got.Stdout, "[truncated 4 bytes]...") || !strings.HasSuffix(got.Stdout, "done") {
+		t.Errorf("got stdout starting %q, want the end kept and the cut noted", got.Stdout[:30])
+	}
+	if runs, runsErr := Runs(fsys, dir); runsErr != nil || len(runs) != 1 || runs[0] != "20261019T120000" {
+		t.Errorf("got runs %v and error %v, want the one run", runs, runsErr)
+	}
+}
+
+func TestTruncateKeepsWholeCharacters(t *testing.T) {
+	// Each ✓ is three bytes, so a cut by bytes alone would land in the middle of one.
+	output := "a" + strings.Repeat("✓", maxOutputBytes/3+1)
+	got := truncate(output)
+	if !utf8.ValidString(got) {
+		t.Fatalf("got invalid UTF-8 %q", got[:30])
+	}
+	if kept := got[strings.Index(got, "...")+3:]; len(kept) > maxOutputBytes || !strings.HasSuffix(output, kept) {
+		t.Fatalf("kept %d bytes, want at most %d from the end", len(kept), maxOutputBytes)
+	}
+	if short := "✓ done"; truncate(short) != short {
+		t.Fatal("short output was truncated")
+	}
+}
@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// customManagerName is what a custom manager can be called, so it can't be mistaken for anything else in the config.
+var customManagerName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// customPackageManagers is the managers from the config that have been registered, by name,
+// so parsing the config again can replace them where it mustn't replace a built-in one.
+var customPackageManagers = make(map[string]*BasePackageManager)
+
+// CustomManager is a package manager defined in the config, for managers we don't support ourselves.
+// Each arg is one or more space separated words, like those of the built-in managers.
+type CustomManager struct {
+	// Binary is the command to run, the manager's name when it isn't set.
+	Binary    string `yaml:"binary,omitempty"`
+	NeedsRoot bool   `yaml:"needs-root,omitempty"`
+	Refresh   string `yaml:"refresh,omitempty"`
+	Install   string `yaml:"install"`
+	Remove    string `yaml:"remove"`
+	Update    string `yaml:"update"`
+	// Upgrade upgrades the whole system, the manager is left out of `gasible upgrade` without it.
+	Upgrade     string `yaml:"upgrade,omitempty"`
+	AutoConfirm string `yaml:"auto-confirm,omitempty"`
+	Quiet       string `yaml:"quiet,omitempty"`
+	// VersionFormat is how to ask for a version of a package, e.g. "%s=%s", pinned packages can't be installed without it.
+	VersionFormat string `yaml:"version-format,omitempty"`
+	// Query is a command that, given package names, prints the name and version of each one installed on a line.
+	Query []string `yaml:"query,omitempty"`
+	// Explicit is a command that prints the packages installed on purpose, one on each line, for `gasible freeze`.
+	Explicit []string `yaml:"explicit,omitempty"`
+}
+
+// manager returns the BasePackageManager the custom manager describes, after checking it has what it needs.
+func (cm CustomManager) manager(name string) (*BasePackageManager, error) {
+	if !customManagerName.MatchString(name) {
+		return nil, fmt.Errorf("custom manager has a bad name %q", name)
+	}
+	if cm.Install == "" || cm.Remove == "" || cm.Update == "" {
+		return nil, fmt.Errorf("custom manager %s needs install, remove and update", name)
+	}
+	if cm.VersionFormat != "" && strings.Count(cm.VersionFormat, "%s") != 2 {
+		return nil, fmt.Errorf("custom manager %s version-format needs a %%s for the name and one for the version", name)
+	}
+	pm := &BasePackageManager{
+		Name:      name,
+		NeedsRoot: cm.NeedsRoot,
+		Args: packageManagerArgs{
+			RefreshArg:   cm.Refresh,
+			InstallArg:   cm.Install,
+			UninstallArg: cm.Remove,
+			UpdateArg:    cm.Update,
+			UpgradeArg:   cm.Upgrade,
+		},
+		Opts: packageManagerOpts{
+			AutoConfirmOpt: cm.AutoConfirm,
+			QuietOpt:       cm.Quiet,
+		},
+		VersionFormat: cm.VersionFormat,
+	}
+	if cm.Binary != "" && cm.Binary != name {
+		pm.Executables = make(map[string]string)
+		for _, operation := range []string{"refresh", "install", "uninstall", "update", "upgrade"} {
+			pm.Executables[operation] = cm.Binary
+		}
+	}
+	if len(cm.Query) > 0 {
+		pm.Query = &packageQuery{Command: cm.Query, parse: parseNameAndVersion}
+	}
+	if len(cm.Explicit) > 0 {
+		pm.Explicit = &packageList{Command: cm.Explicit, parse: parseNames}
+	}
+	return pm, nil
+}
+
+// parseNameAndVersion parses lines starting with a package's name and version, split by whitespace.
+func parseNameAndVersion(stdout string) map[string]string {
+	versions := make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			versions[fields[0]] = fields[1]
+		}
+	}
+	return versions
+}
+
+// registerCustomManagers adds the managers defined in the config to supportedPackageManagers,
+// so they can be used for the manager setting, in managers and in aliases like the built-in ones.
+// Those from an earlier parse are replaced, so a manager taken out of the config can't be used any more.
+func registerCustomManagers(custom map[string]CustomManager) error {
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	registered := make(map[string]*BasePackageManager, len(custom))
+	for _, name := range names {
+		_, builtIn := supportedPackageManagers[name]
+		if (builtIn && customPackageManagers[name] == nil) || isReservedManagerName(name) {
+			return fmt.Errorf("custom manager %s has the name of a built-in one", name)
+		}
+		pm, err := custom[name].manager(name)
+		if err != nil {
+			return err
+		}
+		registered[name] = pm
+	}
+
+	for name := range customPackageManagers {
+		delete(supportedPackageManagers, name)
+	}
+	for name, pm := range registered {
+		supportedPackageManagers[name] = pm
+	}
+	customPackageManagers = registered
+	return nil
+}
+
+// isReservedManagerName reports whether the config already means another manager by the name,
+// managerNamed looks these up before supportedPackageManagers, so a custom one by the name would never be used.
+func isReservedManagerName(name string) bool {
+	if name == "flatpak" || name == "snap" {
+		return true
+	}
+	_, ok := languageManagers[name]
+	return ok
+}
@@ -0,0 +1,102 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+)
+
+// forgetCustomManagers unregisters the custom managers a test registers, once it is done.
+func forgetCustomManagers(t *testing.T, names ...string) {
+	t.Helper()
+	t.Cleanup(func() {
+		for _, name := range names {
+			delete(supportedPackageManagers, name)
+			delete(customPackageManagers, name)
+		}
+	})
+}
+
+func TestCustomManager(t *testing.T) {
+	forgetCustomManagers(t, "solus")
+	settings := map[string]interface{}{
+		"manager":  "solus",
+		"packages": []string{"git", "curl@8.6.0"},
+		"custom-managers": map[string]interface{}{
+			"solus": map[string]interface{}{
+				"binary":         "eopkg",
+				"needs-root":     true,
+				"install":        "install",
+				"remove":         "remove",
+				"update":         "upgrade",
+				"auto-confirm":   "-y",
+				"version-format": "%s==%s",
+				"query":          []string{"eopkg-query", "--versions"},
+			},
+		},
+	}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"eopkg-query", "--versions", "git", "curl"}, Stdout: "git 2.43.0\n"},
+		fakeCommand{Argv: []string{"sudo", "eopkg", "install", "-y", "curl==8.6.0"}, Sudo: true},
+		fakeCommand{Argv: []string{"eopkg-query", "--versions", "curl"}, Stdout: "curl 8.6.0\n"},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+
+	// Without an upgrade the manager has nothing to do for a system upgrade, pinned packages are still checked.
+	fake.expect(fakeCommand{Argv: []string{"eopkg-query", "--versions", "curl"}, Stdout: "curl 8.6.0\n"})
+	if err := gpm.Upgrade(); err != nil {
+		t.Fatalf("Upgrade returned an error: %v", err)
+	}
+}
+
+func TestCustomManagerErrors(t *testing.T) {
+	forgetCustomManagers(t, "eopkg", "bad name", "flatpak", "npm")
+	tests := []struct {
+		name   string
+		custom map[string]CustomManager
+		want   string
+	}{
+		{"built-in name", map[string]CustomManager{"dnf": {Install: "in", Remove: "rm", Update: "up"}}, "name of a built-in one"},
+		{"flatpak", map[string]CustomManager{"flatpak": {Install: "in", Remove: "rm", Update: "up"}}, "name of a built-in one"},
+		{"language manager", map[string]CustomManager{"npm": {Install: "in", Remove: "rm", Update: "up"}}, "name of a built-in one"},
+		{"no install", map[string]CustomManager{"eopkg": {Remove: "rm", Update: "up"}}, "needs install, remove and update"},
+		{"bad name", map[string]CustomManager{"bad name": {Install: "in", Remove: "rm", Update: "up"}}, "bad name"},
+		{"bad version format", map[string]CustomManager{"eopkg": {Install: "in", Remove: "rm", Update: "up", VersionFormat: "%s"}}, "version-format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := registerCustomManagers(tt.custom)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+	if supportedPackageManagers["dnf"] != &dnf {
+		t.Fatalf("the built-in dnf was replaced")
+	}
+}
+
+func TestCustomManagersAreReplacedOnEachParse(t *testing.T) {
+	forgetCustomManagers(t, "solus", "eopkg")
+	solus := map[string]interface{}{"install": "install", "remove": "remove", "update": "upgrade"}
+	gpm := newTestPackageManager(t, map[string]interface{}{"custom-managers": map[string]interface{}{"solus": solus}}, newFakeRunner(t))
+	if supportedPackageManagers["solus"] == nil {
+		t.Fatal("solus wasn't registered")
+	}
+
+	settings := map[string]interface{}{"custom-managers": map[string]interface{}{"eopkg": solus}}
+	if err := gpm.ParseConfig(map[string]interface{}{"enabled": true, "settings": settings}); err != nil {
+		t.Fatalf("ParseConfig returned an error: %v", err)
+	}
+	if _, ok := supportedPackageManagers["solus"]; ok {
+		t.Fatal("solus is still registered after being taken out of the config")
+	}
+	if supportedPackageManagers["eopkg"] == nil {
+		t.Fatal("eopkg wasn't registered")
+	}
+	if _, err := resolveManager("solus"); err == nil {
+		t.Fatal("solus can still be used as the manager")
+	}
+}
@@ -0,0 +1,230 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// flathubURL is where the flathub remote is added from when no URL is given for it.
+const flathubURL = "https://dl.flathub.org/repo/flathub.flatpakrepo"
+
+// Flatpak installations, an app or remote goes into one or the other.
+const (
+	flatpakSystem = "system"
+	flatpakUser   = "user"
+)
+
+// FlatpakSettings are the Flatpak apps to install alongside the native packages, and the remotes they come from.
+type FlatpakSettings struct {
+	// Installation is "system" or "user", for apps and remotes that don't choose their own. Defaults to system.
+	Installation string           `yaml:"installation,omitempty"`
+	Remotes      []FlatpakRemote  `yaml:"remotes,omitempty"`
+	Packages     []FlatpakPackage `yaml:"packages,omitempty"`
+}
+
+// FlatpakRemote is a remote to add with `flatpak remote-add --if-not-exists`.
+type FlatpakRemote struct {
+	Name string `yaml:"name"`
+	// URL is the .flatpakrepo file, it can be left out for flathub.
+	URL          string `yaml:"url,omitempty"`
+	Installation string `yaml:"installation,omitempty"`
+}
+
+// FlatpakPackage is an app ID, optionally with the remote it comes from and the installation it goes into.
+// In the config it can be just the ID, e.g. `- org.mozilla.firefox`.
+type FlatpakPackage struct {
+	ID           string `yaml:"id"`
+	Remote       string `yaml:"remote,omitempty"`
+	Installation string `yaml:"installation,omitempty"`
+}
+
+// UnmarshalYAML accepts either an app ID or a mapping with the ID and its options.
+func (p *FlatpakPackage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		p.ID = node.Value
+		return nil
+	}
+	type plain FlatpakPackage
+	return node.Decode((*plain)(p))
+}
+
+// MarshalYAML writes an app without options as just its ID.
+func (p FlatpakPackage) MarshalYAML() (interface{}, error) {
+	if p.Remote == "" && p.Installation == "" {
+		return p.ID, nil
+	}
+	type plain FlatpakPackage
+	return plain(p), nil
+}
+
+// validate makes sure the installations are ones flatpak knows and every remote can be added.
+func (s *FlatpakSettings) validate() error {
+	var badInstallationErr = errors.New("flatpak installation must be " + flatpakSystem + " or " + flatpakUser)
+	installations := []string{s.Installation}
+	for _, remote := range s.Remotes {
+		if remote.URL == "" && remote.Name != "flathub" {
+			return fmt.Errorf("flatpak remote %s has no url", remote.Name)
+		}
+		installations = append(installations, remote.Installation)
+	}
+	for _, pkg := range s.Packages {
+		installations = append(installations, pkg.Installation)
+	}
+	for _, installation := range installations {
+		if installation != "" && installation != flatpakSystem && installation != flatpakUser {
+			return badInstallationErr
+		}
+	}
+	return nil
+}
+
+// ids returns the app IDs to hand to the flatpak manager.
+func (s *FlatpakSettings) ids() []string {
+	ids := make([]string, len(s.Packages))
+	for i, pkg := range s.Packages {
+		ids[i] = pkg.ID
+	}
+	return ids
+}
+
+// flatpakManager implements packageManager for Flatpak apps, looking up each app's options in its settings.
+type flatpakManager struct {
+	settings FlatpakSettings
+}
+
+// flatpakGroup is the apps that can be handled by one flatpak command.
+type flatpakGroup struct {
+	installation string
+	remote       string
+	ids          []string
+}
+
+// Install adds the configured remotes, then installs the apps into their installations.
+func (fm *flatpakManager) Install(ids []string, call *SysCall) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	for _, remote := range fm.settings.Remotes {
+		url := remote.URL
+		if url == "" {
+			url = flathubURL
+		}
+		installation := fm.installation(remote.Installation)
+		args := []string{"remote-add", "--if-not-exists", "--" + installation, remote.Name, url}
+		if _, err := call.Exec("flatpak", args, installation == flatpakSystem); err != nil {
+			return fmt.Errorf("flatpak remote-add %s failed: %w", remote.Name, err)
+		}
+	}
+	return fm.execute("install", ids, call, true)
+}
+
+// Uninstall removes the apps from their installations.
+func (fm *flatpakManager) Uninstall(ids []string, call *SysCall) error {
+	return fm.execute("uninstall", ids, call, false)
+}
+
+// Update updates the apps in their installations.
+func (fm *flatpakManager) Update(ids []string, call *SysCall) error {
+	return fm.execute("update", ids, call, false)
+}
+
+// Upgrade updates every app and runtime in the installations the settings use.
+func (fm *flatpakManager) Upgrade(call *SysCall) error {
+	installations := []string{fm.installation("")}
+	for _, remote := range fm.settings.Remotes {
+		installations = append(installations, fm.installation(remote.Installation))
+	}
+	for _, pkg := range fm.settings.Packages {
+		installations = append(installations, fm.installation(pkg.Installation))
+	}
+	slices.Sort(installations)
+	for _, installation := range slices.Compact(installations) {
+		args := []string{"update", "--" + installation, "-y", "--noninteractive"}
+		result, err := call.Exec("flatpak", args, installation == flatpakSystem)
+		if err != nil {
+			return fmt.Errorf("flatpak upgrade failed: %w", err)
+		}
+		slog.Info("package manager finished running operation", "manager", "flatpak", "operation", "upgrade",
+			"installation", installation, "duration", result.Duration.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// execute runs the operation once for each installation, and for installs once for each remote too.
+func (fm *flatpakManager) execute(operation string, ids []string, call *SysCall, byRemote bool) error {
+	var commands []packageCommand
+	for _, group := range fm.groups(ids, byRemote) {
+		args := []string{operation, "--" + group.installation, "-y", "--noninteractive"}
+		if group.remote != "" {
+			args = append(args, group.remote)
+		}
+		commands = append(commands, packageCommand{args: args, packages: group.ids, names: group.ids,
+			sudo: group.installation == flatpakSystem})
+	}
+	duration, err := runPackageCommands(call, "flatpak", operation, commands)
+	if err != nil {
+		return fmt.Errorf("flatpak %s failed: %w", operation, err)
+	}
+	if len(commands) > 0 {
+		slog.Info("packages finished running operation", "manager", "flatpak", "operation", operation,
+			"packages", len(ids), "duration", duration.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// groups splits the apps by installation, and by remote when asked, keeping the order they were configured in.
+func (fm *flatpakManager) groups(ids []string, byRemote bool) []flatpakGroup {
+	type groupKey struct{ installation, remote string }
+	var groups []flatpakGroup
+	index := make(map[groupKey]int)
+	for _, id := range ids {
+		pkg := fm.lookup(id)
+		key := groupKey{installation: fm.installation(pkg.Installation)}
+		if byRemote {
+			key.remote = pkg.Remote
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, flatpakGroup{installation: key.installation, remote: key.remote})
+		}
+		groups[i].ids = append(groups[i].ids, id)
+	}
+	return groups
+}
+
+// lookup returns the configured options for an app, an app that isn't configured gets the defaults.
+func (fm *flatpakManager) lookup(id string) FlatpakPackage {
+	for _, pkg := range fm.settings.Packages {
+		if pkg.ID == id {
+			return pkg
+		}
+	}
+	return FlatpakPackage{ID: id}
+}
+
+// installation returns the installation to use, falling back to the settings' and then to the system one.
+func (fm *flatpakManager) installation(chosen string) string {
+	switch {
+	case chosen != "":
+		return chosen
+	case fm.settings.Installation != "":
+		return fm.settings.Installation
+	default:
+		return flatpakSystem
+	}
+}
+
+// newFlatpakManager returns a pointer to a flatpakManager for the settings, after checking them.
+func newFlatpakManager(settings FlatpakSettings) (*flatpakManager, error) {
+	if err := settings.validate(); err != nil {
+		return nil, internal.ErrorAs("newFlatpakManager", err)
+	}
+	return &flatpakManager{settings: settings}, nil
+}
@@ -0,0 +1,88 @@
+package modules
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+)
+
+func TestFlatpakSettingsUnmarshal(t *testing.T) {
+	raw := `
+installation: user
+remotes:
+  - name: flathub
+packages:
+  - org.mozilla.firefox
+  - id: com.slack.Slack
+    installation: system
+`
+	var settings FlatpakSettings
+	if err := yaml.Unmarshal([]byte(raw), &settings); err != nil {
+		t.Fatalf("unable to parse settings: %v", err)
+	}
+	want := []FlatpakPackage{{ID: "org.mozilla.firefox"}, {ID: "com.slack.Slack", Installation: "system"}}
+	if !reflect.DeepEqual(settings.Packages, want) {
+		t.Errorf("got packages %+v, want %+v", settings.Packages, want)
+	}
+
+	written, err := yaml.Marshal(settings)
+	if err != nil {
+		t.Fatalf("unable to write settings: %v", err)
+	}
+	var reread FlatpakSettings
+	if err = yaml.Unmarshal(written, &reread); err != nil || !reflect.DeepEqual(reread, settings) {
+		t.Errorf("settings didn't survive being written out, got %+v and error %v from:\n%s", reread, err, written)
+	}
+}
+
+func TestFlatpakInstall(t *testing.T) {
+	fm, err := newFlatpakManager(FlatpakSettings{
+		Installation: "user",
+		Remotes: []FlatpakRemote{
+			{Name: "flathub"},
+			{Name: "gnome-nightly", URL: "https://nightly.gnome.org/gnome-nightly.flatpakrepo", Installation: "system"},
+		},
+		Packages: []FlatpakPackage{
+			{ID: "org.mozilla.firefox", Remote: "flathub"},
+			{ID: "org.gnome.Builder.Devel", Remote: "gnome-nightly", Installation: "system"},
+			{ID: "com.slack.Slack", Remote: "flathub"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newFlatpakManager returned an error: %v", err)
+	}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"flatpak", "remote-add", "--if-not-exists", "--user", "flathub", flathubURL}},
+		fakeCommand{Argv: []string{"sudo", "flatpak", "remote-add", "--if-not-exists", "--system", "gnome-nightly",
+			"https://nightly.gnome.org/gnome-nightly.flatpakrepo"}, Sudo: true},
+		fakeCommand{Argv: []string{"flatpak", "install", "--user", "-y", "--noninteractive", "flathub",
+			"org.mozilla.firefox", "com.slack.Slack"}},
+		fakeCommand{Argv: []string{"sudo", "flatpak", "install", "--system", "-y", "--noninteractive", "gnome-nightly",
+			"org.gnome.Builder.Devel"}, Sudo: true},
+	)
+	if err = fm.Install(fm.settings.ids(), newFakeSysCall(t, fake)); err != nil {
+		t.Fatalf("Install returned an error: %v", err)
+	}
+}
+
+func TestFlatpakUninstall(t *testing.T) {
+	fm := &flatpakManager{settings: FlatpakSettings{
+		Packages: []FlatpakPackage{{ID: "org.mozilla.firefox", Remote: "flathub"}, {ID: "com.slack.Slack", Installation: "user"}},
+	}}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "flatpak", "uninstall", "--system", "-y", "--noninteractive", "org.mozilla.firefox"}, Sudo: true},
+		fakeCommand{Argv: []string{"flatpak", "uninstall", "--user", "-y", "--noninteractive", "com.slack.Slack"}},
+	)
+	if err := fm.Uninstall(fm.settings.ids(), newFakeSysCall(t, fake)); err != nil {
+		t.Fatalf("Uninstall returned an error: %v", err)
+	}
+}
+
+func TestFlatpakSettingsValidate(t *testing.T) {
+	if _, err := newFlatpakManager(FlatpakSettings{Remotes: []FlatpakRemote{{Name: "private"}}}); err == nil {
+		t.Error("a remote other than flathub without a url was accepted")
+	}
+	if _, err := newFlatpakManager(FlatpakSettings{Installation: "everywhere"}); err == nil {
+		t.Error("an unknown installation was accepted")
+	}
+}
@@ -6,28 +6,50 @@ import (
 	"github.com/Linkinlog/gasible/internal"
 	"github.com/Linkinlog/gasible/internal/app"
 	"gopkg.in/yaml.v3"
-	"log"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 )
 
 // init
 // This should really just handle registering the module in the registry.
 func init() {
 	ToBeRegistered = append(ToBeRegistered, &GenericPackageManager{
-		Name: "GenericPackageManager",
-		config: config{
-			Enabled:        true,
-			ConfigSettings: PackageManagerSettings{},
-		},
+		Name:              "GenericPackageManager",
+		config:            defaultConfig(),
 		PackageManagerMap: make(map[packageManager][]string),
 	})
 }
 
+// defaultConfig is the config before any is parsed, each parse starts from it.
+func defaultConfig() config {
+	return config{
+		Enabled:        true,
+		ConfigSettings: PackageManagerSettings{Manager: autoDetectManager},
+	}
+}
+
 // GenericPackageManager implements module, so we can register and execute it.
 type GenericPackageManager struct {
 	Name              string
 	config            config
 	PackageManagerMap map[packageManager][]string
 	Application       *app.App
+	// Results are what each manager did in the last operation, in the order they ran.
+	Results         []ManagerResult
+	resolvedManager string
+	flatpak         *flatpakManager
+	snap            *snapManager
+	// managed is the packages from the managers setting, by the manager they resolved to.
+	managed map[packageManager][]string
+	// order is the managers from the order setting, which run before the rest.
+	order []packageManager
+	// aliases is the built-in aliases with those from the config over them.
+	aliases map[string]map[string]string
+	// locked is the lockfile's versions when installing from it, nil otherwise.
+	locked Lockfile
 }
 
 // config is the YAML configuration for GenericPackageManager.
@@ -37,29 +59,97 @@ type config struct {
 }
 
 // PackageManagerSettings contains the user chosen package manager and the packages the user wants to install.
+// A manager of "auto" (or none at all) means we detect the native one for the system.
+// Flatpak apps, snaps and packages from language registries are installed alongside the native packages, after them.
+// Packages are written as name@version, the version being optional, and pinned native packages are left alone by update.
+// Managers maps any other manager, by name, to packages for it, e.g. brew for CLI tools on a Linux machine,
+// and Order is the managers to run first, in that order; the rest run after, native managers first.
 type PackageManagerSettings struct {
-	Manager  string   `yaml:"manager"`
-	Packages []string `yaml:"packages"`
+	Manager  string          `yaml:"manager"`
+	Packages []string        `yaml:"packages"`
+	Flatpak  FlatpakSettings `yaml:"flatpak,omitempty"`
+	Snap     SnapSettings    `yaml:"snap,omitempty"`
+	Go       []string        `yaml:"go,omitempty"`
+	Cargo    []string        `yaml:"cargo,omitempty"`
+	Npm      []string        `yaml:"npm,omitempty"`
+	Pipx     []string        `yaml:"pipx,omitempty"`
+	Gem      []string        `yaml:"gem,omitempty"`
+	// Hold holds pinned native packages, so nothing upgrades them, and lets them go before they are removed.
+	Hold     bool                `yaml:"hold,omitempty"`
+	Managers map[string][]string `yaml:"managers,omitempty"`
+	// Aliases maps a logical package name to what each manager calls it, adding to and overriding the built-in ones.
+	Aliases map[string]map[string]string `yaml:"aliases,omitempty"`
+	Order   []string                     `yaml:"order,omitempty"`
+	// CustomManagers defines managers we don't support ourselves, by name, usable anywhere a built-in one is.
+	CustomManagers map[string]CustomManager `yaml:"custom-managers,omitempty"`
+	// RefreshMaxAge is how old a package index can get before installing refreshes it, an hour when it isn't set.
+	// A negative age never refreshes it.
+	RefreshMaxAge time.Duration `yaml:"refresh-max-age,omitempty"`
+	// Repositories are added before any packages are installed, and the ones we added are removed on teardown.
+	Repositories []Repository `yaml:"repositories,omitempty"`
 }
 
-// Setup will run the installation command on the chosen package manager.
+// Setup adds the configured repositories, then runs the installation command on the chosen package manager.
+// Packages are still installed when a repository fails, those that don't need it can be.
 func (gpm *GenericPackageManager) Setup() error {
-	gpm.PackageManagerMap[gpm.Manager()] = gpm.config.ConfigSettings.Packages
-	return gpm.managePackages("install")
+	repositoriesErr := gpm.addRepositories()
+	gpm.mapPackages()
+	if err := errors.Join(repositoriesErr, gpm.managePackages("install")); err != nil {
+		return internal.ErrorAs("GenericPackageManager.Setup", err)
+	}
+	return nil
 }
 
-// TearDown will run the remove command on the chosen package manager.
+// TearDown will run the remove command on the chosen package manager, then remove the repositories we added.
 func (gpm *GenericPackageManager) TearDown() error {
-	gpm.PackageManagerMap[gpm.Manager()] = gpm.config.ConfigSettings.Packages
-	return gpm.managePackages("uninstall")
+	gpm.mapPackages()
+	packagesErr := gpm.managePackages("uninstall")
+	if err := errors.Join(packagesErr, gpm.removeRepositories()); err != nil {
+		return internal.ErrorAs("GenericPackageManager.TearDown", err)
+	}
+	return nil
 }
 
-// Update will run the update command on the chosen package manager.
+// Update will run the update command on the chosen package manager, upgrading the configured packages.
 func (gpm *GenericPackageManager) Update() error {
-	gpm.PackageManagerMap[gpm.Manager()] = gpm.config.ConfigSettings.Packages
+	gpm.mapPackages()
 	return gpm.managePackages("update")
 }
 
+// Upgrade upgrades everything installed by each manager in use that can, not only the configured packages.
+func (gpm *GenericPackageManager) Upgrade() error {
+	gpm.mapPackages()
+	return gpm.managePackages("upgrade")
+}
+
+// mapPackages fills the PackageManagerMap with the configured packages for each manager.
+func (gpm *GenericPackageManager) mapPackages() {
+	settings := gpm.config.ConfigSettings
+	gpm.PackageManagerMap = map[packageManager][]string{
+		gpm.Manager(): settings.Packages,
+	}
+	configured := map[packageManager][]string{
+		gpm.flatpak: settings.Flatpak.ids(),
+		gpm.snap:    settings.Snap.names(),
+		&goInstall:  settings.Go,
+		&cargo:      settings.Cargo,
+		&npmGlobal:  settings.Npm,
+		&pipx:       settings.Pipx,
+		&gem:        settings.Gem,
+	}
+	for pm, packages := range configured {
+		if len(packages) > 0 {
+			gpm.PackageManagerMap[pm] = packages
+		}
+	}
+	for pm, packages := range gpm.managed {
+		gpm.PackageManagerMap[pm] = append(slices.Clone(gpm.PackageManagerMap[pm]), packages...)
+	}
+	for pm, packages := range gpm.PackageManagerMap {
+		gpm.PackageManagerMap[pm] = gpm.lockedVersions(pm, gpm.aliased(pm, packages))
+	}
+}
+
 // GetName returns the name field of the GenericPackageManager struct.
 func (gpm *GenericPackageManager) GetName() string {
 	return gpm.Name
@@ -85,13 +175,89 @@ func (gpm *GenericPackageManager) ParseConfig(rawConfig map[string]interface{})
 		return err
 	}
 
-	err = yaml.Unmarshal(configBytes, &gpm.config)
+	// Unmarshalling into the old config would merge its maps, keeping settings taken out of the file since.
+	parsed := defaultConfig()
+	err = yaml.Unmarshal(configBytes, &parsed)
 	if err != nil {
 		return err
 	}
+	gpm.config = parsed
+
+	if err = registerCustomManagers(gpm.config.ConfigSettings.CustomManagers); err != nil {
+		return internal.ErrorAs("GenericPackageManager.ParseConfig", err)
+	}
+	manager, err := resolveManager(gpm.config.ConfigSettings.Manager)
+	if err != nil {
+		return internal.ErrorAs("GenericPackageManager.ParseConfig", err)
+	}
+	if manager != gpm.config.ConfigSettings.Manager {
+		slog.Info("detected package manager", "module", gpm.Name, "manager", manager)
+	}
+	gpm.resolvedManager = manager
+
+	if gpm.flatpak, err = newFlatpakManager(gpm.config.ConfigSettings.Flatpak); err != nil {
+		return internal.ErrorAs("GenericPackageManager.ParseConfig", err)
+	}
+	gpm.snap = &snapManager{settings: gpm.config.ConfigSettings.Snap}
+	if err = validateRepositories(gpm.config.ConfigSettings.Repositories); err != nil {
+		return internal.ErrorAs("GenericPackageManager.ParseConfig", err)
+	}
+
+	if err = gpm.resolveManagers(); err != nil {
+		return internal.ErrorAs("GenericPackageManager.ParseConfig", err)
+	}
+	if err = gpm.resolveAliases(); err != nil {
+		return internal.ErrorAs("GenericPackageManager.ParseConfig", err)
+	}
+	return nil
+}
+
+// resolveManagers looks up the managers named in the managers and order settings.
+func (gpm *GenericPackageManager) resolveManagers() error {
+	gpm.managed = make(map[packageManager][]string)
+	// Sorted, so packages for two names of the same manager, like apt and apt-get, always come in the same order.
+	managerNames := make([]string, 0, len(gpm.config.ConfigSettings.Managers))
+	for name := range gpm.config.ConfigSettings.Managers {
+		managerNames = append(managerNames, name)
+	}
+	sort.Strings(managerNames)
+	for _, name := range managerNames {
+		pm, err := gpm.managerNamed(name)
+		if err != nil {
+			return err
+		}
+		gpm.managed[pm] = append(gpm.managed[pm], gpm.config.ConfigSettings.Managers[name]...)
+	}
+
+	gpm.order = nil
+	for _, name := range gpm.config.ConfigSettings.Order {
+		pm, err := gpm.managerNamed(name)
+		if err != nil {
+			return err
+		}
+		gpm.order = append(gpm.order, pm)
+	}
 	return nil
 }
 
+// managerNamed returns the manager for a name from the config, a native one is resolved like the manager setting.
+func (gpm *GenericPackageManager) managerNamed(name string) (packageManager, error) {
+	switch name {
+	case "flatpak":
+		return gpm.flatpak, nil
+	case "snap":
+		return gpm.snap, nil
+	}
+	if pm, ok := languageManagers[name]; ok {
+		return pm, nil
+	}
+	resolved, err := resolveManager(name)
+	if err != nil {
+		return nil, err
+	}
+	return supportedPackageManagers[resolved], nil
+}
+
 // packageManager is an interface that is meant to group the functions that a package manager would need to do.
 type packageManager interface {
 	Install([]string, *SysCall) error
@@ -101,13 +267,37 @@ type packageManager interface {
 
 // BasePackageManager implements the packageManager interface.
 type BasePackageManager struct {
-	Name string
-	Args packageManagerArgs
-	Opts packageManagerOpts
+	Name      string
+	NeedsRoot bool
+	Args      packageManagerArgs
+	Opts      packageManagerOpts
+	// Executables overrides Name for operations done by a different binary, such as xbps-remove.
+	Executables map[string]string
+	// InstallPrefix goes in front of every package being installed, such as the flake for nix.
+	InstallPrefix string
+	// VersionFormat is how the manager is asked for a version of a package, given its name and the version,
+	// e.g. "%s=%s" for apt. Managers without one can't install pinned packages.
+	VersionFormat string
+	// VersionInName is for managers like brew where each version is a package of its own, e.g. python@3.12.
+	// Those packages are always named with their version, and are updated like any other.
+	VersionInName bool
+	// Holds keeps packages at the version they are at, nil when the manager can't.
+	Holds *packageHolds
+	// Query asks which versions of packages are installed, nil when we don't know how.
+	Query *packageQuery
+	// Available lists the versions that can be installed, so a pin can leave out what the manager's versions add to
+	// the upstream one. nil when pins are passed to the manager as they are.
+	Available *packageVersions
+	// Explicit lists the packages installed on purpose rather than as dependencies, nil when we don't know how.
+	Explicit *packageList
 }
 
 // packageManagerArgs contains what we need to tell each supported package manager what we intend to do.
+// An arg can hold several space separated words, for managers like nix whose operations are subcommands.
+// UpdateArg upgrades the packages it is given, UpgradeArg upgrades everything on the system,
+// and RefreshArg refreshes the package index, empty for managers that refresh it themselves when it is out of date.
 type packageManagerArgs struct {
+	RefreshArg   string
 	InstallArg   string
 	UninstallArg string
 	UpdateArg    string
@@ -130,35 +320,67 @@ func (pm *BasePackageManager) Uninstall(packages []string, call *SysCall) error
 	return pm.execute("uninstall", packages, *call)
 }
 
-// Update updates the packages.
+// Update updates the packages, leaving pinned ones at their version.
 func (pm *BasePackageManager) Update(packages []string, call *SysCall) error {
-	return pm.execute("update", packages, *call)
+	return pm.execute("update", pm.unpinned(packages), *call)
 }
 
-// execute ensures the package manager is set, checks if we need sudo, formats the command, and manages the packages.
+// execute ensures the package manager is set, checks if we need root, formats the command, and manages the packages.
 func (pm *BasePackageManager) execute(operation string, packages []string, syscall SysCall) error {
+	_, err := pm.run(operation, packages, syscall)
+	return err
+}
+
+// manage does the operation like Install, Uninstall and Update do, and returns the packages it ran it for.
+func (pm *BasePackageManager) manage(operation string, packages []string, call *SysCall) ([]string, error) {
+	if operation == "update" && pm != nil {
+		packages = pm.unpinned(packages)
+	}
+	return pm.run(operation, packages, *call)
+}
+
+// run is execute, returning the packages the operation was run for, leaving out those with nothing to do.
+func (pm *BasePackageManager) run(operation string, packages []string, syscall SysCall) ([]string, error) {
 	var noPackageManagerFoundErr = errors.New("no package Manager set, set one in the config")
 	if pm == nil {
-		return internal.ErrorAs("basePackageManager.execute", noPackageManagerFoundErr)
+		return nil, internal.ErrorAs("basePackageManager.execute", noPackageManagerFoundErr)
+	}
+	if packages = pm.needed(operation, packages, &syscall); len(packages) < 1 {
+		return nil, nil
+	}
+	executable := pm.executable(operation)
+	resolved := packages
+	if operation == "install" {
+		resolved = pm.resolveVersions(packages, &syscall)
 	}
-	if len(packages) < 1 {
-		return nil
+	names, err := pm.packageNames(operation, resolved)
+	if err != nil {
+		return packages, internal.ErrorAs("basePackageManager.execute", err)
 	}
-	sudo := pm.Name != "brew"
-	formattedCommand := formatCommand(pm, operation)
-	packagesAndArgs := append(formattedCommand, packages...)
-	out, execErr := syscall.Exec(pm.Name, packagesAndArgs, sudo)
+	command := packageCommand{args: formatCommand(pm, operation), packages: packages, names: names, sudo: pm.NeedsRoot}
+	duration, execErr := runPackageCommands(&syscall, executable, operation, []packageCommand{command})
 	if execErr != nil {
-		return fmt.Errorf("%w: %s", execErr, string(out))
+		return packages, fmt.Errorf("%s %s failed: %w", executable, operation, execErr)
 	}
-	log.Printf("%d Packages finished running operation: %s\n", len(packages), operation)
-	return nil
+	slog.Info("packages finished running operation", "manager", pm.Name, "operation", operation,
+		"packages", len(packages), "duration", duration.Round(time.Millisecond))
+	return packages, nil
+}
+
+// executable returns the binary that does the operation, the manager's own unless it is overridden.
+func (pm *BasePackageManager) executable(operation string) string {
+	if override, ok := pm.Executables[operation]; ok {
+		return override
+	}
+	return pm.Name
 }
 
 // formatCommand will set the proper auto-confirm and quiet options on our management command.
 func formatCommand(pm *BasePackageManager, operation string) []string {
 	var args string
 	switch operation {
+	case "refresh":
+		args = pm.Args.RefreshArg
 	case "install":
 		args = pm.Args.InstallArg
 	case "uninstall":
@@ -169,55 +391,173 @@ func formatCommand(pm *BasePackageManager, operation string) []string {
 		args = pm.Args.UpgradeArg
 	}
 
-	return []string{
-		args,
-		pm.Opts.AutoConfirmOpt,
-		pm.Opts.QuietOpt,
+	// Managers without an option leave it empty, which must not end up as an empty argument.
+	var command []string
+	for _, arg := range []string{args, pm.Opts.AutoConfirmOpt, pm.Opts.QuietOpt} {
+		command = append(command, strings.Fields(arg)...)
 	}
+	return command
 }
 
-// Manager will get the current package manager as long as it is supprorted.
+// packageNames returns the packages as the manager wants them named for the operation.
+// Pinned packages are installed at their version, and otherwise named without it.
+func (pm *BasePackageManager) packageNames(operation string, packages []string) ([]string, error) {
+	names := make([]string, len(packages))
+	for i, spec := range parsePackageSpecs(packages) {
+		switch {
+		case spec.Version != "" && (operation == "install" || pm.VersionInName):
+			if pm.VersionFormat == "" {
+				return nil, fmt.Errorf("%s can't install a particular version of %s", pm.Name, spec.Name)
+			}
+			names[i] = fmt.Sprintf(pm.VersionFormat, spec.Name, spec.Version)
+		case operation == "install" && pm.InstallPrefix != "" && !strings.Contains(spec.Name, "#"):
+			// Packages that already say where they come from, like nix's flake#package, are left alone.
+			names[i] = pm.InstallPrefix + spec.Name
+		default:
+			names[i] = spec.Name
+		}
+	}
+	return names, nil
+}
+
+// Manager will get the current package manager as long as it is supported.
+// The manager is resolved when the config is parsed, so this is nil until then.
 func (gpm *GenericPackageManager) Manager() *BasePackageManager {
-	return supportedPackageManagers[gpm.config.ConfigSettings.Manager]
+	return supportedPackageManagers[gpm.resolvedManager]
 }
 
 // system returns the SysCall in use by the registry.
 func (gpm *GenericPackageManager) system() *SysCall {
 	sysCallMod := gpm.Application.ModuleRegistry.GetModule("SysCall")
-	return sysCallMod.(*SysCall)
+	return sysCallMod.(*SysCall).ForModule(gpm.Name)
 }
 
 // managePackages will take an operation such as "install" and install all packages in the PackageManagerMap.
+// An "upgrade" upgrades everything each manager that can has installed, rather than the packages.
+// A manager failing doesn't stop the others, every failure is returned once they have all run.
+// Uninstalling goes in reverse, so flatpaks, snaps and language packages are removed before the native packages
+// that may provide flatpak, snapd or the language itself.
 func (gpm *GenericPackageManager) managePackages(operation string) error {
-	for pm, packages := range gpm.PackageManagerMap {
-		switch operation {
-		case "install":
-			if err := pm.Install(packages, gpm.system()); err != nil {
-				return err
-			}
-		case "uninstall":
-			if err := pm.Uninstall(packages, gpm.system()); err != nil {
-				return err
-			}
-		case "update":
-			if err := pm.Update(packages, gpm.system()); err != nil {
-				return err
+	gpm.Results = nil
+	var errs []error
+	managers := gpm.orderedManagers()
+	if operation == "uninstall" {
+		slices.Reverse(managers)
+	}
+	for _, pm := range managers {
+		upgrader, canUpgrade := pm.(systemUpgrader)
+		if operation == "upgrade" && !canUpgrade {
+			continue
+		}
+		packages := gpm.PackageManagerMap[pm]
+		started := time.Now()
+		// Native managers skip the packages with nothing to do, which only those that ran have done.
+		ran := packages
+		err := gpm.releasePinned(pm, operation, packages)
+		if err == nil {
+			gpm.refreshBefore(pm, operation, packages)
+			native, isNative := pm.(*BasePackageManager)
+			switch {
+			case operation == "upgrade":
+				err = upgrader.Upgrade(gpm.system())
+			case isNative:
+				ran, err = native.manage(operation, packages, gpm.system())
+			case operation == "install":
+				err = pm.Install(packages, gpm.system())
+			case operation == "uninstall":
+				err = pm.Uninstall(packages, gpm.system())
+			case operation == "update":
+				err = pm.Update(packages, gpm.system())
 			}
 		}
+		var failed PackageErrors
+		errors.As(err, &failed)
+		var succeeded []string
+		if operation != "upgrade" {
+			succeeded = succeededPackages(ran, err)
+		}
+		var drifted []PackageDrift
+		if err == nil {
+			drifted, err = gpm.checkPinned(pm, operation, packages)
+		}
+		result := ManagerResult{
+			Manager:   managerName(pm),
+			Operation: operation,
+			Packages:  packages,
+			Duration:  time.Since(started),
+			Drift:     drifted,
+			Succeeded: succeeded,
+			Failed:    failed,
+			Err:       err,
+		}
+		gpm.Results = append(gpm.Results, result)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Manager, err))
+		}
+	}
+	logResults(gpm.Results)
+	if len(errs) > 0 {
+		return internal.ErrorAs("GenericPackageManager.managePackages", errors.Join(errs...))
 	}
 	return nil
 }
 
+// orderedManagers returns the managers in the PackageManagerMap, those in the order setting first.
+// The rest run native ones first, since flatpak, snap and the language toolchains are often installed by them.
+func (gpm *GenericPackageManager) orderedManagers() []packageManager {
+	rank := func(pm packageManager) int {
+		if i := slices.Index(gpm.order, pm); i >= 0 {
+			return i - len(gpm.order)
+		}
+		switch pm.(type) {
+		case *BasePackageManager:
+			return 0
+		case *flatpakManager:
+			return 1
+		default:
+			return 2
+		}
+	}
+	managers := make([]packageManager, 0, len(gpm.PackageManagerMap))
+	for pm := range gpm.PackageManagerMap {
+		managers = append(managers, pm)
+	}
+	sort.Slice(managers, func(i, j int) bool {
+		if rank(managers[i]) != rank(managers[j]) {
+			return rank(managers[i]) < rank(managers[j])
+		}
+		return managerName(managers[i]) < managerName(managers[j])
+	})
+	return managers
+}
+
 // supportedPackageManagers
 // Give it a string, get a BasePackageManager.
 var supportedPackageManagers = map[string]*BasePackageManager{
-	"apt":      &aptitude,
-	"apt-get":  &aptitude,
-	"Aptitude": &aptitude,
-	"brew":     &brew,
-	"dnf":      &dnf,
-	"pacman":   &pacman,
-	"zypper":   &zypper,
+	"apk":          &apk,
+	"apt":          &aptitude,
+	"apt-get":      &aptitude,
+	"Aptitude":     &aptitude,
+	"brew":         &brew,
+	"dnf":          &dnf,
+	"emerge":       &emerge,
+	"nix":          &nix,
+	"pacman":       &pacman,
+	"port":         &port,
+	"xbps":         &xbps,
+	"xbps-install": &xbps,
+	"yum":          &yum,
+	"zypper":       &zypper,
+}
+
+// supportedManagerNames returns the sorted names that can be used for the manager setting.
+func supportedManagerNames() []string {
+	names := make([]string, 0, len(supportedPackageManagers))
+	for name := range supportedPackageManagers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // package Manager methods &structs are below
@@ -226,40 +566,57 @@ var supportedPackageManagers = map[string]*BasePackageManager{
 //	return pm.Name
 //}
 
-// brew is the package Manager for Mac
+// brew is the package Manager for Mac, it refuses to run as root
 var brew = BasePackageManager{
-	Name: "brew",
+	Name:      "brew",
+	NeedsRoot: false,
 	Args: packageManagerArgs{
+		RefreshArg:   "",
 		InstallArg:   "install",
 		UninstallArg: "uninstall",
-		UpdateArg:    "update",
+		UpdateArg:    "upgrade",
 		UpgradeArg:   "upgrade",
 	},
 	Opts: packageManagerOpts{
 		AutoConfirmOpt: "",
 		QuietOpt:       "-q",
 	},
+	VersionFormat: "%s@%s",
+	VersionInName: true,
+	Holds:         &packageHolds{Hold: []string{"brew", "pin"}, Unhold: []string{"brew", "unpin"}},
+	Query:         &brewQuery,
+	Explicit:      &brewLeavesList,
 }
 
 // aptitude // apt-get // apt is for debian based distros
 var aptitude = BasePackageManager{
-	Name: "apt-get",
+	Name:      "apt-get",
+	NeedsRoot: true,
 	Args: packageManagerArgs{
+		RefreshArg:   "update",
 		InstallArg:   "install",
 		UninstallArg: "remove",
-		UpdateArg:    "install",
-		UpgradeArg:   "upgrade",
+		UpdateArg:    "install --only-upgrade",
+		UpgradeArg:   "dist-upgrade",
 	},
 	Opts: packageManagerOpts{
 		AutoConfirmOpt: "-y",
 		QuietOpt:       "-qq",
 	},
+	// apt wants the exact Debian version, epoch and revision included, which Available finds for git@2.43.0.
+	VersionFormat: "%s=%s",
+	Holds:         &packageHolds{Hold: []string{"apt-mark", "hold"}, Unhold: []string{"apt-mark", "unhold"}},
+	Query:         &dpkgQuery,
+	Available:     &aptMadison,
+	Explicit:      &aptManualList,
 }
 
 // dnf is for RPM / Redhat-like distros
 var dnf = BasePackageManager{
-	Name: "dnf",
+	Name:      "dnf",
+	NeedsRoot: true,
 	Args: packageManagerArgs{
+		RefreshArg:   "",
 		InstallArg:   "install",
 		UninstallArg: "remove",
 		UpdateArg:    "upgrade",
@@ -269,34 +626,164 @@ var dnf = BasePackageManager{
 		AutoConfirmOpt: "-y",
 		QuietOpt:       "-q",
 	},
+	VersionFormat: "%s-%s",
+	Holds:         &packageHolds{Hold: []string{"dnf", "versionlock", "add"}, Unhold: []string{"dnf", "versionlock", "delete"}},
+	Query:         &rpmQuery,
+	Explicit:      &dnfUserInstalledList,
 }
 
-// pacman is for arch
+// pacman is for arch. Arch doesn't support partial upgrades, so there is no refresh: syncing the databases without
+// upgrading the system is left to upgrade, which does both, and installs use the databases there are.
 var pacman = BasePackageManager{
-	Name: "pacman",
+	Name:      "pacman",
+	NeedsRoot: true,
 	Args: packageManagerArgs{
-		InstallArg:   "-S",
+		RefreshArg:   "",
+		InstallArg:   "-S --needed",
 		UninstallArg: "-R",
-		UpdateArg:    "-Syu",
+		UpdateArg:    "-S --needed",
 		UpgradeArg:   "-Syu",
 	},
 	Opts: packageManagerOpts{
 		AutoConfirmOpt: "--noconfirm",
 		QuietOpt:       "--quiet",
 	},
+	Query:    &pacmanQuery,
+	Explicit: &pacmanExplicitList,
 }
 
 // zypper is for Suse
 var zypper = BasePackageManager{
-	Name: "zypper",
+	Name:      "zypper",
+	NeedsRoot: true,
 	Args: packageManagerArgs{
+		RefreshArg:   "",
 		InstallArg:   "in",
 		UninstallArg: "rm",
-		UpdateArg:    "in",
+		UpdateArg:    "up",
 		UpgradeArg:   "up",
 	},
 	Opts: packageManagerOpts{
 		AutoConfirmOpt: "--non-interactive",
 		QuietOpt:       "--quiet",
 	},
+	VersionFormat: "%s=%s",
+	Holds:         &packageHolds{Hold: []string{"zypper", "addlock"}, Unhold: []string{"zypper", "removelock"}},
+	Query:         &rpmQuery,
+}
+
+// yum is for older RPM / Redhat-like distros that don't have dnf yet, such as CentOS 7
+var yum = BasePackageManager{
+	Name:      "yum",
+	NeedsRoot: true,
+	Args: packageManagerArgs{
+		RefreshArg:   "",
+		InstallArg:   "install",
+		UninstallArg: "remove",
+		UpdateArg:    "update",
+		UpgradeArg:   "update",
+	},
+	Opts: packageManagerOpts{
+		AutoConfirmOpt: "-y",
+		QuietOpt:       "-q",
+	},
+	VersionFormat: "%s-%s",
+	Holds:         &packageHolds{Hold: []string{"yum", "versionlock", "add"}, Unhold: []string{"yum", "versionlock", "delete"}},
+	Query:         &rpmQuery,
+	Explicit:      &yumUserInstalledList,
+}
+
+// apk is for Alpine, --no-cache keeps the package index out of our images
+var apk = BasePackageManager{
+	Name:      "apk",
+	NeedsRoot: true,
+	Args: packageManagerArgs{
+		RefreshArg:   "",
+		InstallArg:   "add --no-cache",
+		UninstallArg: "del",
+		UpdateArg:    "upgrade --no-cache",
+		UpgradeArg:   "upgrade --no-cache",
+	},
+	Opts: packageManagerOpts{
+		AutoConfirmOpt: "",
+		QuietOpt:       "-q",
+	},
+	VersionFormat: "%s=%s",
+}
+
+// xbps is for Void, packages are removed by xbps-remove rather than xbps-install
+var xbps = BasePackageManager{
+	Name:      "xbps-install",
+	NeedsRoot: true,
+	Args: packageManagerArgs{
+		RefreshArg:   "-S",
+		InstallArg:   "-S",
+		UninstallArg: "",
+		UpdateArg:    "-u",
+		UpgradeArg:   "-u",
+	},
+	Opts: packageManagerOpts{
+		AutoConfirmOpt: "-y",
+		QuietOpt:       "",
+	},
+	Executables:   map[string]string{"uninstall": "xbps-remove"},
+	VersionFormat: "%s-%s",
+	Holds:         &packageHolds{Hold: []string{"xbps-pkgdb", "-m", "hold"}, Unhold: []string{"xbps-pkgdb", "-m", "unhold"}},
+}
+
+// emerge is for Gentoo, --noreplace skips packages that are already installed
+// and --depclean only removes packages nothing else depends on
+var emerge = BasePackageManager{
+	Name:      "emerge",
+	NeedsRoot: true,
+	Args: packageManagerArgs{
+		RefreshArg:   "--sync",
+		InstallArg:   "--noreplace",
+		UninstallArg: "--depclean",
+		UpdateArg:    "--update",
+		UpgradeArg:   "--update --deep --newuse @world",
+	},
+	Opts: packageManagerOpts{
+		AutoConfirmOpt: "--ask=n",
+		QuietOpt:       "--quiet",
+	},
+	VersionFormat: "=%s-%s",
+}
+
+// nixFeatures turns on the parts of nix that `nix profile` and flakes need, for installs where they are still off.
+const nixFeatures = "--extra-experimental-features nix-command --extra-experimental-features flakes"
+
+// nix is for NixOS, or nix anywhere, installing into the user's profile from nixpkgs
+var nix = BasePackageManager{
+	Name:      "nix",
+	NeedsRoot: false,
+	Args: packageManagerArgs{
+		RefreshArg:   "",
+		InstallArg:   "profile install " + nixFeatures,
+		UninstallArg: "profile remove " + nixFeatures,
+		UpdateArg:    "profile upgrade " + nixFeatures,
+		UpgradeArg:   "profile upgrade --all " + nixFeatures,
+	},
+	Opts: packageManagerOpts{
+		AutoConfirmOpt: "",
+		QuietOpt:       "--quiet",
+	},
+	InstallPrefix: "nixpkgs#",
+}
+
+// port is MacPorts for Mac, its options go before the action so they are part of each arg
+var port = BasePackageManager{
+	Name:      "port",
+	NeedsRoot: true,
+	Args: packageManagerArgs{
+		RefreshArg:   "-N -q sync",
+		InstallArg:   "-N -q install",
+		UninstallArg: "-N -q uninstall",
+		UpdateArg:    "-N -q upgrade",
+		UpgradeArg:   "-N -q upgrade outdated",
+	},
+	Opts: packageManagerOpts{
+		AutoConfirmOpt: "",
+		QuietOpt:       "",
+	},
 }
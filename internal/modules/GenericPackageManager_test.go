@@ -0,0 +1,299 @@
+package modules
+
+import (
+	"errors"
+	"github.com/Linkinlog/gasible/internal/app"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// packageManagerCommands is what each supported package manager should run for each operation on "git" and "curl".
+// Refreshing the index and upgrading the whole system don't take the packages, and managers that refresh
+// their index themselves have no refresh.
+var packageManagerCommands = map[string]map[string]fakeCommand{
+	"apt": {
+		"install":   {Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "apt-get", "remove", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "apt-get", "install", "--only-upgrade", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "apt-get", "dist-upgrade", "-y", "-qq"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+	},
+	"apt-get": {
+		"install":   {Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "apt-get", "remove", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "apt-get", "install", "--only-upgrade", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "apt-get", "dist-upgrade", "-y", "-qq"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+	},
+	"Aptitude": {
+		"install":   {Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "apt-get", "remove", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "apt-get", "install", "--only-upgrade", "-y", "-qq", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "apt-get", "dist-upgrade", "-y", "-qq"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+	},
+	"brew": {
+		"install":   {Argv: []string{"brew", "install", "-q", "git", "curl"}},
+		"uninstall": {Argv: []string{"brew", "uninstall", "-q", "git", "curl"}},
+		"update":    {Argv: []string{"brew", "upgrade", "-q", "git", "curl"}},
+		"upgrade":   {Argv: []string{"brew", "upgrade", "-q"}},
+	},
+	"dnf": {
+		"install":   {Argv: []string{"sudo", "dnf", "install", "-y", "-q", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "dnf", "remove", "-y", "-q", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "dnf", "upgrade", "-y", "-q", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "dnf", "upgrade", "-y", "-q"}, Sudo: true},
+	},
+	"pacman": {
+		"install":   {Argv: []string{"sudo", "pacman", "-S", "--needed", "--noconfirm", "--quiet", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "pacman", "-R", "--noconfirm", "--quiet", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "pacman", "-S", "--needed", "--noconfirm", "--quiet", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "pacman", "-Syu", "--noconfirm", "--quiet"}, Sudo: true},
+	},
+	"apk": {
+		"install":   {Argv: []string{"sudo", "apk", "add", "--no-cache", "-q", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "apk", "del", "-q", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "apk", "upgrade", "--no-cache", "-q", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "apk", "upgrade", "--no-cache", "-q"}, Sudo: true},
+	},
+	"emerge": {
+		"install":   {Argv: []string{"sudo", "emerge", "--noreplace", "--ask=n", "--quiet", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "emerge", "--depclean", "--ask=n", "--quiet", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "emerge", "--update", "--ask=n", "--quiet", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "emerge", "--update", "--deep", "--newuse", "@world", "--ask=n", "--quiet"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "emerge", "--sync", "--ask=n", "--quiet"}, Sudo: true},
+	},
+	"nix": {
+		"install":   {Argv: []string{"nix", "profile", "install", "--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes", "--quiet", "nixpkgs#git", "nixpkgs#curl"}},
+		"uninstall": {Argv: []string{"nix", "profile", "remove", "--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes", "--quiet", "git", "curl"}},
+		"update":    {Argv: []string{"nix", "profile", "upgrade", "--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes", "--quiet", "git", "curl"}},
+		"upgrade":   {Argv: []string{"nix", "profile", "upgrade", "--all", "--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes", "--quiet"}},
+	},
+	"port": {
+		"install":   {Argv: []string{"sudo", "port", "-N", "-q", "install", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "port", "-N", "-q", "uninstall", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "port", "-N", "-q", "upgrade", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "port", "-N", "-q", "upgrade", "outdated"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "port", "-N", "-q", "sync"}, Sudo: true},
+	},
+	"xbps": {
+		"install":   {Argv: []string{"sudo", "xbps-install", "-S", "-y", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "xbps-remove", "-y", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "xbps-install", "-u", "-y", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "xbps-install", "-u", "-y"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "xbps-install", "-S", "-y"}, Sudo: true},
+	},
+	"xbps-install": {
+		"install":   {Argv: []string{"sudo", "xbps-install", "-S", "-y", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "xbps-remove", "-y", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "xbps-install", "-u", "-y", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "xbps-install", "-u", "-y"}, Sudo: true},
+		"refresh":   {Argv: []string{"sudo", "xbps-install", "-S", "-y"}, Sudo: true},
+	},
+	"yum": {
+		"install":   {Argv: []string{"sudo", "yum", "install", "-y", "-q", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "yum", "remove", "-y", "-q", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "yum", "update", "-y", "-q", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "yum", "update", "-y", "-q"}, Sudo: true},
+	},
+	"zypper": {
+		"install":   {Argv: []string{"sudo", "zypper", "in", "--non-interactive", "--quiet", "git", "curl"}, Sudo: true},
+		"uninstall": {Argv: []string{"sudo", "zypper", "rm", "--non-interactive", "--quiet", "git", "curl"}, Sudo: true},
+		"update":    {Argv: []string{"sudo", "zypper", "up", "--non-interactive", "--quiet", "git", "curl"}, Sudo: true},
+		"upgrade":   {Argv: []string{"sudo", "zypper", "up", "--non-interactive", "--quiet"}, Sudo: true},
+	},
+}
+
+// installedGitAndCurl is what each query prints when git and curl are both installed.
+var installedGitAndCurl = map[*packageQuery]string{
+	&dpkgQuery:   "git\t1:2.43.0-1\tii \ncurl\t8.5.0-2\tii \n",
+	&rpmQuery:    "git\t2.43.0-1.fc40\ncurl\t8.6.0-7.fc40\n",
+	&pacmanQuery: "git 2.43.0-1\ncurl 8.6.0-1\n",
+	&brewQuery:   "git 2.43.0\ncurl 8.6.0\n",
+}
+
+// expectQuery returns the query the manager runs before installing or removing git and curl, finding neither
+// installed before an install and both before an uninstall, so the operation goes ahead. Nil when it has no query.
+func expectQuery(pm *BasePackageManager, operation string) []fakeCommand {
+	if pm.Query == nil || operation == "update" || operation == "upgrade" || operation == "refresh" {
+		return nil
+	}
+	query := fakeCommand{Argv: append(append([]string{}, pm.Query.Command...), "git", "curl"), ExitCode: 1}
+	if operation == "uninstall" {
+		query.Stdout, query.ExitCode = installedGitAndCurl[pm.Query], 0
+	}
+	return []fakeCommand{query}
+}
+
+func TestBasePackageManagerExecute(t *testing.T) {
+	for name, pm := range supportedPackageManagers {
+		commands, ok := packageManagerCommands[name]
+		if !ok {
+			t.Errorf("no expected commands for supported package manager %s", name)
+			continue
+		}
+		for operation, expected := range commands {
+			pm, operation, expected := pm, operation, expected
+			t.Run(name+"/"+operation, func(t *testing.T) {
+				call := newFakeSysCall(t, newFakeRunner(t).expect(expectQuery(pm, operation)...).expect(expected))
+				var err error
+				switch operation {
+				case "refresh":
+					err = pm.Refresh(call)
+				case "upgrade":
+					err = pm.Upgrade(call)
+				default:
+					err = pm.execute(operation, []string{"git", "curl"}, *call)
+				}
+				if err != nil {
+					t.Fatalf("%s returned an error: %v", operation, err)
+				}
+			})
+		}
+	}
+}
+
+func TestBasePackageManagerExecuteFailure(t *testing.T) {
+	failing := fakeCommand{
+		Argv:     []string{"sudo", "apt-get", "install", "-y", "-qq", "not-a-package"},
+		Sudo:     true,
+		Stderr:   "E: Unable to locate package not-a-package\n",
+		ExitCode: 100,
+	}
+	query := fakeCommand{
+		Argv:     append(append([]string{}, dpkgQuery.Command...), "not-a-package"),
+		Stderr:   "dpkg-query: no packages found matching not-a-package\n",
+		ExitCode: 1,
+	}
+	call := newFakeSysCall(t, newFakeRunner(t).expect(query, failing))
+
+	err := aptitude.execute("install", []string{"not-a-package"}, *call)
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		t.Fatalf("got error %v, want a *CommandError", err)
+	}
+	if commandErr.Result.ExitCode != 100 {
+		t.Errorf("got exit code %d, want 100", commandErr.Result.ExitCode)
+	}
+}
+
+func TestBasePackageManagerExecuteNothingToDo(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t))
+	if err := aptitude.execute("install", nil, *call); err != nil {
+		t.Fatalf("execute returned an error: %v", err)
+	}
+
+	var missing *BasePackageManager
+	if err := missing.execute("install", []string{"git"}, *call); err == nil {
+		t.Fatal("execute on a nil package manager returned no error")
+	}
+}
+
+func TestNixInstallKeepsFlakeReferences(t *testing.T) {
+	expected := fakeCommand{Argv: []string{"nix", "profile", "install", "--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes", "--quiet", "nixpkgs#git", "github:nix-community/home-manager#home-manager"}}
+	call := newFakeSysCall(t, newFakeRunner(t).expect(expected))
+	if err := nix.execute("install", []string{"git", "github:nix-community/home-manager#home-manager"}, *call); err != nil {
+		t.Fatalf("execute returned an error: %v", err)
+	}
+}
+
+func TestOrderedManagersRunNativeFirst(t *testing.T) {
+	gpm := &GenericPackageManager{PackageManagerMap: map[packageManager][]string{
+		&snapManager{}:    {"htop"},
+		&flatpakManager{}: {"org.mozilla.firefox"},
+		&dnf:              {"flatpak", "snapd"},
+	}}
+	managers := gpm.orderedManagers()
+	if _, native := managers[0].(*BasePackageManager); !native {
+		t.Fatalf("got %T first, want the native manager", managers[0])
+	}
+	if _, flatpak := managers[1].(*flatpakManager); !flatpak {
+		t.Errorf("got %T second, want flatpak", managers[1])
+	}
+}
+
+func TestTeardownRemovesNativePackagesLast(t *testing.T) {
+	settings := map[string]interface{}{
+		"packages": []string{"flatpak"},
+		"flatpak":  map[string]interface{}{"packages": []string{"org.mozilla.firefox"}},
+	}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "flatpak", "uninstall", "--system", "-y", "--noninteractive", "org.mozilla.firefox"}, Sudo: true},
+		fakeCommand{Argv: append(append([]string{}, rpmQuery.Command...), "flatpak"), Stdout: "flatpak\t1.15.8-1.fc40\n"},
+		fakeCommand{Argv: []string{"sudo", "dnf", "remove", "-y", "-q", "flatpak"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.TearDown(); err != nil {
+		t.Fatalf("TearDown returned an error: %v", err)
+	}
+}
+
+// newTestPackageManager returns a pointer to a GenericPackageManager parsed from settings on a Fedora machine,
+// running its commands through fake.
+func newTestPackageManager(t *testing.T, settings map[string]interface{}, fake sysCommand) *GenericPackageManager {
+	t.Helper()
+	stubDetection(t, "ID=fedora\n", "dnf", "brew", "flatpak")
+	application := app.New()
+	if err := application.UseRoot(t.TempDir()); err != nil {
+		t.Fatalf("unable to sandbox the app: %v", err)
+	}
+	t.Cleanup(func() { _ = application.Close() })
+	call := newFakeSysCall(t, fake)
+	call.SetApp(application)
+	application.ModuleRegistry.Register(call)
+
+	gpm := &GenericPackageManager{Name: "GenericPackageManager", PackageManagerMap: make(map[packageManager][]string)}
+	gpm.SetApp(application)
+	if err := gpm.ParseConfig(map[string]interface{}{"enabled": true, "settings": settings}); err != nil {
+		t.Fatalf("ParseConfig returned an error: %v", err)
+	}
+	return gpm
+}
+
+func TestMultipleManagers(t *testing.T) {
+	settings := map[string]interface{}{
+		"manager":  "auto",
+		"packages": []string{"openssl-devel"},
+		"managers": map[string][]string{
+			"brew":    {"jq"},
+			"flatpak": {"org.mozilla.firefox"},
+			"dnf":     {"gcc"},
+		},
+		"order": []string{"flatpak"},
+	}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "flatpak", "install", "--system", "-y", "--noninteractive", "org.mozilla.firefox"}, Sudo: true},
+		fakeCommand{Argv: []string{"brew", "list", "--versions", "jq"}, ExitCode: 1},
+		fakeCommand{Argv: []string{"brew", "install", "-q", "jq"}, ExitCode: 1, Stderr: "Error: brew is broken\n"},
+		fakeCommand{Argv: append(append([]string{}, rpmQuery.Command...), "openssl-devel", "gcc"), Stdout: "gcc\t14.1.1-7.fc40\n", ExitCode: 1},
+		fakeCommand{Argv: []string{"sudo", "dnf", "install", "-y", "-q", "openssl-devel"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+
+	err := gpm.Setup()
+	if err == nil || !strings.Contains(err.Error(), "brew") {
+		t.Fatalf("got error %v, want brew's failure", err)
+	}
+	var got []string
+	for _, result := range gpm.Results {
+		status := "ok"
+		if result.Err != nil {
+			status = "failed"
+		}
+		got = append(got, result.Manager+" "+strings.Join(result.Packages, ",")+" "+status)
+	}
+	want := []string{"flatpak org.mozilla.firefox ok", "brew jq failed", "dnf openssl-devel,gcc ok"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got results %q, want %q", got, want)
+	}
+}
+
+func TestMultipleManagersRejectsUnknownManager(t *testing.T) {
+	stubDetection(t, "ID=fedora\n", "dnf")
+	gpm := &GenericPackageManager{Name: "GenericPackageManager"}
+	settings := map[string]interface{}{"managers": map[string][]string{"chocolatey": {"git"}}}
+	if err := gpm.ParseConfig(map[string]interface{}{"settings": settings}); err == nil {
+		t.Fatal("ParseConfig accepted a manager we don't support")
+	}
+}
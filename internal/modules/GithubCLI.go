@@ -1,18 +1,15 @@
 package modules
 
 import (
-	"bufio"
 	"crypto/ed25519"
 	"crypto/rand"
-	"errors"
 	"fmt"
 	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/download"
+	"github.com/Linkinlog/gasible/internal/secrets"
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v3"
-	"log"
-	"os"
-	"os/exec"
-	"os/user"
+	"log/slog"
 	"path"
 	"strings"
 )
@@ -24,6 +21,7 @@ func init() {
 		name:     "GitHub",
 		Enabled:  true,
 		Settings: githubSettings{},
+		keyring:  githubCLIKeyring,
 	})
 	ToBeInstalled[&brew] = []string{"gh"}
 }
@@ -34,15 +32,35 @@ type github struct {
 	Enabled     bool
 	Settings    githubSettings
 	application *app.App
+	// keyring is how the GitHub CLI's repository key is downloaded and checked.
+	keyring download.Request
 }
 
-// githubSettings is the settings struct for the github module, allows user to set the token env key.
+// githubSettings is the settings struct for the github module, allows user to set where the token comes from.
+// TokenEnvKey is the original shorthand for `token: {env: ...}` and is still honored.
 type githubSettings struct {
-	token       string `yaml:"-"`
-	TokenEnvKey string `yaml:"token-env-key"`
-	SshKeyPath  string `yaml:"-"`
+	token       string         `yaml:"-"`
+	Token       secrets.Source `yaml:"token,omitempty"`
+	TokenEnvKey string         `yaml:"token-env-key"`
+	SshKeyPath  string         `yaml:"-"`
 }
 
+// defaultTokenPrompt is what we ask the user when no other token source gives us a token.
+const defaultTokenPrompt = "Enter GitHub token"
+
+// githubCLIKeyring is the GPG key for the GitHub CLI's apt repository, the root of trust for everything installed from it.
+// It is pinned by the fingerprint GitHub publishes, which stays the same when the key's expiry is extended.
+var githubCLIKeyring = download.Request{
+	URL:         "https://cli.github.com/packages/githubcli-archive-keyring.gpg",
+	Fingerprint: "2C6106201985B60E6C7AC87323F3D4EA75716059",
+}
+
+// githubCLIKeyringPath is where apt expects the GPG key for the GitHub CLI's repository.
+const githubCLIKeyringPath = "/usr/share/keyrings/githubcli-archive-keyring.gpg"
+
+// githubCLISourcesPath is the apt sources list for the GitHub CLI's repository.
+const githubCLISourcesPath = "/etc/apt/sources.list.d/github-cli.list"
+
 // SetApp sets the application field as the app that is passed in.
 func (gh *github) SetApp(app *app.App) {
 	gh.application = app
@@ -91,12 +109,15 @@ func (gh *github) TearDown() error {
 // Setup will install `gh` and log in to the CLI application, then add an SSH key to GitHub.
 func (gh *github) Setup() error {
 	// TODO figure out other package managers
-	if _, err := exec.LookPath("apt-get"); err == nil {
+	if _, err := lookPath("apt-get"); err == nil {
 		gh.installGH()
 	}
 	// else check if gh is installed, so we don't explode if it's not
 
-	gh.getTokenFromUser()
+	tokenErr := gh.getTokenFromUser()
+	if tokenErr != nil {
+		return tokenErr
+	}
 	// use token to login
 	err := gh.authLogin()
 	if err != nil {
@@ -104,6 +125,9 @@ func (gh *github) Setup() error {
 	}
 	// generate / prompt for the ssh key,
 	// then add the ssh key to gh
+	if gh.application != nil && gh.application.FS.Root() != "" {
+		gh.logger().Warn("the sandbox's SSH key is added to your real GitHub account, teardown removes it")
+	}
 	sshErr := gh.addSSHKey("Gasible-Generated-Key")
 	if sshErr != nil {
 		return sshErr
@@ -121,46 +145,55 @@ func (gh *github) Update() error {
 	}
 }
 
+// logger returns the default logger with this module and the running action attached.
+func (gh *github) logger() *slog.Logger {
+	return slog.With("module", gh.name, "action", gh.application.Action)
+}
+
 // system returns the Syscall that is currently in use by the module registry.
 func (gh *github) system() *SysCall {
 	sysCallMod := gh.application.ModuleRegistry.GetModule("SysCall")
-	return sysCallMod.(*SysCall)
+	return sysCallMod.(*SysCall).ForModule(gh.name)
 }
 
-// getTokenFromUser will check the TokenEnvKey for a key or prompt the user for one if one isn't found.
-func (gh *github) getTokenFromUser() {
-	if ghToke, ok := os.LookupEnv(gh.Settings.TokenEnvKey); ok {
-		gh.Settings.token = ghToke
-		return
-	}
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter GitHub token: ")
-	token, err := reader.ReadString('\n')
+// getTokenFromUser will resolve the token from the configured secret sources,
+// prompting the user for one (without echo) if none of them have it.
+func (gh *github) getTokenFromUser() error {
+	token, err := gh.tokenSource().Resolve(gh.application.FS)
 	if err != nil {
-		log.Fatalf("getTokenFromUser error: %q", err)
-	} else {
-		// Trim the newline from the token
-		gh.Settings.token = strings.TrimSuffix(token, "\n")
+		return fmt.Errorf("getTokenFromUser error: %w", err)
 	}
+	gh.Settings.token = token
+	return nil
+}
+
+// tokenSource merges the token-env-key shorthand into the token source and makes sure we can fall back to a prompt.
+func (gh *github) tokenSource() secrets.Source {
+	source := gh.Settings.Token
+	if source.Env == "" {
+		source.Env = gh.Settings.TokenEnvKey
+	}
+	if source.Prompt == "" {
+		source.Prompt = defaultTokenPrompt
+	}
+	return source
 }
 
 // authLogin runs the auth login --with-token command to authenticate with gh.
 func (gh *github) authLogin() error {
 	// use token to run `gh auth login --with-token`
-	resp, err := gh.system().ExecWithInput("gh", []string{"auth", "login", "--with-token"}, gh.Settings.token, false)
+	_, err := gh.system().ExecWithInput("gh", []string{"auth", "login", "--with-token"}, gh.Settings.token, false)
 	if err != nil {
-		return fmt.Errorf("authLogin error: %w \n more details: %s", err, string(resp))
-	} else {
-		return nil
+		return fmt.Errorf("authLogin error: %w", err)
 	}
+	return nil
 }
 
 // authLogout runs the auth logout --hostname github.com to de-authenticate with gh.
 func (gh *github) authLogout() error {
-	resp, err := gh.system().Exec("gh", []string{"auth", "logout", "--hostname", "github.com"}, false)
+	_, err := gh.system().Exec("gh", []string{"auth", "logout", "--hostname", "github.com"}, false)
 	if err != nil {
-		log.Fatal(resp, err)
-		return err
+		return fmt.Errorf("authLogout error: %w", err)
 	}
 	return nil
 }
@@ -169,31 +202,29 @@ func (gh *github) authLogout() error {
 func (gh *github) addSSHKey(title string) error {
 	// create a new ssh key or specify an existing one.
 	if gh.Settings.SshKeyPath == "" {
-		keyPath, sshErr := generateSSHKeys("github-gasible")
+		keyPath, sshErr := gh.generateSSHKeys("github-gasible")
 		if sshErr != nil {
-			log.Fatal(sshErr)
+			return sshErr
 		}
 		gh.Settings.SshKeyPath = keyPath + ".pub"
 	}
 	// use it with `gh ssh-key add "FILEPATH" --title "TITLE"`.
-	out, err := gh.system().Exec("gh", []string{"ssh-key", "add", gh.Settings.SshKeyPath, "--title", title}, false)
+	_, err := gh.system().Exec("gh", []string{"ssh-key", "add", gh.Settings.SshKeyPath, "--title", title}, false)
 	if err != nil {
-		var outputErr = errors.New(string(out))
-		return errors.Join(err, outputErr)
-	} else {
-		return nil
+		return fmt.Errorf("addSSHKey error: %w", err)
 	}
+	return nil
 }
 
 // getSSHKeyIDs is to retrieve all SSH keys that we believe have been made by this program.
 func (gh *github) getSSHKeyIDs(sshKeyName string) ([]string, error) {
-	out, err := gh.system().Exec("gh", []string{"ssh-key", "list"}, false)
+	result, err := gh.system().Exec("gh", []string{"ssh-key", "list"}, false)
 	if err != nil {
-		return []string{string(out)}, err
+		return nil, fmt.Errorf("getSSHKeyIDs error: %w", err)
 	}
 
 	var ids []string
-	lines := strings.Split(string(out), "\n")
+	lines := strings.Split(result.Stdout, "\n")
 	for _, line := range lines {
 		fields := strings.Fields(line)
 		if len(fields) > 4 && fields[0] == sshKeyName {
@@ -215,9 +246,9 @@ func (gh *github) removeSSHKeys() error {
 		return err
 	}
 	for _, id := range ids {
-		out, runErr := gh.system().Exec("gh", []string{"ssh-key", "delete", id, "-y"}, false)
+		_, runErr := gh.system().Exec("gh", []string{"ssh-key", "delete", id, "-y"}, false)
 		if runErr != nil {
-			return errors.Join(runErr, errors.New(string(out)))
+			return fmt.Errorf("removeSSHKeys error: %w", runErr)
 		}
 	}
 	return nil
@@ -225,107 +256,77 @@ func (gh *github) removeSSHKeys() error {
 
 // installGh installs the gh cli application.
 func (gh *github) installGH() {
-	// Step 1: Check if curl is installed, if not Install it
-	if _, err := exec.LookPath("curl"); err != nil {
-		// curl is not installed, Install it
-		if _, execErr := gh.system().Exec("apt-get", []string{"update"}, true); execErr != nil {
-			_, printErr := fmt.Fprintf(os.Stderr, "Failed to Update apt package list: %v\n", execErr)
-			if printErr != nil {
-				return
-			}
-			return
-		}
-
-		if _, execErr := gh.system().Exec("apt-get", []string{"install", "curl", "-y"}, true); execErr != nil {
-			_, printErr := fmt.Fprintf(os.Stderr, "Failed to Install curl: %v\n", execErr)
-			if printErr != nil {
-				return
-			}
-			return
-		}
+	// Step 1: Download the GPG key for the GitHub CLI's package repository and Install it
+	downloader, downloaderErr := gh.application.Downloader()
+	if downloaderErr != nil {
+		gh.logger().Error("failed to set up downloads", "error", downloaderErr)
+		return
 	}
-
-	// Step 2: Fetch the GPG key for the GitHub CLI's package repository and Install it
-	gpgURL := "https://cli.github.com/packages/githubcli-archive-keyring.gpg"
-	command := fmt.Sprintf(`curl -fsSL %s | sudo dd of=/usr/share/keyrings/githubcli-archive-keyring.gpg`, gpgURL)
-	if _, keyRingInstallErr := gh.system().Exec("sh", []string{"-c", command}, false); keyRingInstallErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to Install GPG key: %v\n", keyRingInstallErr)
-		if err != nil {
-			return
-		}
+	keyring, downloadErr := downloader.Get(gh.keyring)
+	if downloadErr != nil {
+		gh.logger().Error("failed to download GPG key", "error", downloadErr)
+		return
+	}
+	if keyRingInstallErr := gh.system().WriteFileAsRoot(githubCLIKeyringPath, keyring, 0644); keyRingInstallErr != nil {
+		gh.logger().Error("failed to install GPG key", "error", keyRingInstallErr)
 		return
 	}
 
-	// Step 3: Adds the GitHub CLI's package repository to aptitude's list of package sources
-	command = `deb [arch=$(dpkg --print-architecture) signed-by=/usr/share/keyrings/githubcli-archive-keyring.gpg] https://cli.github.com/packages stable main`
-	command = fmt.Sprintf(`echo "%s" | sudo tee /etc/apt/sources.list.d/github-cli.list > /dev/null`, command)
-	if _, sourcesInstallErr := gh.system().Exec("sh", []string{"-c", command}, false); sourcesInstallErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to add GitHub CLI's package repository: %v\n", sourcesInstallErr)
-		if err != nil {
-			return
-		}
+	// Step 2: Adds the GitHub CLI's package repository to aptitude's list of package sources
+	architecture, archErr := gh.system().Exec("dpkg", []string{"--print-architecture"}, false)
+	if archErr != nil {
+		gh.logger().Error("failed to find the system architecture", "error", archErr)
+		return
+	}
+	source := fmt.Sprintf("deb [arch=%s signed-by=%s] https://cli.github.com/packages stable main\n",
+		strings.TrimSpace(architecture.Stdout), githubCLIKeyringPath)
+	if sourcesInstallErr := gh.system().WriteFileAsRoot(githubCLISourcesPath, []byte(source), 0644); sourcesInstallErr != nil {
+		gh.logger().Error("failed to add GitHub CLI's package repository", "error", sourcesInstallErr)
 		return
 	}
 
-	// Step 4: Update the apt package lists again
+	// Step 3: Update the apt package lists
 	if _, updateErr := gh.system().Exec("apt-get", []string{"update"}, true); updateErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to Update apt package list: %v\n", updateErr)
-		if err != nil {
-			return
-		}
+		gh.logger().Error("failed to update apt package list", "error", updateErr)
 		return
 	}
 
-	// Step 5: Install the GitHub CLI
+	// Step 4: Install the GitHub CLI
 	if _, installErr := gh.system().Exec("apt-get", []string{"install", "gh", "-y"}, true); installErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to Install GitHub CLI: %v\n", installErr)
-		if err != nil {
-			return
-		}
+		gh.logger().Error("failed to install GitHub CLI", "error", installErr)
+		return
 	}
 
-	log.Println("Successfully installed GitHub CLI.")
+	gh.logger().Info("successfully installed GitHub CLI")
 }
 
 // uninstallGh uninstalls the gh cli application.
 func (gh *github) uninstallGH() {
 	// Step 1: Uninstall gh
 	if _, removeErr := gh.system().Exec("apt-get", []string{"remove", "gh", "-y"}, true); removeErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to Uninstall GitHub CLI: %v\n", removeErr)
-		if err != nil {
-			return
-		}
+		gh.logger().Error("failed to uninstall GitHub CLI", "error", removeErr)
 		return
 	}
 
 	// Step 2: Remove the repository from the list of sources
-	if _, sourcesRemoveErr := gh.system().Exec("rm", []string{"/etc/apt/sources.list.d/github-cli.list"}, true); sourcesRemoveErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to remove the repository from sources list: %v\n", sourcesRemoveErr)
-		if err != nil {
-			return
-		}
+	if _, sourcesRemoveErr := gh.system().Exec("rm", []string{githubCLISourcesPath}, true); sourcesRemoveErr != nil {
+		gh.logger().Error("failed to remove the repository from sources list", "error", sourcesRemoveErr)
 		return
 	}
 
 	// Step 3: Remove the keyring
-	if _, keyringRemoveErr := gh.system().Exec("rm", []string{"/usr/share/keyrings/githubcli-archive-keyring.gpg"}, true); keyringRemoveErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to remove the keyring: %v\n", keyringRemoveErr)
-		if err != nil {
-			return
-		}
+	if _, keyringRemoveErr := gh.system().Exec("rm", []string{githubCLIKeyringPath}, true); keyringRemoveErr != nil {
+		gh.logger().Error("failed to remove the keyring", "error", keyringRemoveErr)
 		return
 	}
 
 	// Step 4: Update the apt package lists after the changes
 	if _, aptUpdateErr := gh.system().Exec("apt-get", []string{"update"}, true); aptUpdateErr != nil {
-		_, err := fmt.Fprintf(os.Stderr, "Failed to Update apt package list: %v\n", aptUpdateErr)
-		if err != nil {
-			return
-		}
+		gh.logger().Error("failed to update apt package list", "error", aptUpdateErr)
 		return
 	}
 
-	log.Println("Successfully uninstalled GitHub CLI and cleaned up.")
+	gh.logger().Info("successfully uninstalled GitHub CLI and cleaned up")
 }
 
 // upgradeGH upgrades the gh cli application.
@@ -337,30 +338,39 @@ func (gh *github) upgradeGH() error {
 	}
 
 	// Update package lists for upgrades and installations
-	_, err = gh.system().Exec("sudo", []string{"apt", "update"}, false)
+	_, err = gh.system().Exec("apt", []string{"update"}, true)
 	if err != nil {
-		return fmt.Errorf("error running sudo apt Update: %v", err)
+		return fmt.Errorf("error running apt update: %w", err)
 	}
 
 	// Upgrade gh
-	_, err = gh.system().Exec("sudo", []string{"apt", "upgrade", "gh", "-y"}, false)
+	_, err = gh.system().Exec("apt", []string{"upgrade", "gh", "-y"}, true)
 	if err != nil {
-		return fmt.Errorf("error running sudo apt upgrade gh -y: %v", err)
+		return fmt.Errorf("error running apt upgrade gh -y: %w", err)
 	}
 
 	return nil
 }
 
-// generateSSHKeys will create new ssh keys for a given filename.
-func generateSSHKeys(fileName string) (string, error) {
-	keyPath := path.Join(userHomeDir(), ".ssh", fileName)
+// generateSSHKeys will create new ssh keys for a given filename in the user's ~/.ssh.
+func (gh *github) generateSSHKeys(fileName string) (string, error) {
+	fsys := gh.application.FS
+	homeDir, err := fsys.HomeDir()
+	if err != nil {
+		return "", err
+	}
+	sshDir := path.Join(homeDir, ".ssh")
+	if err = fsys.MkdirAll(sshDir, 0700); err != nil {
+		return "", err
+	}
+	keyPath := path.Join(sshDir, fileName)
 	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return "", err
 	}
 
 	privateKeyBytes := privateKey.Seed()
-	err = os.WriteFile(keyPath, privateKeyBytes, 0600)
+	err = fsys.WriteFile(keyPath, privateKeyBytes, 0600)
 	if err != nil {
 		return "", err
 	}
@@ -371,16 +381,10 @@ func generateSSHKeys(fileName string) (string, error) {
 	}
 
 	publicKeyBytes := ssh.MarshalAuthorizedKey(pub)
-	err = os.WriteFile(keyPath+".pub", publicKeyBytes, 0600)
+	err = fsys.WriteFile(keyPath+".pub", publicKeyBytes, 0600)
 	if err != nil {
 		return "", err
 	}
 
 	return keyPath, nil
 }
-
-// userHomeDir gets the home directory for the user.
-func userHomeDir() string {
-	usr, _ := user.Current()
-	return usr.HomeDir
-}
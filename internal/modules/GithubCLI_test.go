@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/download"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // matches what the downloader verifies with
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestGitHub returns a pointer to a github module running its commands through fake,
+// on what looks like a Debian machine with curl installed and a token in the config.
+func newTestGitHub(t *testing.T, action string, fake sysCommand) *github {
+	t.Helper()
+	realLookPath := lookPath
+	lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	t.Cleanup(func() { lookPath = realLookPath })
+
+	application := app.New()
+	if err := application.UseRoot(t.TempDir()); err != nil {
+		t.Fatalf("unable to sandbox the app: %v", err)
+	}
+	application.Action = action
+	t.Cleanup(func() { _ = application.Close() })
+	call := newFakeSysCall(t, fake)
+	call.SetApp(application)
+	application.ModuleRegistry.Register(call)
+
+	gh := &github{
+		name:    "GitHub",
+		Enabled: true,
+		keyring: githubCLIKeyring,
+		Settings: githubSettings{
+			Token:      secrets.Source{Value: "ghp_testtoken"},
+			SshKeyPath: "testdata/github-gasible.pub",
+		},
+	}
+	gh.SetApp(application)
+	return gh
+}
+
+// serveKeyring serves a stand-in for the GitHub CLI's repository key and pins the module to it,
+// unless we are recording a real session.
+func serveKeyring(t *testing.T, gh *github) {
+	t.Helper()
+	if *record {
+		return
+	}
+	entity, err := openpgp.NewEntity("Gasible Test", "", "test@gasible.invalid", nil)
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	var key bytes.Buffer
+	if err = entity.Serialize(&key); err != nil {
+		t.Fatalf("unable to serialize key: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(key.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	gh.keyring = download.Request{
+		URL:         server.URL + "/packages/githubcli-archive-keyring.gpg",
+		Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+	}
+}
+
+func TestGitHubSetup(t *testing.T) {
+	gh := newTestGitHub(t, "setup", replayFixture(t, "github-setup"))
+	serveKeyring(t, gh)
+	if err := gh.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+}
+
+func TestGitHubSetupLoginFailure(t *testing.T) {
+	fake := newFakeRunner(t)
+	gh := newTestGitHub(t, "setup", fake)
+	lookPath = func(file string) (string, error) { return "", errors.New("not found") }
+	fake.expect(fakeCommand{
+		Argv:     []string{"gh", "auth", "login", "--with-token"},
+		Stdin:    "ghp_testtoken",
+		Stderr:   "error validating token: HTTP 401: Bad credentials\n",
+		ExitCode: 1,
+	})
+
+	err := gh.Setup()
+	if err == nil || !strings.Contains(err.Error(), "Bad credentials") {
+		t.Fatalf("got error %v, want the login failure", err)
+	}
+}
+
+func TestGitHubTearDown(t *testing.T) {
+	gh := newTestGitHub(t, "teardown", replayFixture(t, "github-teardown"))
+	if err := gh.TearDown(); err != nil {
+		t.Fatalf("TearDown returned an error: %v", err)
+	}
+}
+
+func TestGitHubTearDownWithoutKeys(t *testing.T) {
+	fake := newFakeRunner(t).expect(fakeCommand{
+		Argv:   []string{"gh", "ssh-key", "list"},
+		Stdout: "laptop\tssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILaptopKey\t2022-01-04T09:30:00Z\t12345678\tauthentication\n",
+	})
+	gh := newTestGitHub(t, "teardown", fake)
+
+	err := gh.TearDown()
+	if err == nil || !strings.Contains(err.Error(), "Gasible-Generated-Key not found") {
+		t.Fatalf("got error %v, want the missing key", err)
+	}
+}
+
+func TestGitHubGeneratesSSHKeyInHome(t *testing.T) {
+	fake := newFakeRunner(t)
+	gh := newTestGitHub(t, "setup", fake)
+	gh.Settings.SshKeyPath = ""
+	home, _ := gh.application.FS.HomeDir()
+	keyPath := filepath.Join(home, ".ssh", "github-gasible.pub")
+	fake.expect(fakeCommand{Argv: []string{"gh", "ssh-key", "add", keyPath, "--title", "Gasible-Generated-Key"}})
+
+	if err := gh.addSSHKey("Gasible-Generated-Key"); err != nil {
+		t.Fatalf("addSSHKey returned an error: %v", err)
+	}
+	publicKey, err := gh.application.FS.ReadFile(keyPath)
+	if err != nil || !strings.HasPrefix(string(publicKey), "ssh-ed25519 ") {
+		t.Fatalf("got public key %q and error %v, want an ed25519 key", publicKey, err)
+	}
+}
@@ -0,0 +1,335 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// runLanguageCommands runs a language manager's commands as the current user, these managers install into the home directory.
+func runLanguageCommands(call *SysCall, manager string, operation string, commands []packageCommand) error {
+	packages := 0
+	for _, command := range commands {
+		packages += len(command.packages)
+	}
+	duration, err := runPackageCommands(call, manager, operation, commands)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", manager, operation, err)
+	}
+	if len(commands) > 0 {
+		slog.Info("packages finished running operation", "manager", manager, "operation", operation,
+			"packages", packages, "duration", duration.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// batch returns a command for the packages, named on the command line by their names.
+func batch(args []string, packages []packageSpec) packageCommand {
+	command := packageCommand{args: args}
+	for _, pkg := range packages {
+		command.packages = append(command.packages, pkg.String())
+		command.names = append(command.names, pkg.Name)
+	}
+	return command
+}
+
+// single returns a command for one package, named on the command line by name.
+func single(args []string, pkg packageSpec, name string, suffix ...string) packageCommand {
+	return packageCommand{args: args, packages: []string{pkg.String()}, names: []string{name}, suffix: suffix}
+}
+
+// withVersionFlag batches the packages that aren't pinned into one command and gives each pinned one its own,
+// for managers like cargo and gem whose version flag applies to everything on the command line.
+func withVersionFlag(args []string, flag string, packages []packageSpec) []packageCommand {
+	var commands []packageCommand
+	var unpinned []packageSpec
+	for _, pkg := range packages {
+		if pkg.Version == "" {
+			unpinned = append(unpinned, pkg)
+			continue
+		}
+		commands = append(commands, single(args, pkg, pkg.Name, flag, pkg.Version))
+	}
+	if len(unpinned) > 0 {
+		commands = append([]packageCommand{batch(args, unpinned)}, commands...)
+	}
+	return commands
+}
+
+// goManager implements packageManager with `go install`, into GOBIN or GOPATH/bin.
+// Each package gets its own command, go only installs several at once when they share a module.
+type goManager struct {
+	Name string
+}
+
+// majorVersionSuffix is the /vN on the end of a module path, which isn't part of the binary's name.
+var majorVersionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// Install installs each package at its version, or the latest one.
+func (gm *goManager) Install(specs []string, call *SysCall) error {
+	var commands []packageCommand
+	for _, pkg := range parsePackageSpecs(specs) {
+		version := pkg.Version
+		if version == "" {
+			version = "latest"
+		}
+		commands = append(commands, single([]string{"install"}, pkg, pkg.Name+"@"+version))
+	}
+	return runLanguageCommands(call, gm.Name, "install", commands)
+}
+
+// Update installs each package again, which moves unpinned ones to the latest version.
+func (gm *goManager) Update(specs []string, call *SysCall) error {
+	return gm.Install(specs, call)
+}
+
+// Uninstall removes each package's binary, go has no command for it.
+func (gm *goManager) Uninstall(specs []string, call *SysCall) error {
+	if len(specs) < 1 {
+		return nil
+	}
+	binDir, err := gm.binDir(call)
+	if err != nil {
+		return internal.ErrorAs("goManager.Uninstall", err)
+	}
+	for _, pkg := range parsePackageSpecs(specs) {
+		binary := filepath.Join(binDir, goBinaryName(pkg.Name))
+		if removeErr := call.filesystem().Remove(binary); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+			return internal.ErrorAs("goManager.Uninstall", removeErr)
+		}
+		slog.Debug("removed go binary", "package", pkg.Name, "path", binary)
+	}
+	slog.Info("packages finished running operation", "manager", gm.Name, "operation", "uninstall", "packages", len(specs))
+	return nil
+}
+
+// binDir asks go where it installs binaries, GOBIN when it is set and GOPATH/bin otherwise.
+func (gm *goManager) binDir(call *SysCall) (string, error) {
+	var noGoPathErr = errors.New("go env returned neither GOBIN nor GOPATH")
+	result, err := call.Exec(gm.Name, []string{"env", "GOBIN", "GOPATH"}, false)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(result.Stdout, "\n"), "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) != "" {
+		return strings.TrimSpace(lines[0]), nil
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		// GOPATH can be a list, go installs into the first entry.
+		return filepath.Join(filepath.SplitList(strings.TrimSpace(lines[1]))[0], "bin"), nil
+	}
+	return "", noGoPathErr
+}
+
+// goBinaryName returns the name go gives the binary for a package path, e.g. gopls for golang.org/x/tools/gopls.
+func goBinaryName(pkgPath string) string {
+	name := path.Base(pkgPath)
+	if majorVersionSuffix.MatchString(name) && strings.Contains(pkgPath, "/") {
+		name = path.Base(path.Dir(pkgPath))
+	}
+	return name
+}
+
+// cargoManager implements packageManager with `cargo install`, into ~/.cargo/bin.
+type cargoManager struct {
+	Name string
+}
+
+// Install installs the crates, pinned ones at their version.
+func (cm *cargoManager) Install(specs []string, call *SysCall) error {
+	packages := parsePackageSpecs(specs)
+	return runLanguageCommands(call, cm.Name, "install", withVersionFlag([]string{"install"}, "--version", packages))
+}
+
+// Update installs the crates again, cargo only rebuilds those with a newer version than the one installed.
+func (cm *cargoManager) Update(specs []string, call *SysCall) error {
+	return cm.Install(specs, call)
+}
+
+// Uninstall removes the crates' binaries.
+func (cm *cargoManager) Uninstall(specs []string, call *SysCall) error {
+	if len(specs) < 1 {
+		return nil
+	}
+	commands := []packageCommand{batch([]string{"uninstall"}, parsePackageSpecs(specs))}
+	return runLanguageCommands(call, cm.Name, "uninstall", commands)
+}
+
+// npmManager implements packageManager with `npm install -g`, into ~/.local rather than the system prefix.
+type npmManager struct {
+	Name string
+}
+
+// npmPrefix is where global npm packages go, under the home directory so they never need root.
+const npmPrefix = ".local"
+
+// Install installs the packages, pinned ones at their version.
+func (nm *npmManager) Install(specs []string, call *SysCall) error {
+	return nm.install("install", specs, "", call)
+}
+
+// Update installs each package again at its pinned version, or the latest one.
+func (nm *npmManager) Update(specs []string, call *SysCall) error {
+	return nm.install("update", specs, "latest", call)
+}
+
+// Uninstall removes the packages.
+func (nm *npmManager) Uninstall(specs []string, call *SysCall) error {
+	if len(specs) < 1 {
+		return nil
+	}
+	args, err := nm.args("uninstall", call)
+	if err != nil {
+		return internal.ErrorAs("npmManager.Uninstall", err)
+	}
+	return runLanguageCommands(call, nm.Name, "uninstall", []packageCommand{batch(args, parsePackageSpecs(specs))})
+}
+
+// install runs `npm install`, giving unpinned packages the default version when there is one.
+func (nm *npmManager) install(operation string, specs []string, defaultVersion string, call *SysCall) error {
+	if len(specs) < 1 {
+		return nil
+	}
+	args, err := nm.args("install", call)
+	if err != nil {
+		return internal.ErrorAs("npmManager."+operation, err)
+	}
+	command := packageCommand{args: args}
+	for _, pkg := range parsePackageSpecs(specs) {
+		version := pkg.Version
+		if version == "" {
+			version = defaultVersion
+		}
+		command.packages = append(command.packages, pkg.String())
+		if version == "" {
+			command.names = append(command.names, pkg.Name)
+		} else {
+			command.names = append(command.names, pkg.Name+"@"+version)
+		}
+	}
+	return runLanguageCommands(call, nm.Name, operation, []packageCommand{command})
+}
+
+// args returns the npm command for global packages under the home directory's prefix.
+func (nm *npmManager) args(command string, call *SysCall) ([]string, error) {
+	home, err := call.filesystem().HomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return []string{command, "--global", "--prefix", filepath.Join(home, npmPrefix)}, nil
+}
+
+// pipxManager implements packageManager with pipx, each package in its own virtualenv under the home directory.
+type pipxManager struct {
+	Name string
+}
+
+// Install installs the packages, pinned ones at their version.
+func (pm *pipxManager) Install(specs []string, call *SysCall) error {
+	if len(specs) < 1 {
+		return nil
+	}
+	command := packageCommand{args: []string{"install"}}
+	for _, pkg := range parsePackageSpecs(specs) {
+		command.packages = append(command.packages, pkg.String())
+		command.names = append(command.names, pm.requirement(pkg))
+	}
+	return runLanguageCommands(call, pm.Name, "install", []packageCommand{command})
+}
+
+// Update upgrades unpinned packages, and reinstalls pinned ones at their version since pipx upgrade can't pin.
+func (pm *pipxManager) Update(specs []string, call *SysCall) error {
+	var commands []packageCommand
+	for _, pkg := range parsePackageSpecs(specs) {
+		if pkg.Version == "" {
+			commands = append(commands, single([]string{"upgrade"}, pkg, pkg.Name))
+		} else {
+			commands = append(commands, single([]string{"install", "--force"}, pkg, pm.requirement(pkg)))
+		}
+	}
+	return runLanguageCommands(call, pm.Name, "update", commands)
+}
+
+// Uninstall removes each package and its virtualenv.
+func (pm *pipxManager) Uninstall(specs []string, call *SysCall) error {
+	var commands []packageCommand
+	for _, pkg := range parsePackageSpecs(specs) {
+		commands = append(commands, single([]string{"uninstall"}, pkg, pkg.Name))
+	}
+	return runLanguageCommands(call, pm.Name, "uninstall", commands)
+}
+
+// requirement returns the package as pip writes it, name==version when pinned.
+func (pm *pipxManager) requirement(pkg packageSpec) string {
+	if pkg.Version == "" {
+		return pkg.Name
+	}
+	return pkg.Name + "==" + pkg.Version
+}
+
+// gemManager implements packageManager with gem, into the user's gem directory.
+type gemManager struct {
+	Name string
+}
+
+// gemArgs are what every gem install and update needs to stay in the home directory and skip building docs.
+var gemArgs = []string{"--user-install", "--no-document"}
+
+// Install installs the gems, pinned ones at their version.
+func (gm *gemManager) Install(specs []string, call *SysCall) error {
+	packages := parsePackageSpecs(specs)
+	commands := withVersionFlag(append([]string{"install"}, gemArgs...), "--version", packages)
+	return runLanguageCommands(call, gm.Name, "install", commands)
+}
+
+// Update updates unpinned gems, and installs pinned ones at their version in case they aren't yet.
+func (gm *gemManager) Update(specs []string, call *SysCall) error {
+	var unpinned []packageSpec
+	var commands []packageCommand
+	for _, pkg := range parsePackageSpecs(specs) {
+		if pkg.Version == "" {
+			unpinned = append(unpinned, pkg)
+			continue
+		}
+		commands = append(commands, withVersionFlag(append([]string{"install"}, gemArgs...), "--version", []packageSpec{pkg})...)
+	}
+	if len(unpinned) > 0 {
+		commands = append([]packageCommand{batch(append([]string{"update"}, gemArgs...), unpinned)}, commands...)
+	}
+	return runLanguageCommands(call, gm.Name, "update", commands)
+}
+
+// Uninstall removes every version of the gems along with their executables, without asking.
+func (gm *gemManager) Uninstall(specs []string, call *SysCall) error {
+	if len(specs) < 1 {
+		return nil
+	}
+	command := batch([]string{"uninstall", "--user-install", "--all", "--executables"}, parsePackageSpecs(specs))
+	return runLanguageCommands(call, gm.Name, "uninstall", []packageCommand{command})
+}
+
+// The language managers, modules can use them in ToBeInstalled like the native ones.
+// Each is named after its binary, which also keeps them distinct map keys where pointers to empty structs might not be.
+var (
+	goInstall = goManager{Name: "go"}
+	cargo     = cargoManager{Name: "cargo"}
+	npmGlobal = npmManager{Name: "npm"}
+	pipx      = pipxManager{Name: "pipx"}
+	gem       = gemManager{Name: "gem"}
+)
+
+// languageManagers maps the names used in the config to the language managers.
+var languageManagers = map[string]packageManager{
+	"go":    &goInstall,
+	"cargo": &cargo,
+	"npm":   &npmGlobal,
+	"pipx":  &pipx,
+	"gem":   &gem,
+}
@@ -0,0 +1,162 @@
+package modules
+
+import (
+	"github.com/Linkinlog/gasible/internal/app"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoBinaryName(t *testing.T) {
+	tests := map[string]string{
+		"golang.org/x/tools/gopls":             "gopls",
+		"github.com/go-delve/delve/cmd/dlv":    "dlv",
+		"github.com/golang-migrate/migrate/v4": "migrate",
+	}
+	for pkgPath, want := range tests {
+		if got := goBinaryName(pkgPath); got != want {
+			t.Errorf("goBinaryName(%q) = %q, want %q", pkgPath, got, want)
+		}
+	}
+}
+
+// languageManagerCommands is what each language manager should run for each operation on the same two packages,
+// one pinned and one not. None of them should ever need sudo.
+var languageManagerCommands = []struct {
+	manager packageManager
+	specs   []string
+	install []fakeCommand
+	update  []fakeCommand
+	remove  []fakeCommand
+}{
+	{
+		manager: &cargo,
+		specs:   []string{"ripgrep", "bat@0.24.0"},
+		install: []fakeCommand{{Argv: []string{"cargo", "install", "ripgrep"}}, {Argv: []string{"cargo", "install", "bat", "--version", "0.24.0"}}},
+		update:  []fakeCommand{{Argv: []string{"cargo", "install", "ripgrep"}}, {Argv: []string{"cargo", "install", "bat", "--version", "0.24.0"}}},
+		remove:  []fakeCommand{{Argv: []string{"cargo", "uninstall", "ripgrep", "bat"}}},
+	},
+	{
+		manager: &npmGlobal,
+		specs:   []string{"@biomejs/biome", "typescript@5.4"},
+		install: []fakeCommand{{Argv: []string{"npm", "install", "--global", "--prefix", "HOME/.local", "@biomejs/biome", "typescript@5.4"}}},
+		update:  []fakeCommand{{Argv: []string{"npm", "install", "--global", "--prefix", "HOME/.local", "@biomejs/biome@latest", "typescript@5.4"}}},
+		remove:  []fakeCommand{{Argv: []string{"npm", "uninstall", "--global", "--prefix", "HOME/.local", "@biomejs/biome", "typescript"}}},
+	},
+	{
+		manager: &pipx,
+		specs:   []string{"ruff", "black@24.1.0"},
+		install: []fakeCommand{{Argv: []string{"pipx", "install", "ruff", "black==24.1.0"}}},
+		update:  []fakeCommand{{Argv: []string{"pipx", "upgrade", "ruff"}}, {Argv: []string{"pipx", "install", "--force", "black==24.1.0"}}},
+		remove:  []fakeCommand{{Argv: []string{"pipx", "uninstall", "ruff"}}, {Argv: []string{"pipx", "uninstall", "black"}}},
+	},
+	{
+		manager: &gem,
+		specs:   []string{"rubocop", "rails@7.1.3"},
+		install: []fakeCommand{
+			{Argv: []string{"gem", "install", "--user-install", "--no-document", "rubocop"}},
+			{Argv: []string{"gem", "install", "--user-install", "--no-document", "rails", "--version", "7.1.3"}},
+		},
+		update: []fakeCommand{
+			{Argv: []string{"gem", "update", "--user-install", "--no-document", "rubocop"}},
+			{Argv: []string{"gem", "install", "--user-install", "--no-document", "rails", "--version", "7.1.3"}},
+		},
+		remove: []fakeCommand{{Argv: []string{"gem", "uninstall", "--user-install", "--all", "--executables", "rubocop", "rails"}}},
+	},
+	{
+		manager: &goInstall,
+		specs:   []string{"golang.org/x/tools/gopls", "github.com/go-delve/delve/cmd/dlv@v1.22.1"},
+		install: []fakeCommand{
+			{Argv: []string{"go", "install", "golang.org/x/tools/gopls@latest"}},
+			{Argv: []string{"go", "install", "github.com/go-delve/delve/cmd/dlv@v1.22.1"}},
+		},
+		update: []fakeCommand{
+			{Argv: []string{"go", "install", "golang.org/x/tools/gopls@latest"}},
+			{Argv: []string{"go", "install", "github.com/go-delve/delve/cmd/dlv@v1.22.1"}},
+		},
+	},
+}
+
+// newLanguageSysCall returns a pointer to a fake SysCall with a sandboxed home directory, and that directory.
+func newLanguageSysCall(t *testing.T, fake *fakeRunner) (*SysCall, string) {
+	t.Helper()
+	application := app.New()
+	if err := application.UseRoot(t.TempDir()); err != nil {
+		t.Fatalf("unable to sandbox the app: %v", err)
+	}
+	call := newFakeSysCall(t, fake)
+	call.application = application
+	home, err := application.FS.HomeDir()
+	if err != nil {
+		t.Fatalf("unable to get the sandbox's home: %v", err)
+	}
+	return call, home
+}
+
+// withHome replaces HOME in the commands' arguments with the home directory.
+func withHome(commands []fakeCommand, home string) []fakeCommand {
+	replaced := make([]fakeCommand, len(commands))
+	for i, command := range commands {
+		replaced[i] = command
+		replaced[i].Argv = nil
+		for _, arg := range command.Argv {
+			if arg == "HOME/.local" {
+				arg = filepath.Join(home, ".local")
+			}
+			replaced[i].Argv = append(replaced[i].Argv, arg)
+		}
+	}
+	return replaced
+}
+
+func TestLanguageManagers(t *testing.T) {
+	for _, test := range languageManagerCommands {
+		test := test
+		operations := map[string]struct {
+			run      func([]string, *SysCall) error
+			expected []fakeCommand
+		}{
+			"install": {test.manager.Install, test.install},
+			"update":  {test.manager.Update, test.update},
+		}
+		if test.remove != nil {
+			operations["uninstall"] = struct {
+				run      func([]string, *SysCall) error
+				expected []fakeCommand
+			}{test.manager.Uninstall, test.remove}
+		}
+		for operation, op := range operations {
+			op := op
+			t.Run(test.specs[0]+"/"+operation, func(t *testing.T) {
+				fake := newFakeRunner(t)
+				call, home := newLanguageSysCall(t, fake)
+				fake.expect(withHome(op.expected, home)...)
+				if err := op.run(test.specs, call); err != nil {
+					t.Fatalf("%s returned an error: %v", operation, err)
+				}
+			})
+		}
+	}
+}
+
+func TestGoUninstallRemovesBinaries(t *testing.T) {
+	fake := newFakeRunner(t)
+	call, home := newLanguageSysCall(t, fake)
+	binDir := filepath.Join(home, "go", "bin")
+	fake.expect(fakeCommand{Argv: []string{"go", "env", "GOBIN", "GOPATH"}, Stdout: "\n" + filepath.Join(home, "go") + "\n"})
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("unable to create bin dir: %v", err)
+	}
+	gopls := filepath.Join(binDir, "gopls")
+	if err := os.WriteFile(gopls, []byte("binary"), 0755); err != nil {
+		t.Fatalf("unable to create binary: %v", err)
+	}
+
+	// dlv was never installed, which is fine.
+	if err := goInstall.Uninstall([]string{"golang.org/x/tools/gopls@latest", "github.com/go-delve/delve/cmd/dlv"}, call); err != nil {
+		t.Fatalf("Uninstall returned an error: %v", err)
+	}
+	if _, err := os.Stat(gopls); !os.IsNotExist(err) {
+		t.Errorf("gopls is still installed: %v", err)
+	}
+}
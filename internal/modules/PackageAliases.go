@@ -0,0 +1,91 @@
+package modules
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// builtinAliases maps a package's logical name to what each manager calls it, by the manager's name.
+// Managers that aren't listed call it by its logical name, and an empty name means the manager needs nothing installed.
+var builtinAliases = map[string]map[string]string{
+	"fd": {"apt-get": "fd-find", "dnf": "fd-find"},
+	"pip": {
+		"apt-get": "python3-pip", "dnf": "python3-pip", "zypper": "python3-pip", "pacman": "python-pip",
+		"apk": "py3-pip", "xbps-install": "python3-pip", "brew": "",
+	},
+	"python": {
+		"apt-get": "python3", "dnf": "python3", "zypper": "python3", "apk": "python3",
+		"xbps-install": "python3", "emerge": "dev-lang/python", "nix": "python3",
+	},
+	"node":        {"apt-get": "nodejs", "dnf": "nodejs", "zypper": "nodejs", "pacman": "nodejs", "apk": "nodejs", "nix": "nodejs"},
+	"gpg":         {"apt-get": "gnupg", "dnf": "gnupg2", "zypper": "gpg2", "pacman": "gnupg", "apk": "gnupg", "brew": "gnupg"},
+	"ssh":         {"apt-get": "openssh-client", "dnf": "openssh-clients", "zypper": "openssh-clients", "pacman": "openssh", "apk": "openssh-client", "brew": "openssh"},
+	"ag":          {"apt-get": "silversearcher-ag", "dnf": "the_silver_searcher", "pacman": "the_silver_searcher", "brew": "the_silver_searcher"},
+	"delta":       {"apt-get": "git-delta", "dnf": "git-delta", "pacman": "git-delta", "brew": "git-delta"},
+	"ctags":       {"apt-get": "universal-ctags", "brew": "universal-ctags"},
+	"openssl-dev": {"apt-get": "libssl-dev", "dnf": "openssl-devel", "zypper": "libopenssl-devel", "pacman": "openssl", "apk": "openssl-dev", "brew": "openssl"},
+	"build-tools": {"apt-get": "build-essential", "dnf": "@development-tools", "zypper": "make", "pacman": "base-devel", "apk": "build-base", "brew": ""},
+}
+
+// aliasFamilies maps a manager to the one whose package names it shares, when it has none of its own.
+var aliasFamilies = map[string]string{"yum": "dnf"}
+
+// resolveAliases merges the aliases from the config over the built-in ones, naming each manager the way results do.
+func (gpm *GenericPackageManager) resolveAliases() error {
+	gpm.aliases = make(map[string]map[string]string, len(builtinAliases))
+	for logical, names := range builtinAliases {
+		gpm.aliases[logical] = make(map[string]string, len(names))
+		for manager, name := range names {
+			gpm.aliases[logical][manager] = name
+		}
+	}
+	// Sorted, so two spellings of the same manager, like apt and apt-get, always resolve the same way.
+	logicalNames := make([]string, 0, len(gpm.config.ConfigSettings.Aliases))
+	for logical := range gpm.config.ConfigSettings.Aliases {
+		logicalNames = append(logicalNames, logical)
+	}
+	sort.Strings(logicalNames)
+	for _, logical := range logicalNames {
+		if gpm.aliases[logical] == nil {
+			gpm.aliases[logical] = make(map[string]string)
+		}
+		for manager, name := range gpm.config.ConfigSettings.Aliases[logical] {
+			pm, err := gpm.managerNamed(manager)
+			if err != nil {
+				return err
+			}
+			gpm.aliases[logical][managerName(pm)] = name
+		}
+	}
+	return nil
+}
+
+// aliased returns the packages under the names the manager knows them by, keeping their versions.
+// Packages the manager needs nothing installed for are left out.
+func (gpm *GenericPackageManager) aliased(pm packageManager, packages []string) []string {
+	aliases := gpm.aliases
+	if aliases == nil {
+		aliases = builtinAliases
+	}
+	manager := managerName(pm)
+	renamed := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		spec := parsePackageSpec(pkg)
+		name, ok := aliases[spec.Name][manager]
+		if !ok {
+			name, ok = aliases[spec.Name][aliasFamilies[manager]]
+		}
+		switch {
+		case !ok:
+			renamed = append(renamed, pkg)
+			continue
+		case name == "":
+			slog.Debug("package isn't needed with this manager", "manager", manager, "package", spec.Name)
+			continue
+		}
+		slog.Debug("package is known by another name", "manager", manager, "package", spec.Name, "name", name)
+		spec.Name = name
+		renamed = append(renamed, spec.String())
+	}
+	return renamed
+}
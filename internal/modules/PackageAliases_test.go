@@ -0,0 +1,44 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAliased(t *testing.T) {
+	gpm := &GenericPackageManager{}
+	packages := []string{"fd", "pip", "git", "gpg@2.4.4"}
+	tests := map[*BasePackageManager][]string{
+		&aptitude: {"fd-find", "python3-pip", "git", "gnupg@2.4.4"},
+		&yum:      {"fd-find", "python3-pip", "git", "gnupg2@2.4.4"},
+		&pacman:   {"fd", "python-pip", "git", "gnupg@2.4.4"},
+		// brew's python comes with pip, so there is nothing to install for it.
+		&brew: {"fd", "git", "gnupg@2.4.4"},
+	}
+	for pm, want := range tests {
+		if got := gpm.aliased(pm, packages); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", pm.Name, got, want)
+		}
+	}
+}
+
+func TestConfiguredAliases(t *testing.T) {
+	settings := map[string]interface{}{
+		"manager":  "dnf",
+		"packages": []string{"fd", "bat", "lazygit"},
+		"aliases": map[string]map[string]string{
+			"fd":      {"dnf": "fd"},
+			"lazygit": {"apt": "lazygit-bin", "dnf": "lazygit-git"},
+		},
+		"managers": map[string][]string{"apt": {"lazygit", "fd"}},
+	}
+	gpm := newTestPackageManager(t, settings, newFakeRunner(t))
+	gpm.mapPackages()
+
+	if got, want := gpm.PackageManagerMap[&dnf], []string{"fd", "bat", "lazygit-git"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dnf: got %q, want %q", got, want)
+	}
+	if got, want := gpm.PackageManagerMap[&aptitude], []string{"lazygit-bin", "fd-find"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("apt: got %q, want %q", got, want)
+	}
+}
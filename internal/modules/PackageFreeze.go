@@ -0,0 +1,249 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// lockfileName is the lockfile freeze writes next to the config.
+const lockfileName = "packages.lock"
+
+// lockfileHeader starts every lockfile, so whoever opens one knows where it came from.
+const lockfileHeader = "# Written by `gasible freeze`, `gasible setup --locked` installs these exact versions.\n"
+
+// Lockfile is the exact version of each package, by package name, by the name of the manager that installed it.
+type Lockfile map[string]map[string]string
+
+// packageList is how to ask a manager which packages were installed on purpose, rather than as dependencies.
+type packageList struct {
+	Command []string
+	// parse turns the command's output into the package names.
+	parse func(stdout string) []string
+}
+
+// aptManualList asks apt for the packages marked as manually installed.
+var aptManualList = packageList{Command: []string{"apt-mark", "showmanual"}, parse: parseNames}
+
+// dnfUserInstalledList asks dnf for the packages the user installed, which it prints as name-version-release.arch.
+var dnfUserInstalledList = packageList{Command: []string{"dnf", "history", "userinstalled"}, parse: parseNEVRANames}
+
+// yumUserInstalledList asks yum the same as dnf, for the releases that only have yum.
+var yumUserInstalledList = packageList{Command: []string{"yum", "history", "userinstalled"}, parse: parseNEVRANames}
+
+// pacmanExplicitList asks pacman for the packages explicitly installed.
+var pacmanExplicitList = packageList{Command: []string{"pacman", "-Qqe"}, parse: parseNames}
+
+// brewLeavesList asks brew for the formulae nothing else depends on, the ones that were asked for.
+var brewLeavesList = packageList{Command: []string{"brew", "leaves"}, parse: parseNames}
+
+// parseNames returns the first word of each line that has one.
+func parseNames(stdout string) []string {
+	var names []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
+
+// parseNEVRANames returns the name of each name-version-release.arch line, skipping headings such as
+// "Packages installed by user", which have spaces where a package has none.
+func parseNEVRANames(stdout string) []string {
+	var names []string
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, " ") {
+			continue
+		}
+		if i := strings.LastIndex(line, "."); i > strings.LastIndex(line, "-") {
+			line = line[:i]
+		}
+		name := line
+		for n := 0; n < 2; n++ {
+			if i := strings.LastIndex(name, "-"); i > 0 {
+				name = name[:i]
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// explicitPackages returns the packages that were installed on purpose, sorted.
+func (pm *BasePackageManager) explicitPackages(call *SysCall) ([]string, error) {
+	var noListErr = errors.New(pm.Name + " has no way to list explicitly installed packages")
+	if pm.Explicit == nil {
+		return nil, noListErr
+	}
+	result, err := call.Exec(pm.Explicit.Command[0], pm.Explicit.Command[1:], false)
+	if err != nil {
+		return nil, fmt.Errorf("%s list failed: %w", pm.Name, err)
+	}
+	names := pm.Explicit.parse(result.Stdout)
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// Freeze adds the packages installed on purpose on this machine to the config, the native manager's to the
+// packages and the other managers' found on the PATH under managers, and writes their exact versions to the lockfile.
+// It is for bootstrapping a config from a reference machine. Packages already in the config are kept as they are.
+func (gpm *GenericPackageManager) Freeze() (Lockfile, error) {
+	var noNativeListErr = errors.New("the native package manager can't list explicitly installed packages")
+	native := gpm.Manager()
+	if native == nil || native.Explicit == nil {
+		return nil, internal.ErrorAs("GenericPackageManager.Freeze", noNativeListErr)
+	}
+	settings := &gpm.config.ConfigSettings
+	if settings.Managers == nil {
+		settings.Managers = make(map[string][]string)
+	}
+	lock := make(Lockfile)
+	for _, pm := range gpm.freezeManagers() {
+		names, err := pm.explicitPackages(gpm.system())
+		if err != nil {
+			return nil, internal.ErrorAs("GenericPackageManager.Freeze", err)
+		}
+		lock[pm.Name] = make(map[string]string)
+		if pm.Query != nil {
+			installed, queryErr := pm.installedVersions(names, gpm.system())
+			if queryErr != nil {
+				return nil, internal.ErrorAs("GenericPackageManager.Freeze", queryErr)
+			}
+			for _, name := range names {
+				if version, ok := installed[name]; ok {
+					lock[pm.Name][name] = version
+				}
+			}
+		}
+		if pm == native {
+			settings.Packages = gpm.mergeFrozen(pm, settings.Packages, names)
+		} else {
+			settings.Managers[pm.Name] = gpm.mergeFrozen(pm, settings.Managers[pm.Name], names)
+		}
+		slog.Info("froze explicitly installed packages", "manager", pm.Name, "packages", len(names))
+	}
+
+	frozen := map[string]interface{}{"packages": settings.Packages}
+	if len(settings.Managers) > 0 {
+		frozen["managers"] = settings.Managers
+	}
+	if err := gpm.Application.Config.SetModuleSettings(gpm.Name, frozen); err != nil {
+		return nil, internal.ErrorAs("GenericPackageManager.Freeze", err)
+	}
+	if err := gpm.writeLockfile(lock); err != nil {
+		return nil, internal.ErrorAs("GenericPackageManager.Freeze", err)
+	}
+	return lock, nil
+}
+
+// mergeFrozen adds the frozen packages to those configured for the manager. Configured packages are kept as they
+// were written, pinned versions and logical names included, and frozen packages they already cover aren't added.
+func (gpm *GenericPackageManager) mergeFrozen(pm *BasePackageManager, configured []string, frozen []string) []string {
+	covered := make(map[string]bool)
+	for _, pkg := range configured {
+		covered[parsePackageSpec(pkg).Name] = true
+		for _, name := range gpm.aliased(pm, []string{pkg}) {
+			covered[parsePackageSpec(name).Name] = true
+		}
+	}
+	merged := slices.Clone(configured)
+	for _, name := range frozen {
+		if !covered[name] {
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
+
+// freezeManagers returns the native manager, then every other manager on the PATH that can list its packages,
+// leaving out those that share the native manager's packages, such as yum alongside dnf.
+func (gpm *GenericPackageManager) freezeManagers() []*BasePackageManager {
+	native := gpm.Manager()
+	family := func(name string) string {
+		if shared, ok := aliasFamilies[name]; ok {
+			return shared
+		}
+		return name
+	}
+	managers := []*BasePackageManager{native}
+	for _, name := range supportedManagerNames() {
+		pm := supportedPackageManagers[name]
+		if pm.Explicit == nil || slices.Contains(managers, pm) || family(pm.Name) == family(native.Name) {
+			continue
+		}
+		if _, err := lookPath(pm.executable("install")); err == nil {
+			managers = append(managers, pm)
+		}
+	}
+	return managers
+}
+
+// lockfilePath returns where the lockfile is, next to the config.
+func (gpm *GenericPackageManager) lockfilePath() string {
+	return filepath.Join(filepath.Dir(gpm.Application.Config.FullPath), lockfileName)
+}
+
+// writeLockfile writes the lockfile as YAML.
+func (gpm *GenericPackageManager) writeLockfile(lock Lockfile) error {
+	encoded, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	path := gpm.lockfilePath()
+	if err = gpm.Application.FS.WriteFile(path, append([]byte(lockfileHeader), encoded...), 0600); err != nil {
+		return err
+	}
+	slog.Info("wrote lockfile", "path", path)
+	return nil
+}
+
+// UseLockfile reads the lockfile, so packages without a version of their own are installed at the locked one.
+func (gpm *GenericPackageManager) UseLockfile() error {
+	contents, err := gpm.Application.FS.ReadFile(gpm.lockfilePath())
+	if err != nil {
+		return internal.ErrorAs("GenericPackageManager.UseLockfile", err)
+	}
+	var lock Lockfile
+	if err = yaml.Unmarshal(contents, &lock); err != nil {
+		return internal.ErrorAs("GenericPackageManager.UseLockfile", err)
+	}
+	gpm.locked = lock
+	return nil
+}
+
+// Locked returns the packages pinned to the lockfile's versions when installing from it, for packages
+// installed outside of Setup, such as those other modules need.
+func (gpm *GenericPackageManager) Locked(pm *BasePackageManager, packages []string) []string {
+	return gpm.lockedVersions(pm, packages)
+}
+
+// lockedVersions pins the manager's packages that have no version of their own to the version in the lockfile.
+// Managers that can't install a version, or name each version as its own package, install them as they are.
+func (gpm *GenericPackageManager) lockedVersions(pm packageManager, packages []string) []string {
+	native, ok := pm.(*BasePackageManager)
+	locked := gpm.locked[managerName(pm)]
+	if !ok || native == nil || len(locked) == 0 {
+		return packages
+	}
+	if native.VersionFormat == "" || native.VersionInName {
+		slog.Warn("package manager can't install locked versions, installing the packages as they are", "manager", native.Name)
+		return packages
+	}
+	pinned := make([]string, 0, len(packages))
+	for _, spec := range parsePackageSpecs(packages) {
+		if version, ok := locked[spec.Name]; ok && spec.Version == "" {
+			spec.Version = version
+		} else if !ok {
+			slog.Warn("package isn't in the lockfile, installing it as it is", "manager", native.Name, "package", spec.Name)
+		}
+		pinned = append(pinned, spec.String())
+	}
+	return pinned
+}
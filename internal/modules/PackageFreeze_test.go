@@ -0,0 +1,121 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTestConfig points the app at a config file in its sandbox, with the given contents.
+func useTestConfig(t *testing.T, gpm *GenericPackageManager, contents string) string {
+	t.Helper()
+	home, err := gpm.Application.FS.HomeDir()
+	if err != nil {
+		t.Fatalf("unable to find the sandbox's home directory: %v", err)
+	}
+	path := filepath.Join(home, "gasible.yml")
+	if err = os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("unable to write the config: %v", err)
+	}
+	gpm.Application.Config.FullPath = path
+	return path
+}
+
+func TestFreezeWritesConfigAndLockfile(t *testing.T) {
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"dnf", "history", "userinstalled"},
+			Stdout: "Packages installed by user\nfd-find-10.1.0-1.fc40.x86_64\ngcc-14.1.1-7.fc40.x86_64\ngit-2.46.0-1.fc40.x86_64\nneovim-0.10.1-1.fc40.x86_64\n"},
+		fakeCommand{Argv: append(append([]string{}, rpmQuery.Command...), "fd-find", "gcc", "git", "neovim"),
+			Stdout: "fd-find\t10.1.0-1.fc40\ngcc\t14.1.1-7.fc40\ngit\t2.46.0-1.fc40\nneovim\t0.10.1-1.fc40\n"},
+		fakeCommand{Argv: []string{"brew", "leaves"}, Stdout: "jq\n"},
+		fakeCommand{Argv: []string{"brew", "list", "--versions", "jq"}, Stdout: "jq 1.7.1\n"},
+	)
+	gpm := newTestPackageManager(t, map[string]interface{}{"packages": []string{"git@2.46.0", "fd"}}, fake)
+	configPath := useTestConfig(t, gpm,
+		"# my machines\nGenericPackageManager:\n  enabled: true\n  settings:\n    packages:\n      - git@2.46.0\n      - fd\n")
+
+	lock, err := gpm.Freeze()
+	if err != nil {
+		t.Fatalf("Freeze returned an error: %v", err)
+	}
+	want := Lockfile{
+		"dnf":  {"fd-find": "10.1.0-1.fc40", "gcc": "14.1.1-7.fc40", "git": "2.46.0-1.fc40", "neovim": "0.10.1-1.fc40"},
+		"brew": {"jq": "1.7.1"},
+	}
+	if !reflect.DeepEqual(lock, want) {
+		t.Fatalf("got lockfile %v, want %v", lock, want)
+	}
+
+	written, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("unable to read the config: %v", err)
+	}
+	for _, line := range []string{"# my machines", "- git@2.46.0", "- fd\n", "- gcc", "- neovim", "brew:", "- jq"} {
+		if !strings.Contains(string(written), line) {
+			t.Errorf("config is missing %q:\n%s", line, written)
+		}
+	}
+	if got := gpm.config.ConfigSettings.Packages; !reflect.DeepEqual(got, []string{"git@2.46.0", "fd", "gcc", "neovim"}) {
+		t.Errorf("got packages %v, want the configured ones kept as written and the rest added", got)
+	}
+
+	// Setting up from the lockfile installs dnf's packages at the frozen versions, and brew's as they are.
+	settings := map[string]interface{}{"packages": []string{"gcc", "neovim", "ripgrep"}, "managers": map[string][]string{"brew": {"jq"}}}
+	if err = gpm.ParseConfig(map[string]interface{}{"enabled": true, "settings": settings}); err != nil {
+		t.Fatalf("ParseConfig returned an error: %v", err)
+	}
+	if err = gpm.UseLockfile(); err != nil {
+		t.Fatalf("UseLockfile returned an error: %v", err)
+	}
+	gpm.mapPackages()
+	got := gpm.PackageManagerMap[&dnf]
+	if wantPackages := []string{"gcc@14.1.1-7.fc40", "neovim@0.10.1-1.fc40", "ripgrep"}; !reflect.DeepEqual(got, wantPackages) {
+		t.Fatalf("got dnf packages %v, want %v", got, wantPackages)
+	}
+	if got = gpm.PackageManagerMap[&brew]; !reflect.DeepEqual(got, []string{"jq"}) {
+		t.Fatalf("got brew packages %v, want jq as it is", got)
+	}
+	// Packages other modules need are locked too.
+	if got = gpm.Locked(&dnf, []string{"git"}); !reflect.DeepEqual(got, []string{"git@2.46.0-1.fc40"}) {
+		t.Fatalf("got %v, want git at its locked version", got)
+	}
+}
+
+func TestFreezeNeedsANativeList(t *testing.T) {
+	gpm := newTestPackageManager(t, map[string]interface{}{"manager": "zypper"}, newFakeRunner(t))
+	if _, err := gpm.Freeze(); err == nil || !strings.Contains(err.Error(), "can't list explicitly installed packages") {
+		t.Fatalf("got error %v, want one saying zypper can't list its packages", err)
+	}
+}
+
+func TestParseNEVRANames(t *testing.T) {
+	stdout := "Packages installed by user\nfedora-release-40-39.noarch\npython3-pip-23.3.2-1.fc40.noarch\n\n"
+	want := []string{"fedora-release", "python3-pip"}
+	if got := parseNEVRANames(stdout); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLockedAptVersionsAreExact(t *testing.T) {
+	settings := map[string]interface{}{"manager": "apt-get", "packages": []string{"git", "curl"}, "refresh-max-age": "-1s"}
+	madison := " git | 1:2.43.0-1ubuntu7.1 | http://archive.ubuntu.com/ubuntu noble-updates/main amd64 Packages\n" +
+		" git | 1:2.43.0-1ubuntu7 | http://archive.ubuntu.com/ubuntu noble/main amd64 Packages\n" +
+		"curl | 8.5.0-2ubuntu10.1 | http://archive.ubuntu.com/ubuntu noble-updates/main amd64 Packages\n"
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: append(append([]string{}, dpkgQuery.Command...), "git", "curl"), ExitCode: 1},
+		fakeCommand{Argv: []string{"apt-cache", "madison", "git", "curl"}, Stdout: madison},
+		// curl's locked version is gone from the archive, it is still asked for exactly rather than a newer one.
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git=1:2.43.0-1ubuntu7", "curl=8.5.0-2ubuntu10"},
+			Sudo: true},
+		fakeCommand{Argv: append(append([]string{}, dpkgQuery.Command...), "git", "curl"),
+			Stdout: "git\t1:2.43.0-1ubuntu7\tii \ncurl\t8.5.0-2ubuntu10\tii \n"},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	gpm.locked = Lockfile{"apt-get": {"git": "1:2.43.0-1ubuntu7", "curl": "8.5.0-2ubuntu10"}}
+	gpm.mapPackages()
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+}
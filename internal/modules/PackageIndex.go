@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"log/slog"
+	"time"
+)
+
+// defaultRefreshMaxAge is how old a package index can get before installing refreshes it, when the config doesn't say.
+const defaultRefreshMaxAge = time.Hour
+
+// systemUpgrader is a manager that can upgrade everything it has installed, not only the packages it is given.
+type systemUpgrader interface {
+	Upgrade(*SysCall) error
+}
+
+// Refresh refreshes the package index, managers that refresh their own when it is out of date have nothing to do.
+func (pm *BasePackageManager) Refresh(call *SysCall) error {
+	if pm == nil || pm.Args.RefreshArg == "" {
+		return nil
+	}
+	return pm.executeAll("refresh", *call)
+}
+
+// Upgrade upgrades every package on the system, pinned ones included unless they are held.
+// A custom manager without an upgrade has nothing to do.
+func (pm *BasePackageManager) Upgrade(call *SysCall) error {
+	if pm != nil && pm.Args.UpgradeArg == "" {
+		slog.Info("package manager has no way to upgrade the system, skipping it", "manager", pm.Name)
+		return nil
+	}
+	return pm.executeAll("upgrade", *call)
+}
+
+// executeAll runs an operation that doesn't take packages.
+func (pm *BasePackageManager) executeAll(operation string, syscall SysCall) error {
+	var noPackageManagerFoundErr = errors.New("no package Manager set, set one in the config")
+	if pm == nil {
+		return internal.ErrorAs("basePackageManager.executeAll", noPackageManagerFoundErr)
+	}
+	executable := pm.executable(operation)
+	result, err := syscall.Exec(executable, formatCommand(pm, operation), pm.NeedsRoot)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", executable, operation, err)
+	}
+	slog.Info("package manager finished running operation", "manager", pm.Name, "operation", operation,
+		"duration", result.Duration.Round(time.Millisecond))
+	return nil
+}
+
+// refreshMaxAge returns how old a package index can get before it is refreshed, negative when it never is.
+func (gpm *GenericPackageManager) refreshMaxAge() time.Duration {
+	if maxAge := gpm.config.ConfigSettings.RefreshMaxAge; maxAge != 0 {
+		return maxAge
+	}
+	return defaultRefreshMaxAge
+}
+
+// refreshBefore refreshes a native manager's package index before it installs or upgrades anything, when it is
+// older than the max age. A failed refresh is only a warning, the packages may well install from the index there is.
+func (gpm *GenericPackageManager) refreshBefore(pm packageManager, operation string, packages []string) {
+	native, ok := pm.(*BasePackageManager)
+	if !ok || native == nil || operation == "uninstall" || (operation != "upgrade" && len(packages) == 0) {
+		return
+	}
+	if err := gpm.refreshIndex(native, false); err != nil {
+		slog.Warn("unable to refresh the package index", "manager", native.Name, "error", err)
+	}
+}
+
+// refreshIndex refreshes the manager's package index and records when, unless it was refreshed within the max age.
+// Forcing it refreshes the index however recently it was refreshed, such as after adding a repository.
+func (gpm *GenericPackageManager) refreshIndex(pm *BasePackageManager, force bool) error {
+	maxAge := gpm.refreshMaxAge()
+	if pm.Args.RefreshArg == "" || (maxAge < 0 && !force) {
+		return nil
+	}
+	store, err := gpm.Application.State()
+	if err != nil {
+		return internal.ErrorAs("GenericPackageManager.refreshIndex", err)
+	}
+	var current packageManagerState
+	if err = store.Load(gpm.Name, &current); err != nil {
+		return internal.ErrorAs("GenericPackageManager.refreshIndex", err)
+	}
+	if refreshed, ok := current.Refreshed[pm.Name]; ok && !force && time.Since(refreshed) < maxAge {
+		slog.Debug("package index is recent enough, not refreshing it", "manager", pm.Name, "refreshed", refreshed)
+		return nil
+	}
+	if err = pm.Refresh(gpm.system()); err != nil {
+		return internal.ErrorAs("GenericPackageManager.refreshIndex", err)
+	}
+	if current.Refreshed == nil {
+		current.Refreshed = make(map[string]time.Time)
+	}
+	current.Refreshed[pm.Name] = time.Now()
+	if err = store.Save(gpm.Name, current); err != nil {
+		return internal.ErrorAs("GenericPackageManager.refreshIndex", err)
+	}
+	return nil
+}
+
+// RefreshIndex refreshes the native manager's package index when it is older than the max age,
+// for the packages other modules need, which are installed before Setup runs.
+func (gpm *GenericPackageManager) RefreshIndex() {
+	gpm.refreshBefore(gpm.Manager(), "install", ToBeInstalled[gpm.Manager()])
+}
@@ -0,0 +1,74 @@
+package modules
+
+import (
+	"testing"
+)
+
+func TestInstallRefreshesStaleIndex(t *testing.T) {
+	settings := map[string]interface{}{"manager": "apt-get", "packages": []string{"git"}}
+	query := fakeCommand{Argv: append(append([]string{}, dpkgQuery.Command...), "git"), ExitCode: 1}
+	install := fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git"}, Sudo: true}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+		query,
+		install,
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+
+	// The index was just refreshed, so it isn't again.
+	fake.expect(query, install)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("second Setup returned an error: %v", err)
+	}
+}
+
+func TestNegativeMaxAgeNeverRefreshes(t *testing.T) {
+	settings := map[string]interface{}{"manager": "apt-get", "packages": []string{"git"}, "refresh-max-age": "-1s"}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: append(append([]string{}, dpkgQuery.Command...), "git"), ExitCode: 1},
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+}
+
+func TestPacmanOnlySyncsWhenUpgrading(t *testing.T) {
+	settings := map[string]interface{}{"manager": "pacman", "packages": []string{"git"}}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: append(append([]string{}, pacmanQuery.Command...), "git"), ExitCode: 1},
+		fakeCommand{Argv: []string{"sudo", "pacman", "-S", "--needed", "--noconfirm", "--quiet", "git"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+
+	fake.expect(fakeCommand{Argv: []string{"sudo", "pacman", "-Syu", "--noconfirm", "--quiet"}, Sudo: true})
+	if err := gpm.Upgrade(); err != nil {
+		t.Fatalf("Upgrade returned an error: %v", err)
+	}
+}
+
+func TestUpgradeRunsEveryManagerThatCan(t *testing.T) {
+	settings := map[string]interface{}{
+		"packages": []string{"git"},
+		"flatpak":  map[string]interface{}{"packages": []string{"org.mozilla.firefox"}},
+		"pipx":     []string{"black"},
+	}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "dnf", "upgrade", "-y", "-q"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "flatpak", "update", "--system", "-y", "--noninteractive"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Upgrade(); err != nil {
+		t.Fatalf("Upgrade returned an error: %v", err)
+	}
+	if len(gpm.Results) != 2 || gpm.Results[0].Manager != "dnf" || gpm.Results[1].Manager != "flatpak" {
+		t.Fatalf("got results %+v, want dnf and flatpak upgraded and pipx left out", gpm.Results)
+	}
+}
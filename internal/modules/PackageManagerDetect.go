@@ -0,0 +1,159 @@
+package modules
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// autoDetectManager is the manager setting that asks us to find the native package manager ourselves.
+const autoDetectManager = "auto"
+
+// osReleasePath is where we read the distribution identifiers from.
+var osReleasePath = "/etc/os-release"
+
+// lookPath is exec.LookPath, kept as a variable so detection doesn't depend on the host's PATH.
+var lookPath = exec.LookPath
+
+// managersByDistroID maps an os-release ID / ID_LIKE value to the package manager that distro ships with.
+var managersByDistroID = map[string]string{
+	"debian":        "apt-get",
+	"ubuntu":        "apt-get",
+	"linuxmint":     "apt-get",
+	"pop":           "apt-get",
+	"fedora":        "dnf",
+	"rhel":          "dnf",
+	"centos":        "dnf",
+	"rocky":         "dnf",
+	"almalinux":     "dnf",
+	"arch":          "pacman",
+	"manjaro":       "pacman",
+	"endeavouros":   "pacman",
+	"opensuse":      "zypper",
+	"opensuse-leap": "zypper",
+	"suse":          "zypper",
+	"sles":          "zypper",
+	"ol":            "dnf",
+	"amzn":          "dnf",
+	"alpine":        "apk",
+	"void":          "xbps-install",
+	"gentoo":        "emerge",
+	"nixos":         "nix",
+}
+
+// managerFallbacks is the manager to use when the one we expect isn't installed,
+// such as yum on RHEL and CentOS 7, which came before dnf.
+var managerFallbacks = map[string]string{
+	"dnf": "yum",
+}
+
+// detectionOrder is the order we search the PATH in when the os-release file doesn't give us an answer.
+var detectionOrder = []string{"apt-get", "dnf", "yum", "pacman", "zypper", "apk", "xbps-install", "emerge", "nix", "brew"}
+
+// darwinDetectionOrder is the order we search the PATH in on macOS, preferring brew over MacPorts.
+var darwinDetectionOrder = []string{"brew", "port"}
+
+// detectPackageManager finds the native package manager for the running system.
+// On macOS this is brew or MacPorts, elsewhere we trust /etc/os-release first and fall back to searching the PATH.
+func detectPackageManager() (string, error) {
+	var noManagerDetectedErr = fmt.Errorf(
+		"unable to detect a package manager, looked for %s; set `manager` in the config",
+		strings.Join(detectionOrder, ", "),
+	)
+	if runtime.GOOS == "darwin" {
+		for _, manager := range darwinDetectionOrder {
+			if _, err := lookPath(manager); err == nil {
+				return manager, nil
+			}
+		}
+		return "", internal.ErrorAs("detectPackageManager", noManagerDetectedErr)
+	}
+
+	for _, id := range distroIDs(osReleasePath) {
+		manager, ok := managersByDistroID[id]
+		if !ok {
+			continue
+		}
+		if installed, found := installedManager(manager); found {
+			return installed, nil
+		}
+	}
+
+	for _, manager := range detectionOrder {
+		if _, err := lookPath(manager); err == nil {
+			return manager, nil
+		}
+	}
+	return "", internal.ErrorAs("detectPackageManager", noManagerDetectedErr)
+}
+
+// installedManager returns the manager if it is on the PATH, or its fallback if that is instead.
+func installedManager(manager string) (string, bool) {
+	if _, err := lookPath(manager); err == nil {
+		return manager, true
+	}
+	if fallback, ok := managerFallbacks[manager]; ok {
+		if _, err := lookPath(fallback); err == nil {
+			slog.Info("package manager not found, using its fallback", "manager", manager, "fallback", fallback)
+			return fallback, true
+		}
+	}
+	return "", false
+}
+
+// distroIDs returns the ID followed by every ID_LIKE entry found in an os-release file.
+// A missing or unreadable file simply yields no IDs.
+func distroIDs(path string) []string {
+	release := readOSRelease(path)
+	id, like := release["ID"], strings.Fields(release["ID_LIKE"])
+	if id == "" {
+		return like
+	}
+	return append([]string{id}, like...)
+}
+
+// readOSRelease returns the values in an os-release file by their keys, unquoted.
+// A missing or unreadable file simply yields no values.
+func readOSRelease(path string) map[string]string {
+	release := make(map[string]string)
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return release
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if found {
+			release[key] = strings.Trim(value, `"'`)
+		}
+	}
+	return release
+}
+
+// resolveManager turns the configured manager into one we support, detecting it when asked to.
+func resolveManager(configured string) (string, error) {
+	if configured == "" || configured == autoDetectManager {
+		return detectPackageManager()
+	}
+	if _, ok := supportedPackageManagers[configured]; !ok {
+		var unsupportedManagerErr = errors.New("unsupported package manager " + configured + ", use one of: " +
+			strings.Join(supportedManagerNames(), ", ") + " or " + autoDetectManager)
+		return "", internal.ErrorAs("resolveManager", unsupportedManagerErr)
+	}
+	// A config written for dnf still works on the older releases that only have yum.
+	if _, hasFallback := managerFallbacks[configured]; hasFallback {
+		if installed, found := installedManager(configured); found {
+			return installed, nil
+		}
+	}
+	return configured, nil
+}
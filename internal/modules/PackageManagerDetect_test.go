@@ -0,0 +1,73 @@
+package modules
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// stubDetection points detection at an os-release file with the given contents and a PATH holding only onPath.
+func stubDetection(t *testing.T, osRelease string, onPath ...string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "os-release")
+	if err := os.WriteFile(path, []byte(osRelease), 0600); err != nil {
+		t.Fatalf("unable to write os-release: %v", err)
+	}
+	realOSReleasePath, realLookPath := osReleasePath, lookPath
+	osReleasePath = path
+	lookPath = func(file string) (string, error) {
+		if slices.Contains(onPath, file) {
+			return "/usr/bin/" + file, nil
+		}
+		return "", errors.New("not found")
+	}
+	t.Cleanup(func() { osReleasePath, lookPath = realOSReleasePath, realLookPath })
+}
+
+func TestDetectPackageManager(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("macOS only looks for brew and MacPorts")
+	}
+	tests := []struct {
+		name      string
+		osRelease string
+		onPath    []string
+		want      string
+	}{
+		{"alpine", "ID=alpine\n", []string{"apk"}, "apk"},
+		{"void", "ID=\"void\"\n", []string{"xbps-install", "xbps-remove"}, "xbps-install"},
+		{"gentoo", "ID=gentoo\n", []string{"emerge"}, "emerge"},
+		{"nixos", "ID=nixos\n", []string{"nix"}, "nix"},
+		{"rhel 9", "ID=\"rhel\"\nID_LIKE=\"fedora\"\n", []string{"dnf", "yum"}, "dnf"},
+		{"centos 7", "ID=\"centos\"\nID_LIKE=\"rhel fedora\"\n", []string{"yum"}, "yum"},
+		{"unknown distro", "ID=mystery\n", []string{"apk"}, "apk"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			stubDetection(t, test.osRelease, test.onPath...)
+			got, err := detectPackageManager()
+			if err != nil || got != test.want {
+				t.Fatalf("got %q and error %v, want %q", got, err, test.want)
+			}
+			if _, ok := supportedPackageManagers[got]; !ok {
+				t.Errorf("detected %q, which isn't a supported package manager", got)
+			}
+		})
+	}
+}
+
+func TestResolveManagerFallsBackToYum(t *testing.T) {
+	stubDetection(t, "ID=centos\n", "yum")
+	if got, err := resolveManager("dnf"); err != nil || got != "yum" {
+		t.Fatalf("got %q and error %v, want yum", got, err)
+	}
+
+	stubDetection(t, "ID=fedora\n", "dnf", "yum")
+	if got, err := resolveManager("dnf"); err != nil || got != "dnf" {
+		t.Fatalf("got %q and error %v, want dnf", got, err)
+	}
+}
@@ -0,0 +1,76 @@
+package modules
+
+import (
+	"log/slog"
+	"time"
+)
+
+// ManagerResult is what one package manager did during an operation.
+type ManagerResult struct {
+	Manager   string
+	Operation string
+	Packages  []string
+	Duration  time.Duration
+	// Drift is the pinned packages that aren't at their version after the operation.
+	Drift []PackageDrift
+	// Succeeded is the packages the operation did, leaving out those that failed and those that had nothing to do,
+	// such as packages that were already installed.
+	Succeeded []string
+	// Failed is the packages that failed by themselves, when a batch failed and the rest of it was done anyway.
+	Failed []PackageError
+	// Err is why the manager failed, nil when it succeeded.
+	Err error
+}
+
+// managerName returns the name a manager goes by in the config and in results.
+func managerName(pm packageManager) string {
+	switch manager := pm.(type) {
+	case *BasePackageManager:
+		if manager == nil {
+			return "none"
+		}
+		return manager.Name
+	case *flatpakManager:
+		return "flatpak"
+	case *snapManager:
+		return "snap"
+	case *goManager:
+		return manager.Name
+	case *cargoManager:
+		return manager.Name
+	case *npmManager:
+		return manager.Name
+	case *pipxManager:
+		return manager.Name
+	case *gemManager:
+		return manager.Name
+	default:
+		return "unknown"
+	}
+}
+
+// logResults logs a line for each manager that had packages to work on, so a run ends with what each one did.
+func logResults(results []ManagerResult) {
+	for _, result := range results {
+		if len(result.Packages) == 0 && result.Err == nil {
+			continue
+		}
+		attrs := []any{"manager", result.Manager, "operation", result.Operation,
+			"packages", len(result.Packages), "duration", result.Duration.Round(time.Millisecond)}
+		if len(result.Drift) > 0 {
+			attrs = append(attrs, "drifted", len(result.Drift))
+		}
+		if len(result.Failed) > 0 {
+			attrs = append(attrs, "succeeded", len(result.Succeeded), "failed", len(result.Failed))
+			for _, failure := range result.Failed {
+				slog.Error("package failed", "manager", result.Manager, "operation", result.Operation,
+					"package", failure.Package, "error", failure.Err)
+			}
+		}
+		if result.Err != nil {
+			slog.Error("package manager failed", append(attrs, "error", result.Err)...)
+			continue
+		}
+		slog.Info("package manager succeeded", attrs...)
+	}
+}
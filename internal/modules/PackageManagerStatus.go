@@ -0,0 +1,52 @@
+package modules
+
+import "log/slog"
+
+// PackageStatus is whether a configured package is installed, and at which version.
+type PackageStatus struct {
+	Manager string
+	Package string
+	// Pinned is the version the package is pinned to, empty when it isn't.
+	Pinned string
+	// Installed is the installed version, empty when the package isn't installed.
+	Installed string
+	// Known is false when the manager can't be asked, leaving Installed empty either way.
+	Known bool
+}
+
+// Drifted reports whether the package is pinned to a version other than the installed one.
+func (s PackageStatus) Drifted() bool {
+	return s.Known && s.Pinned != "" && !matchesVersion(s.Installed, s.Pinned)
+}
+
+// Status returns every configured package with the version installed, asking each manager that can be asked.
+func (gpm *GenericPackageManager) Status() []PackageStatus {
+	gpm.mapPackages()
+	var statuses []PackageStatus
+	for _, pm := range gpm.orderedManagers() {
+		packages := gpm.PackageManagerMap[pm]
+		if len(packages) < 1 {
+			continue
+		}
+		native, _ := pm.(*BasePackageManager)
+		var installed map[string]string
+		if native != nil && native.Query != nil {
+			names := make([]string, len(packages))
+			for i, spec := range parsePackageSpecs(packages) {
+				names[i] = native.queryName(spec)
+			}
+			var err error
+			if installed, err = native.installedVersions(names, gpm.system()); err != nil {
+				slog.Warn("unable to query installed packages", "manager", native.Name, "error", err)
+			}
+		}
+		for _, spec := range parsePackageSpecs(packages) {
+			status := PackageStatus{Manager: managerName(pm), Package: spec.Name, Pinned: spec.Version, Known: installed != nil}
+			if installed != nil {
+				status.Installed = installed[native.queryName(spec)]
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
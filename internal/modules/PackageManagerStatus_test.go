@@ -0,0 +1,34 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	settings := map[string]interface{}{
+		"manager":  "dnf",
+		"packages": []string{"git@2.43.0", "curl", "vim@9.1"},
+		"cargo":    []string{"ripgrep"},
+	}
+	fake := newFakeRunner(t).expect(fakeCommand{
+		Argv:     append(append([]string{}, rpmQuery.Command...), "git", "curl", "vim"),
+		Stdout:   "git\t2.43.0-1.fc40\nvim\t9.0-1.fc40\npackage curl is not installed\n",
+		ExitCode: 1,
+	})
+	gpm := newTestPackageManager(t, settings, fake)
+
+	want := []PackageStatus{
+		{Manager: "dnf", Package: "git", Pinned: "2.43.0", Installed: "2.43.0-1.fc40", Known: true},
+		{Manager: "dnf", Package: "curl", Known: true},
+		{Manager: "dnf", Package: "vim", Pinned: "9.1", Installed: "9.0-1.fc40", Known: true},
+		{Manager: "cargo", Package: "ripgrep"},
+	}
+	got := gpm.Status()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got[0].Drifted() || !got[2].Drifted() || got[3].Drifted() {
+		t.Errorf("got drift %t %t %t, want only vim drifted", got[0].Drifted(), got[2].Drifted(), got[3].Drifted())
+	}
+}
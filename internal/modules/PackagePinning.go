@@ -0,0 +1,220 @@
+package modules
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// packageHolds is how a manager keeps packages at their version, and lets them go again.
+type packageHolds struct {
+	// Hold is the argv the package names are appended to, to hold them.
+	Hold []string
+	// Unhold is the argv the package names are appended to, to let them go.
+	Unhold []string
+}
+
+// PackageDrift is a pinned package whose installed version isn't the pinned one.
+type PackageDrift struct {
+	Package string
+	Pinned  string
+	// Installed is the installed version, empty when the package isn't installed at all.
+	Installed string
+}
+
+// unpinned returns the packages that aren't pinned to a version, or all of them when versions are packages of their own.
+func (pm *BasePackageManager) unpinned(packages []string) []string {
+	if pm.VersionInName {
+		return packages
+	}
+	var unpinned []string
+	for _, pkg := range packages {
+		if parsePackageSpec(pkg).Version == "" {
+			unpinned = append(unpinned, pkg)
+		}
+	}
+	return unpinned
+}
+
+// pinnedNames returns the names the manager knows the pinned packages by.
+func (pm *BasePackageManager) pinnedNames(packages []string) []string {
+	var names []string
+	for _, spec := range parsePackageSpecs(packages) {
+		if spec.Version != "" {
+			names = append(names, pm.queryName(spec))
+		}
+	}
+	return names
+}
+
+// hold holds the pinned packages, so nothing upgrades them until they are let go.
+func (pm *BasePackageManager) hold(packages []string, call *SysCall) error {
+	return pm.runHolds("hold", packages, call)
+}
+
+// unhold lets the pinned packages go.
+func (pm *BasePackageManager) unhold(packages []string, call *SysCall) error {
+	return pm.runHolds("unhold", packages, call)
+}
+
+// runHolds holds or lets go of the pinned packages, a manager that can't hold them only warns.
+func (pm *BasePackageManager) runHolds(operation string, packages []string, call *SysCall) error {
+	names := pm.pinnedNames(packages)
+	if len(names) < 1 {
+		return nil
+	}
+	if pm.Holds == nil {
+		slog.Warn("package manager can't hold packages, pinned packages may be upgraded", "manager", pm.Name, "packages", names)
+		return nil
+	}
+	argv := pm.Holds.Hold
+	if operation == "unhold" {
+		argv = pm.Holds.Unhold
+	}
+	args := append(append([]string{}, argv[1:]...), names...)
+	if _, err := call.Exec(argv[0], args, pm.NeedsRoot); err != nil {
+		return fmt.Errorf("%s %s failed: %w", pm.Name, operation, err)
+	}
+	return nil
+}
+
+// drift returns the pinned packages that aren't installed at their version.
+func (pm *BasePackageManager) drift(packages []string, call *SysCall) ([]PackageDrift, error) {
+	names := pm.pinnedNames(packages)
+	if len(names) < 1 || pm.Query == nil {
+		return nil, nil
+	}
+	installed, err := pm.installedVersions(names, call)
+	if err != nil {
+		return nil, err
+	}
+	var drifted []PackageDrift
+	for _, spec := range parsePackageSpecs(packages) {
+		if spec.Version == "" {
+			continue
+		}
+		if version := installed[pm.queryName(spec)]; !matchesVersion(version, spec.Version) {
+			drifted = append(drifted, PackageDrift{Package: spec.Name, Pinned: spec.Version, Installed: version})
+		}
+	}
+	return drifted, nil
+}
+
+// releasePinned lets go of a native manager's held packages before they are removed, when the config holds them.
+func (gpm *GenericPackageManager) releasePinned(pm packageManager, operation string, packages []string) error {
+	native, ok := pm.(*BasePackageManager)
+	if !ok || native == nil || operation != "uninstall" || !gpm.config.ConfigSettings.Hold {
+		return nil
+	}
+	return native.unhold(packages, gpm.system())
+}
+
+// checkPinned holds a native manager's pinned packages once they are installed or updated, when the config holds them,
+// and returns those that aren't at their pinned version.
+func (gpm *GenericPackageManager) checkPinned(pm packageManager, operation string, packages []string) ([]PackageDrift, error) {
+	native, ok := pm.(*BasePackageManager)
+	if !ok || native == nil || operation == "uninstall" {
+		return nil, nil
+	}
+	if gpm.config.ConfigSettings.Hold {
+		if err := native.hold(packages, gpm.system()); err != nil {
+			return nil, err
+		}
+	}
+	drifted, err := native.drift(packages, gpm.system())
+	for _, drift := range drifted {
+		slog.Warn("pinned package isn't at its version", "manager", native.Name, "package", drift.Package,
+			"pinned", drift.Pinned, "installed", drift.Installed)
+	}
+	return drifted, err
+}
+
+// packageVersions is how to ask a manager which versions of packages it can install.
+type packageVersions struct {
+	// Command is the argv the package names are appended to.
+	Command []string
+	// parse turns the command's output into the versions of each package, by name, the newest first.
+	parse func(stdout string) map[string][]string
+}
+
+// aptMadison asks apt for the versions in its sources, printed as "name | version | source" lines.
+var aptMadison = packageVersions{
+	Command: []string{"apt-cache", "madison"},
+	parse: func(stdout string) map[string][]string {
+		versions := make(map[string][]string)
+		for _, line := range strings.Split(stdout, "\n") {
+			fields := strings.Split(line, "|")
+			if len(fields) < 3 {
+				continue
+			}
+			name, version := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+			if name != "" && version != "" && !slices.Contains(versions[name], version) {
+				versions[name] = append(versions[name], version)
+			}
+		}
+		return versions
+	},
+}
+
+// resolveVersions returns the packages with each pinned version swapped for the manager's full version of it,
+// so git@2.43.0 is installed as apt's 1:2.43.0-1ubuntu7. Pins without a match are left for the manager to refuse.
+func (pm *BasePackageManager) resolveVersions(packages []string, call *SysCall) []string {
+	specs := parsePackageSpecs(packages)
+	var names []string
+	for _, spec := range specs {
+		if spec.Version != "" {
+			names = append(names, spec.Name)
+		}
+	}
+	if pm.Available == nil || pm.VersionInName || len(names) < 1 {
+		return packages
+	}
+	args := append(append([]string{}, pm.Available.Command[1:]...), names...)
+	result, err := call.Exec(pm.Available.Command[0], args, false)
+	if err != nil {
+		slog.Warn("unable to list available versions, installing pinned packages as written", "manager", pm.Name, "error", err)
+		return packages
+	}
+	available := pm.Available.parse(result.Stdout)
+
+	resolved := make([]string, len(specs))
+	for i, spec := range specs {
+		if spec.Version != "" {
+			if version, ok := resolveVersion(available[spec.Name], spec.Version); ok {
+				spec.Version = version
+			} else {
+				slog.Warn("no available version matches the pin", "manager", pm.Name, "package", spec.Name,
+					"pinned", spec.Version, "available", available[spec.Name])
+			}
+		}
+		resolved[i] = spec.String()
+	}
+	return resolved
+}
+
+// resolveVersion picks the version a pin means from those available, the newest first.
+// A full version must be there as it is, while one without an epoch or revision matches any,
+// so 2.43.0 matches 1:2.43.0-1ubuntu7 but neither 2.43.01 nor 2.43.0.1.
+func resolveVersion(available []string, pinned string) (string, bool) {
+	if slices.Contains(available, pinned) {
+		return pinned, true
+	}
+	for _, version := range available {
+		if upstreamVersion(version, pinned) == pinned {
+			return version, true
+		}
+	}
+	return "", false
+}
+
+// upstreamVersion drops the parts of a Debian style epoch:upstream-revision version the pin leaves out.
+func upstreamVersion(version string, pinned string) string {
+	if epoch, rest, found := strings.Cut(version, ":"); found && isDigits(epoch) && !strings.Contains(pinned, ":") {
+		version = rest
+	}
+	if i := strings.LastIndex(version, "-"); i > 0 && !strings.Contains(pinned, "-") {
+		version = version[:i]
+	}
+	return version
+}
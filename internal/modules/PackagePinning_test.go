@@ -0,0 +1,116 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPinnedPackageNames(t *testing.T) {
+	tests := []struct {
+		pm   *BasePackageManager
+		want []string
+	}{
+		{&aptitude, []string{"git=1:2.43.0-1ubuntu7", "curl"}},
+		{&dnf, []string{"git-1:2.43.0-1ubuntu7", "curl"}},
+		{&brew, []string{"git@1:2.43.0-1ubuntu7", "curl"}},
+		{&emerge, []string{"=git-1:2.43.0-1ubuntu7", "curl"}},
+	}
+	for _, test := range tests {
+		got, err := test.pm.packageNames("install", []string{"git@1:2.43.0-1ubuntu7", "curl"})
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q and error %v, want %q", test.pm.Name, got, err, test.want)
+		}
+	}
+
+	if _, err := pacman.packageNames("install", []string{"git@2.43.0"}); err == nil {
+		t.Error("pacman was asked for a version it can't install without an error")
+	}
+	if got, _ := aptitude.packageNames("uninstall", []string{"git@2.43.0"}); !reflect.DeepEqual(got, []string{"git"}) {
+		t.Errorf("got %q, want pinned packages removed by name", got)
+	}
+}
+
+func TestUpdateLeavesPinnedPackages(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "--only-upgrade", "-y", "-qq", "curl"}, Sudo: true},
+		fakeCommand{Argv: []string{"brew", "upgrade", "-q", "python@3.12", "curl"}},
+	))
+	if err := aptitude.Update([]string{"git@2.43.0", "curl"}, call); err != nil {
+		t.Fatalf("apt Update returned an error: %v", err)
+	}
+	// brew's versions are formulae of their own, so they are updated within their version.
+	if err := brew.Update([]string{"python@3.12", "curl"}, call); err != nil {
+		t.Fatalf("brew Update returned an error: %v", err)
+	}
+}
+
+func TestHoldAndDrift(t *testing.T) {
+	settings := map[string]interface{}{
+		"manager":  "dnf",
+		"packages": []string{"git@2.43.0", "curl", "vim@9.1"},
+		"hold":     true,
+	}
+	rpm := func(names ...string) []string { return append(append([]string{}, rpmQuery.Command...), names...) }
+	fake := newFakeRunner(t).expect(
+		// git is already there at its version, but vim isn't.
+		fakeCommand{Argv: rpm("git", "curl", "vim"), Stdout: "git\t2.43.0-1.fc40\nvim\t9.0-1.fc40\n", ExitCode: 1},
+		fakeCommand{Argv: []string{"sudo", "dnf", "install", "-y", "-q", "curl", "vim-9.1"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "dnf", "versionlock", "add", "git", "vim"}, Sudo: true},
+		// vim-9.1 turned out to be vim 9.10.
+		fakeCommand{Argv: rpm("git", "vim"), Stdout: "git\t2.43.0-1.fc40\nvim\t9.10-2.fc40\n"},
+		fakeCommand{Argv: []string{"sudo", "dnf", "versionlock", "delete", "git", "vim"}, Sudo: true},
+		fakeCommand{Argv: rpm("git", "curl", "vim"), Stdout: "git\t2.43.0-1.fc40\nvim\t9.10-2.fc40\n", ExitCode: 1},
+		fakeCommand{Argv: []string{"sudo", "dnf", "remove", "-y", "-q", "git", "vim"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	want := []PackageDrift{{Package: "vim", Pinned: "9.1", Installed: "9.10-2.fc40"}}
+	if len(gpm.Results) != 1 || !reflect.DeepEqual(gpm.Results[0].Drift, want) {
+		t.Errorf("got results %+v, want vim to have drifted", gpm.Results)
+	}
+
+	if err := gpm.TearDown(); err != nil {
+		t.Fatalf("TearDown returned an error: %v", err)
+	}
+}
+
+func TestAptPinsAreResolvedToExactVersions(t *testing.T) {
+	madison := " git | 1:2.43.0-1ubuntu7.1 | http://archive.ubuntu.com/ubuntu noble-updates/main amd64 Packages\n" +
+		" git | 1:2.43.0-1ubuntu7 | http://archive.ubuntu.com/ubuntu noble/main amd64 Packages\n" +
+		"curl | 8.5.0-2ubuntu10 | http://archive.ubuntu.com/ubuntu noble/main amd64 Packages\n" +
+		"curl | 8.50-1 | http://ppa.launchpadcontent.net/someone/curl/ubuntu noble/main amd64 Packages\n"
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: append(append([]string{}, dpkgQuery.Command...), "git", "curl", "vim"), ExitCode: 1},
+		fakeCommand{Argv: []string{"apt-cache", "madison", "git", "curl", "vim"}, Stdout: madison},
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git=1:2.43.0-1ubuntu7.1", "curl=8.5.0-2ubuntu10", "vim=9.1"},
+			Sudo: true},
+	))
+	if err := aptitude.Install([]string{"git@2.43.0", "curl@8.5.0", "vim@9.1"}, call); err != nil {
+		t.Fatalf("Install returned an error: %v", err)
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	available := []string{"1:2.43.0-1ubuntu7.1", "1:2.43.0-1ubuntu7", "1:2.430-1"}
+	tests := []struct {
+		pinned string
+		want   string
+		found  bool
+	}{
+		{"2.43.0", "1:2.43.0-1ubuntu7.1", true},
+		{"2.43.0-1ubuntu7", "1:2.43.0-1ubuntu7", true},
+		{"1:2.43.0-1ubuntu7", "1:2.43.0-1ubuntu7", true},
+		{"1:2.43.0", "1:2.43.0-1ubuntu7.1", true},
+		{"2.43", "", false},
+		{"2.4", "", false},
+		{"0:2.43.0", "", false},
+	}
+	for _, test := range tests {
+		if got, found := resolveVersion(available, test.pinned); got != test.want || found != test.found {
+			t.Errorf("%s: got %q, %t, want %q, %t", test.pinned, got, found, test.want, test.found)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// packageQuery is how to ask a manager which versions of packages are installed.
+type packageQuery struct {
+	// Command is the argv the package names are appended to.
+	Command []string
+	// parse turns the command's output into each installed package's version, by name.
+	parse func(stdout string) map[string]string
+}
+
+// dpkgQuery asks dpkg for the version and state of each package, only those in the installed state count.
+var dpkgQuery = packageQuery{
+	Command: []string{"dpkg-query", "--show", "--showformat=${Package}\t${Version}\t${db:Status-Abbrev}\n"},
+	parse: func(stdout string) map[string]string {
+		return parseVersionLines(stdout, "\t", func(fields []string) bool {
+			// The abbreviated status is the wanted state then the actual one, e.g. "ii " or "hi " when held.
+			return len(fields) > 2 && len(fields[2]) > 1 && fields[2][1] == 'i'
+		})
+	},
+}
+
+// rpmQuery asks rpm, for the managers of distros built on it.
+var rpmQuery = packageQuery{
+	Command: []string{"rpm", "--query", "--queryformat", "%{NAME}\t%{VERSION}-%{RELEASE}\n"},
+	parse: func(stdout string) map[string]string {
+		return parseVersionLines(stdout, "\t", nil)
+	},
+}
+
+// pacmanQuery asks pacman, which prints the name and version of each installed package.
+var pacmanQuery = packageQuery{
+	Command: []string{"pacman", "--query"},
+	parse: func(stdout string) map[string]string {
+		return parseVersionLines(stdout, " ", nil)
+	},
+}
+
+// brewQuery asks brew, which prints each installed version of a formula after its name, the newest last.
+var brewQuery = packageQuery{
+	Command: []string{"brew", "list", "--versions"},
+	parse: func(stdout string) map[string]string {
+		versions := make(map[string]string)
+		for _, line := range strings.Split(stdout, "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 {
+				versions[fields[0]] = fields[len(fields)-1]
+			}
+		}
+		return versions
+	},
+}
+
+// parseVersionLines parses lines of a name and a version split by sep, skipping lines that aren't,
+// such as rpm's "package x is not installed", and lines installed rejects when it is given.
+func parseVersionLines(stdout string, sep string, installed func(fields []string) bool) map[string]string {
+	versions := make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), sep)
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			continue
+		}
+		if installed != nil && !installed(fields) {
+			continue
+		}
+		versions[fields[0]] = fields[1]
+	}
+	return versions
+}
+
+// installedVersions returns the installed version of each of the packages that is installed, by name.
+// Managers exit non-zero when some of the packages aren't installed, which only means they are left out.
+func (pm *BasePackageManager) installedVersions(names []string, call *SysCall) (map[string]string, error) {
+	var noQueryErr = errors.New(pm.Name + " has no way to query installed packages")
+	if pm.Query == nil {
+		return nil, noQueryErr
+	}
+	if len(names) < 1 {
+		return map[string]string{}, nil
+	}
+	args := append(append([]string{}, pm.Query.Command[1:]...), names...)
+	result, err := call.Exec(pm.Query.Command[0], args, false)
+	var commandErr *CommandError
+	if err != nil && !(errors.As(err, &commandErr) && commandErr.Result.ExitCode > 0) {
+		return nil, err
+	}
+	return pm.Query.parse(result.Stdout), nil
+}
+
+// queryName returns the name the manager knows a package by when queried, which for brew's versioned formulae includes the version.
+func (pm *BasePackageManager) queryName(spec packageSpec) string {
+	if pm.VersionInName && spec.Version != "" {
+		return fmt.Sprintf(pm.VersionFormat, spec.Name, spec.Version)
+	}
+	return spec.Name
+}
+
+// needed returns the packages an install or uninstall would change. Install skips packages that are installed,
+// at their pinned version when they have one, and uninstall skips those that aren't installed.
+// When the manager can't be asked, every package is needed and the manager sorts it out.
+func (pm *BasePackageManager) needed(operation string, packages []string, call *SysCall) []string {
+	if pm.Query == nil || len(packages) < 1 || (operation != "install" && operation != "uninstall") {
+		return packages
+	}
+	specs := parsePackageSpecs(packages)
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = pm.queryName(spec)
+	}
+	installed, err := pm.installedVersions(names, call)
+	if err != nil {
+		slog.Warn("unable to query installed packages, passing them all to the manager", "manager", pm.Name, "error", err)
+		return packages
+	}
+
+	var needed []string
+	for i, spec := range specs {
+		version, isInstalled := installed[names[i]]
+		switch operation {
+		case "install":
+			pinnedElsewhere := spec.Version != "" && !pm.VersionInName && !matchesVersion(version, spec.Version)
+			if !isInstalled || pinnedElsewhere {
+				needed = append(needed, packages[i])
+			}
+		case "uninstall":
+			if isInstalled {
+				needed = append(needed, packages[i])
+			}
+		}
+	}
+	if skipped := len(packages) - len(needed); skipped > 0 {
+		slog.Info("skipping packages with nothing to do", "manager", pm.Name, "operation", operation, "skipped", skipped)
+	}
+	return needed
+}
@@ -0,0 +1,53 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstalledVersions(t *testing.T) {
+	tests := []struct {
+		pm      *BasePackageManager
+		command fakeCommand
+		want    map[string]string
+	}{
+		{&aptitude, fakeCommand{
+			Argv:     []string{"dpkg-query", "--show", "--showformat=${Package}\t${Version}\t${db:Status-Abbrev}\n", "git", "curl", "vim"},
+			Stdout:   "git\t1:2.43.0-1ubuntu7\tii \ncurl\t8.5.0-2ubuntu10\trc \n",
+			Stderr:   "dpkg-query: no packages found matching vim\n",
+			ExitCode: 1,
+		}, map[string]string{"git": "1:2.43.0-1ubuntu7"}},
+		{&dnf, fakeCommand{
+			Argv:     []string{"rpm", "--query", "--queryformat", "%{NAME}\t%{VERSION}-%{RELEASE}\n", "git", "curl", "vim"},
+			Stdout:   "git\t2.43.0-1.fc40\ncurl\t8.6.0-7.fc40\npackage vim is not installed\n",
+			ExitCode: 1,
+		}, map[string]string{"git": "2.43.0-1.fc40", "curl": "8.6.0-7.fc40"}},
+		{&pacman, fakeCommand{
+			Argv:     []string{"pacman", "--query", "git", "curl", "vim"},
+			Stdout:   "git 2.43.0-1\n",
+			Stderr:   "error: package 'curl' was not found\nerror: package 'vim' was not found\n",
+			ExitCode: 1,
+		}, map[string]string{"git": "2.43.0-1"}},
+		{&brew, fakeCommand{
+			Argv:   []string{"brew", "list", "--versions", "git", "curl", "vim"},
+			Stdout: "git 2.42.0 2.43.0\nvim 9.1.0\n",
+		}, map[string]string{"git": "2.43.0", "vim": "9.1.0"}},
+	}
+	for _, test := range tests {
+		call := newFakeSysCall(t, newFakeRunner(t).expect(test.command))
+		got, err := test.pm.installedVersions([]string{"git", "curl", "vim"}, call)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v and error %v, want %v", test.pm.Name, got, err, test.want)
+		}
+	}
+}
+
+func TestNeededWithoutQuery(t *testing.T) {
+	// A query that can't run leaves it to the manager, rather than skipping anything.
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"pacman", "--query", "git"}, Error: "executable file not found in $PATH"},
+	))
+	if got := pacman.needed("install", []string{"git"}, call); !reflect.DeepEqual(got, []string{"git"}) {
+		t.Errorf("got %q, want every package", got)
+	}
+}
@@ -0,0 +1,553 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/download"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where repositories are written to, kept as variables so tests don't depend on the host's files.
+var (
+	aptSourcesDir  = "/etc/apt/sources.list.d"
+	aptKeyringsDir = "/etc/apt/keyrings"
+	yumReposDir    = "/etc/yum.repos.d"
+	zypperReposDir = "/etc/zypp/repos.d"
+	pacmanConfPath = "/etc/pacman.conf"
+)
+
+// repositoryFileName is what a repository written to a file of its own can be named, so the name is safe in a path.
+var repositoryFileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ownerAndName is how PPAs, COPR projects and brew taps are named, e.g. deadsnakes/ppa.
+var ownerAndName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*/[A-Za-z0-9._-]+$`)
+
+// armoredKeyHeader starts a key that is ASCII armored, which apt wants named .asc rather than .gpg.
+const armoredKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// Repository is a package repository to add before installing packages, and remove again on teardown.
+// It is only added when the manager it is for is in use, so one config can carry repositories for several distros.
+type Repository struct {
+	// Type is apt, ppa, dnf, copr, zypper, pacman or tap.
+	Type string `yaml:"type"`
+	// Name is the file the repository is written to for apt and dnf, the section for pacman and the alias for zypper.
+	// PPAs, COPR projects and taps are named owner/name.
+	Name string `yaml:"name"`
+	// URL is the repository, for dnf it can be a .repo file, and for a tap it is only needed when it isn't on GitHub.
+	URL string `yaml:"url,omitempty"`
+	// Suite is the apt suite, defaulting to the release's codename, and Components are its components, defaulting to main.
+	Suite      string   `yaml:"suite,omitempty"`
+	Components []string `yaml:"components,omitempty"`
+	// Key is what the repository is signed with, a URL for apt and dnf and a fingerprint for pacman.
+	// zypper imports the key the repository publishes.
+	Key string `yaml:"key,omitempty"`
+	// KeySHA256 is checked against the downloaded key when it is given, or against the .repo file for a dnf repository
+	// given as one, since that names the key to trust.
+	KeySHA256 string `yaml:"key-sha256,omitempty"`
+}
+
+// addedRepository is a repository we added and what adding it changed, as kept in the state.
+type addedRepository struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Manager string   `json:"manager"`
+	Files   []string `json:"files,omitempty"`
+	Key     string   `json:"key,omitempty"`
+}
+
+// packageManagerState is what GenericPackageManager keeps in the state between runs.
+type packageManagerState struct {
+	Repositories []addedRepository `json:"repositories,omitempty"`
+	// Refreshed is when each manager's package index was last refreshed, by the manager's name.
+	Refreshed map[string]time.Time `json:"refreshed,omitempty"`
+}
+
+// repositoryType is how one type of repository is added and removed, and which managers use it.
+type repositoryType struct {
+	managers []string
+	// needsURL is whether the repository can't be added without a URL.
+	needsURL bool
+	// validName checks the repository's name.
+	validName *regexp.Regexp
+	// add adds the repository, returning nil when it was already there so it is never removed by us.
+	add    func(rc repositoryCall, repo Repository) (*addedRepository, error)
+	remove func(rc repositoryCall, added addedRepository) error
+	// refresh is whether the manager's package index needs refreshing once the repository is added or removed.
+	refresh bool
+}
+
+// repositoryCall is what adding or removing a repository runs with.
+type repositoryCall struct {
+	gpm     *GenericPackageManager
+	manager *BasePackageManager
+}
+
+// repositoryTypes maps the type in the config to how it is handled.
+var repositoryTypes = map[string]repositoryType{
+	"apt": {managers: []string{"apt-get"}, needsURL: true, validName: repositoryFileName,
+		add: addAptRepository, remove: removeRepositoryFiles, refresh: true},
+	"ppa": {managers: []string{"apt-get"}, validName: ownerAndName,
+		add: addPPA, remove: removePPA, refresh: true},
+	"dnf": {managers: []string{"dnf", "yum"}, needsURL: true, validName: repositoryFileName,
+		add: addYumRepository, remove: removeRepositoryFiles},
+	"copr": {managers: []string{"dnf", "yum"}, validName: ownerAndName,
+		add: addCopr, remove: removeCopr},
+	"zypper": {managers: []string{"zypper"}, needsURL: true, validName: repositoryFileName,
+		add: addZypperRepository, remove: removeZypperRepository},
+	"pacman": {managers: []string{"pacman"}, needsURL: true, validName: repositoryFileName,
+		add: addPacmanRepository, remove: removePacmanRepository, refresh: true},
+	"tap": {managers: []string{"brew"}, validName: ownerAndName,
+		add: addTap, remove: removeTap},
+}
+
+// validateRepositories makes sure every repository is of a type we know, and has what that type needs.
+func validateRepositories(repositories []Repository) error {
+	for _, repo := range repositories {
+		kind, ok := repositoryTypes[repo.Type]
+		if !ok {
+			return fmt.Errorf("repository %s has unknown type %q", repo.Name, repo.Type)
+		}
+		if !kind.validName.MatchString(repo.Name) {
+			return fmt.Errorf("%s repository has a bad name %q", repo.Type, repo.Name)
+		}
+		if kind.needsURL && repo.URL == "" {
+			return fmt.Errorf("%s repository %s has no url", repo.Type, repo.Name)
+		}
+	}
+	return nil
+}
+
+// addRepositories adds the configured repositories for the managers in use, recording each one in the state.
+// Repositories already in the state, or that were there before us, are left as they are.
+// A repository failing doesn't stop the others, every failure is returned once they have all been tried.
+func (gpm *GenericPackageManager) addRepositories() error {
+	repositories := gpm.config.ConfigSettings.Repositories
+	if len(repositories) == 0 {
+		return nil
+	}
+	store, err := gpm.Application.State()
+	if err != nil {
+		return internal.ErrorAs("GenericPackageManager.addRepositories", err)
+	}
+	var current packageManagerState
+	if err = store.Load(gpm.Name, &current); err != nil {
+		return internal.ErrorAs("GenericPackageManager.addRepositories", err)
+	}
+
+	var errs []error
+	refresh := make(map[*BasePackageManager]bool)
+	for _, repo := range repositories {
+		kind := repositoryTypes[repo.Type]
+		manager := gpm.repositoryManager(kind)
+		if manager == nil {
+			slog.Debug("skipping repository, its manager isn't in use", "type", repo.Type, "name", repo.Name)
+			continue
+		}
+		if slices.ContainsFunc(current.Repositories, func(added addedRepository) bool {
+			return added.Type == repo.Type && added.Name == repo.Name
+		}) {
+			continue
+		}
+		rc := repositoryCall{gpm: gpm, manager: manager}
+		added, addErr := kind.add(rc, repo)
+		if added != nil {
+			added.Type, added.Name, added.Manager = repo.Type, repo.Name, manager.Name
+		}
+		if addErr != nil {
+			errs = append(errs, fmt.Errorf("%s repository %s failed: %w", repo.Type, repo.Name, addErr))
+			// Whatever was only partly added is undone, so the next run starts over. What can't be undone is recorded,
+			// so teardown tries again.
+			if added == nil || kind.remove(rc, *added) == nil {
+				continue
+			}
+		}
+		if added == nil {
+			slog.Info("repository is already configured, leaving it alone", "type", repo.Type, "name", repo.Name)
+			continue
+		}
+		current.Repositories = append(current.Repositories, *added)
+		// Saved straight away, so a later failure can't lose track of what was added.
+		if err = store.Save(gpm.Name, current); err != nil {
+			return internal.ErrorAs("GenericPackageManager.addRepositories", errors.Join(append(errs, err)...))
+		}
+		if addErr == nil {
+			slog.Info("added repository", "type", repo.Type, "name", repo.Name)
+			refresh[manager] = refresh[manager] || kind.refresh
+		}
+	}
+	errs = append(errs, gpm.refreshRepositories(refresh))
+	if err = errors.Join(errs...); err != nil {
+		return internal.ErrorAs("GenericPackageManager.addRepositories", err)
+	}
+	return nil
+}
+
+// removeRepositories removes every repository the state says we added, newest first, whether or not it is still configured.
+// Those that fail to be removed stay in the state, so the next teardown tries them again.
+func (gpm *GenericPackageManager) removeRepositories() error {
+	store, err := gpm.Application.State()
+	if err != nil {
+		return internal.ErrorAs("GenericPackageManager.removeRepositories", err)
+	}
+	var current packageManagerState
+	if err = store.Load(gpm.Name, &current); err != nil {
+		return internal.ErrorAs("GenericPackageManager.removeRepositories", err)
+	}
+	if len(current.Repositories) == 0 {
+		return nil
+	}
+
+	var errs []error
+	refresh := make(map[*BasePackageManager]bool)
+	var kept []addedRepository
+	for i := len(current.Repositories) - 1; i >= 0; i-- {
+		added := current.Repositories[i]
+		kind, ok := repositoryTypes[added.Type]
+		manager, found := supportedPackageManagers[added.Manager]
+		if !ok || !found {
+			errs = append(errs, fmt.Errorf("%s repository %s failed: unknown type or manager", added.Type, added.Name))
+			kept = append([]addedRepository{added}, kept...)
+			continue
+		}
+		if removeErr := kind.remove(repositoryCall{gpm: gpm, manager: manager}, added); removeErr != nil {
+			errs = append(errs, fmt.Errorf("%s repository %s failed: %w", added.Type, added.Name, removeErr))
+			kept = append([]addedRepository{added}, kept...)
+			continue
+		}
+		slog.Info("removed repository", "type", added.Type, "name", added.Name)
+		refresh[manager] = refresh[manager] || kind.refresh
+	}
+	current.Repositories = kept
+	if err = store.Save(gpm.Name, current); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, gpm.refreshRepositories(refresh))
+	if err = errors.Join(errs...); err != nil {
+		return internal.ErrorAs("GenericPackageManager.removeRepositories", err)
+	}
+	return nil
+}
+
+// repositoryManager returns the manager in use that the type of repository is for, nil when none of them are.
+func (gpm *GenericPackageManager) repositoryManager(kind repositoryType) *BasePackageManager {
+	inUse := []*BasePackageManager{gpm.Manager()}
+	for pm := range gpm.managed {
+		if base, ok := pm.(*BasePackageManager); ok {
+			inUse = append(inUse, base)
+		}
+	}
+	for _, manager := range inUse {
+		if manager != nil && slices.Contains(kind.managers, manager.Name) {
+			return manager
+		}
+	}
+	return nil
+}
+
+// refreshRepositories refreshes the package index of each manager whose repositories changed in a way that needs it.
+func (gpm *GenericPackageManager) refreshRepositories(refresh map[*BasePackageManager]bool) error {
+	managers := make([]*BasePackageManager, 0, len(refresh))
+	for manager, needed := range refresh {
+		if needed {
+			managers = append(managers, manager)
+		}
+	}
+	sort.Slice(managers, func(i, j int) bool { return managers[i].Name < managers[j].Name })
+	var errs []error
+	for _, manager := range managers {
+		if manager == &pacman {
+			slog.Warn("pacman's databases are only synced along with the system, run `gasible upgrade` to use the changed repositories")
+		}
+		errs = append(errs, gpm.refreshIndex(manager, true))
+	}
+	return errors.Join(errs...)
+}
+
+// call returns the SysCall to run the repository's commands with.
+func (rc repositoryCall) call() *SysCall {
+	return rc.gpm.system()
+}
+
+// exists reports whether a file is already there.
+func (rc repositoryCall) exists(path string) bool {
+	_, err := rc.call().filesystem().Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// downloadKey fetches the repository's key, checked against its checksum when there is one.
+func (rc repositoryCall) downloadKey(repo Repository) ([]byte, error) {
+	downloader, err := rc.gpm.Application.Downloader()
+	if err != nil {
+		return nil, err
+	}
+	return downloader.Get(download.Request{URL: repo.Key, SHA256: repo.KeySHA256})
+}
+
+// addAptRepository writes the repository's key to its own keyring and a sources file for it signed by that keyring.
+func addAptRepository(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	sourcesPath := filepath.Join(aptSourcesDir, repo.Name+".list")
+	if rc.exists(sourcesPath) {
+		return nil, nil
+	}
+	suite := repo.Suite
+	if suite == "" {
+		suite = readOSRelease(osReleasePath)["VERSION_CODENAME"]
+	}
+	if suite == "" {
+		// A sources line without a suite breaks every apt-get update after it, so nothing is written.
+		return nil, fmt.Errorf("apt repository %s has no suite and /etc/os-release has no VERSION_CODENAME, set its suite", repo.Name)
+	}
+	added := &addedRepository{}
+	options := ""
+	if repo.Key != "" {
+		key, err := rc.downloadKey(repo)
+		if err != nil {
+			return nil, err
+		}
+		extension := ".gpg"
+		if bytes.HasPrefix(bytes.TrimSpace(key), []byte(armoredKeyHeader)) {
+			extension = ".asc"
+		}
+		keyringPath := filepath.Join(aptKeyringsDir, repo.Name+extension)
+		if _, err = rc.call().Exec("install", []string{"-d", "-m", "755", aptKeyringsDir}, true); err != nil {
+			return nil, err
+		}
+		if err = rc.call().WriteFileAsRoot(keyringPath, key, 0644); err != nil {
+			return nil, err
+		}
+		added.Files = append(added.Files, keyringPath)
+		options = " signed-by=" + keyringPath
+	}
+	architecture, err := rc.call().Exec("dpkg", []string{"--print-architecture"}, false)
+	if err != nil {
+		return added, err
+	}
+	components := repo.Components
+	if len(components) == 0 {
+		components = []string{"main"}
+	}
+	source := fmt.Sprintf("deb [arch=%s%s] %s %s %s\n",
+		strings.TrimSpace(architecture.Stdout), options, repo.URL, suite, strings.Join(components, " "))
+	if err = rc.call().WriteFileAsRoot(sourcesPath, []byte(source), 0644); err != nil {
+		return added, err
+	}
+	added.Files = append(added.Files, sourcesPath)
+	return added, nil
+}
+
+// removeRepositoryFiles removes the files that adding the repository wrote.
+func removeRepositoryFiles(rc repositoryCall, added addedRepository) error {
+	if len(added.Files) == 0 {
+		return nil
+	}
+	_, err := rc.call().Exec("rm", append([]string{"-f"}, added.Files...), true)
+	return err
+}
+
+// hasPPA reports whether a sources file already points at the PPA, however it was added.
+func (rc repositoryCall) hasPPA(name string) bool {
+	var sources []string
+	for _, pattern := range []string{"*.list", "*.sources"} {
+		matches, err := rc.call().filesystem().Glob(filepath.Join(aptSourcesDir, pattern))
+		if err == nil {
+			sources = append(sources, matches...)
+		}
+	}
+	for _, path := range sources {
+		contents, err := rc.call().filesystem().ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "#") && strings.Contains(line, "launchpad") && strings.Contains(line, "/"+name+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addPPA adds a Launchpad PPA, add-apt-repository fetches its key and refreshes apt itself.
+func addPPA(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	if rc.hasPPA(repo.Name) {
+		return nil, nil
+	}
+	if _, err := rc.call().Exec("add-apt-repository", []string{"-y", "ppa:" + repo.Name}, true); err != nil {
+		return nil, err
+	}
+	return &addedRepository{}, nil
+}
+
+// removePPA removes a Launchpad PPA.
+func removePPA(rc repositoryCall, added addedRepository) error {
+	_, err := rc.call().Exec("add-apt-repository", []string{"-y", "--remove", "ppa:" + added.Name}, true)
+	return err
+}
+
+// addYumRepository writes a .repo file, the one at the URL when it is one and otherwise one with the URL as its baseurl.
+func addYumRepository(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	repoPath := filepath.Join(yumReposDir, repo.Name+".repo")
+	if rc.exists(repoPath) {
+		return nil, nil
+	}
+	var contents []byte
+	if strings.HasSuffix(repo.URL, ".repo") {
+		downloader, err := rc.gpm.Application.Downloader()
+		if err != nil {
+			return nil, err
+		}
+		if contents, err = downloader.Get(download.Request{URL: repo.URL, SHA256: repo.KeySHA256}); err != nil {
+			return nil, err
+		}
+	} else {
+		var section strings.Builder
+		fmt.Fprintf(&section, "[%s]\nname=%s\nbaseurl=%s\nenabled=1\n", repo.Name, repo.Name, repo.URL)
+		if repo.Key != "" {
+			fmt.Fprintf(&section, "gpgcheck=1\ngpgkey=%s\n", repo.Key)
+		}
+		contents = []byte(section.String())
+	}
+	if err := rc.call().WriteFileAsRoot(repoPath, contents, 0644); err != nil {
+		return nil, err
+	}
+	return &addedRepository{Files: []string{repoPath}}, nil
+}
+
+// addCopr enables a COPR project, which needs the copr plugin from dnf-plugins-core.
+// The plugin writes each project to a .repo file named after its hub, owner and project.
+func addCopr(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	owner, project, _ := strings.Cut(repo.Name, "/")
+	if matches, _ := rc.call().filesystem().Glob(filepath.Join(yumReposDir, "_copr:*:"+owner+":"+project+".repo")); len(matches) > 0 {
+		return nil, nil
+	}
+	if _, err := rc.call().Exec(rc.manager.Name, []string{"copr", "enable", "-y", repo.Name}, true); err != nil {
+		return nil, err
+	}
+	return &addedRepository{}, nil
+}
+
+// removeCopr removes a COPR project's repository.
+func removeCopr(rc repositoryCall, added addedRepository) error {
+	_, err := rc.call().Exec(rc.manager.Name, []string{"copr", "remove", "-y", added.Name}, true)
+	return err
+}
+
+// addZypperRepository adds the repository with autorefresh on, then refreshes it to import the key it publishes.
+func addZypperRepository(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	if rc.exists(filepath.Join(zypperReposDir, repo.Name+".repo")) {
+		return nil, nil
+	}
+	if _, err := rc.call().Exec("zypper", []string{"--non-interactive", "addrepo", "--refresh", repo.URL, repo.Name}, true); err != nil {
+		return nil, err
+	}
+	added := &addedRepository{}
+	args := []string{"--non-interactive", "--gpg-auto-import-keys", "refresh", repo.Name}
+	if _, err := rc.call().Exec("zypper", args, true); err != nil {
+		return added, err
+	}
+	return added, nil
+}
+
+// removeZypperRepository removes the repository by its alias.
+func removeZypperRepository(rc repositoryCall, added addedRepository) error {
+	_, err := rc.call().Exec("zypper", []string{"--non-interactive", "removerepo", added.Name}, true)
+	return err
+}
+
+// pacmanMarkers return the comments around a repository's section in pacman.conf, which has no directory to drop files in.
+func pacmanMarkers(name string) (string, string) {
+	return "# gasible: " + name + "\n", "# gasible end: " + name + "\n"
+}
+
+// addPacmanRepository trusts the repository's key, then appends a section for it to pacman.conf.
+func addPacmanRepository(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	conf, err := rc.call().filesystem().ReadFile(pacmanConfPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(conf), "\n["+repo.Name+"]") {
+		return nil, nil
+	}
+	added := &addedRepository{}
+	// A key that was already trusted is left out of what we added, so removing the repository doesn't untrust it.
+	if repo.Key != "" && !rc.hasPacmanKey(repo.Key) {
+		for _, args := range [][]string{{"--recv-keys", repo.Key}, {"--lsign-key", repo.Key}} {
+			if _, err = rc.call().Exec("pacman-key", args, true); err != nil {
+				return nil, err
+			}
+		}
+		added.Key = repo.Key
+	}
+	begin, end := pacmanMarkers(repo.Name)
+	if len(conf) > 0 && !bytes.HasSuffix(conf, []byte("\n")) {
+		conf = append(conf, '\n')
+	}
+	conf = append(conf, fmt.Sprintf("\n%s[%s]\nServer = %s\n%s", begin, repo.Name, repo.URL, end)...)
+	if err = rc.call().WriteFileAsRoot(pacmanConfPath, conf, 0644); err != nil {
+		return added, err
+	}
+	return added, nil
+}
+
+// hasPacmanKey reports whether the key is already in pacman's keyring, pacman-key exits non-zero when it isn't.
+func (rc repositoryCall) hasPacmanKey(key string) bool {
+	_, err := rc.call().Exec("pacman-key", []string{"--list-keys", key}, true)
+	return err == nil
+}
+
+// removePacmanRepository takes the repository's section out of pacman.conf, and deletes its key.
+func removePacmanRepository(rc repositoryCall, added addedRepository) error {
+	conf, err := rc.call().filesystem().ReadFile(pacmanConfPath)
+	if err != nil {
+		return err
+	}
+	begin, end := pacmanMarkers(added.Name)
+	before, rest, found := strings.Cut(string(conf), "\n"+begin)
+	if found {
+		_, after, _ := strings.Cut(rest, end)
+		if err = rc.call().WriteFileAsRoot(pacmanConfPath, []byte(before+after), 0644); err != nil {
+			return err
+		}
+	}
+	if added.Key != "" {
+		if _, err = rc.call().Exec("pacman-key", []string{"--delete", added.Key}, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTap taps the repository, from its URL when it has one.
+func addTap(rc repositoryCall, repo Repository) (*addedRepository, error) {
+	taps, err := rc.call().Exec("brew", []string{"tap"}, false)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(strings.Fields(taps.Stdout), repo.Name) {
+		return nil, nil
+	}
+	args := []string{"tap", repo.Name}
+	if repo.URL != "" {
+		args = append(args, repo.URL)
+	}
+	if _, err = rc.call().Exec("brew", args, false); err != nil {
+		return nil, err
+	}
+	return &addedRepository{}, nil
+}
+
+// removeTap untaps the repository.
+func removeTap(rc repositoryCall, added addedRepository) error {
+	_, err := rc.call().Exec("brew", []string{"untap", added.Name}, false)
+	return err
+}
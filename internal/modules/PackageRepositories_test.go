@@ -0,0 +1,301 @@
+package modules
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubRepositoryPaths points the repository files at a temporary directory, so tests don't read the host's.
+func stubRepositoryPaths(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	realSources, realKeyrings, realRepos, realZypperRepos, realPacmanConf := aptSourcesDir, aptKeyringsDir, yumReposDir, zypperReposDir, pacmanConfPath
+	aptSourcesDir = filepath.Join(dir, "sources.list.d")
+	aptKeyringsDir = filepath.Join(dir, "keyrings")
+	yumReposDir = filepath.Join(dir, "yum.repos.d")
+	zypperReposDir = filepath.Join(dir, "repos.d")
+	pacmanConfPath = filepath.Join(dir, "pacman.conf")
+	t.Cleanup(func() {
+		aptSourcesDir, aptKeyringsDir, yumReposDir, zypperReposDir, pacmanConfPath =
+			realSources, realKeyrings, realRepos, realZypperRepos, realPacmanConf
+	})
+	return dir
+}
+
+// addedRepositories returns the repositories the state says were added.
+func addedRepositories(t *testing.T, gpm *GenericPackageManager) []addedRepository {
+	t.Helper()
+	store, err := gpm.Application.State()
+	if err != nil {
+		t.Fatalf("unable to open the state: %v", err)
+	}
+	var current packageManagerState
+	if err = store.Load(gpm.Name, &current); err != nil {
+		t.Fatalf("unable to load the state: %v", err)
+	}
+	return current.Repositories
+}
+
+func TestAptRepositoriesAreRemovedOnTeardown(t *testing.T) {
+	stubRepositoryPaths(t)
+	const key = armoredKeyHeader + "\ntest key\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(key))
+	}))
+	t.Cleanup(server.Close)
+
+	settings := map[string]interface{}{
+		"manager": "apt-get",
+		"repositories": []map[string]interface{}{
+			{"type": "apt", "name": "docker", "url": "https://download.docker.com/linux/debian",
+				"components": []string{"stable"}, "key": server.URL + "/gpg"},
+			{"type": "ppa", "name": "deadsnakes/ppa"},
+			{"type": "copr", "name": "atim/lazygit"},
+		},
+	}
+	keyring := filepath.Join(aptKeyringsDir, "docker.asc")
+	sources := filepath.Join(aptSourcesDir, "docker.list")
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "install", "-d", "-m", "755", aptKeyringsDir}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "install", "-m", "644", "/dev/stdin", keyring}, Sudo: true, Stdin: key},
+		fakeCommand{Argv: []string{"dpkg", "--print-architecture"}, Stdout: "amd64\n"},
+		fakeCommand{Argv: []string{"sudo", "install", "-m", "644", "/dev/stdin", sources}, Sudo: true,
+			Stdin: "deb [arch=amd64 signed-by=" + keyring + "] https://download.docker.com/linux/debian bookworm stable\n"},
+		fakeCommand{Argv: []string{"sudo", "add-apt-repository", "-y", "ppa:deadsnakes/ppa"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	stubDetection(t, "ID=debian\nVERSION_CODENAME=bookworm\n", "apt-get")
+
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	if got := addedRepositories(t, gpm); len(got) != 2 || got[0].Name != "docker" || got[1].Name != "deadsnakes/ppa" {
+		t.Fatalf("got %+v in the state, want the apt repository and the PPA", got)
+	}
+	// A second setup leaves the repositories it already added alone.
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("second Setup returned an error: %v", err)
+	}
+
+	fake.expect(
+		fakeCommand{Argv: []string{"sudo", "add-apt-repository", "-y", "--remove", "ppa:deadsnakes/ppa"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "rm", "-f", keyring, sources}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+	)
+	if err := gpm.TearDown(); err != nil {
+		t.Fatalf("TearDown returned an error: %v", err)
+	}
+	if got := addedRepositories(t, gpm); len(got) != 0 {
+		t.Fatalf("got %+v in the state, want nothing left after teardown", got)
+	}
+}
+
+func TestExistingRepositoriesAreLeftAlone(t *testing.T) {
+	stubRepositoryPaths(t)
+	if err := os.MkdirAll(yumReposDir, 0700); err != nil {
+		t.Fatalf("unable to create the repos directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(yumReposDir, "vscode.repo"), []byte("[code]\n"), 0600); err != nil {
+		t.Fatalf("unable to write the repo file: %v", err)
+	}
+	settings := map[string]interface{}{
+		"managers": map[string][]string{"brew": {}},
+		"repositories": []map[string]interface{}{
+			{"type": "dnf", "name": "vscode", "url": "https://packages.microsoft.com/yumrepos/vscode"},
+			{"type": "copr", "name": "atim/lazygit"},
+			{"type": "tap", "name": "homebrew/cask-fonts"},
+		},
+	}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "dnf", "copr", "enable", "-y", "atim/lazygit"}, Sudo: true,
+			ExitCode: 1, Stderr: "No such command: copr.\n"},
+		fakeCommand{Argv: []string{"brew", "tap"}, Stdout: "homebrew/cask-fonts\nhomebrew/core\n"},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+
+	err := gpm.Setup()
+	if err == nil || !strings.Contains(err.Error(), "copr repository atim/lazygit failed") {
+		t.Fatalf("got error %v, want the COPR failure", err)
+	}
+	if got := addedRepositories(t, gpm); len(got) != 0 {
+		t.Fatalf("got %+v in the state, want nothing recorded for repositories we didn't add", got)
+	}
+}
+
+func TestPreexistingRepositoriesAreNotRecorded(t *testing.T) {
+	tests := []struct {
+		name    string
+		manager string
+		repo    map[string]interface{}
+		file    func() string
+		content string
+	}{
+		{"ppa", "apt-get", map[string]interface{}{"type": "ppa", "name": "deadsnakes/ppa"},
+			func() string { return filepath.Join(aptSourcesDir, "deadsnakes-ubuntu-ppa-noble.sources") },
+			"Types: deb\nURIs: https://ppa.launchpadcontent.net/deadsnakes/ppa/ubuntu/\nSuites: noble\n"},
+		{"copr", "dnf", map[string]interface{}{"type": "copr", "name": "atim/lazygit"},
+			func() string { return filepath.Join(yumReposDir, "_copr:copr.fedorainfracloud.org:atim:lazygit.repo") },
+			"[copr:copr.fedorainfracloud.org:atim:lazygit]\n"},
+		{"zypper", "zypper", map[string]interface{}{"type": "zypper", "name": "games", "url": "https://download.opensuse.org/repositories/games/openSUSE_Tumbleweed/"},
+			func() string { return filepath.Join(zypperReposDir, "games.repo") },
+			"[games]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubRepositoryPaths(t)
+			path := tt.file()
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				t.Fatalf("unable to create the repository directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unable to write the repository file: %v", err)
+			}
+			settings := map[string]interface{}{"manager": tt.manager, "repositories": []map[string]interface{}{tt.repo}}
+			gpm := newTestPackageManager(t, settings, newFakeRunner(t))
+			if err := gpm.Setup(); err != nil {
+				t.Fatalf("Setup returned an error: %v", err)
+			}
+			if got := addedRepositories(t, gpm); len(got) != 0 {
+				t.Fatalf("got %+v in the state, want the repository that was already there left out", got)
+			}
+		})
+	}
+}
+
+func TestRepoFileIsCheckedAgainstItsChecksum(t *testing.T) {
+	stubRepositoryPaths(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[code]\ngpgkey=https://attacker.example/key\n"))
+	}))
+	t.Cleanup(server.Close)
+	settings := map[string]interface{}{
+		"repositories": []map[string]interface{}{
+			{"type": "dnf", "name": "vscode", "url": server.URL + "/vscode.repo", "key-sha256": strings.Repeat("0", 64)},
+		},
+	}
+	gpm := newTestPackageManager(t, settings, newFakeRunner(t))
+	if err := gpm.Setup(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("got error %v, want the .repo file's checksum to fail", err)
+	}
+	if got := addedRepositories(t, gpm); len(got) != 0 {
+		t.Fatalf("got %+v in the state, want nothing written for a file that failed its checksum", got)
+	}
+}
+
+func TestPacmanRepositorySection(t *testing.T) {
+	stubRepositoryPaths(t)
+	const conf = "[options]\nArchitecture = auto\n"
+	if err := os.WriteFile(pacmanConfPath, []byte(conf), 0600); err != nil {
+		t.Fatalf("unable to write pacman.conf: %v", err)
+	}
+	settings := map[string]interface{}{
+		"manager": "pacman",
+		"repositories": []map[string]interface{}{
+			{"type": "pacman", "name": "chaotic-aur", "url": "https://cdn-mirror.chaotic.cx/$repo/$arch", "key": "3056513887B78AEB"},
+		},
+	}
+	section := "\n# gasible: chaotic-aur\n[chaotic-aur]\nServer = https://cdn-mirror.chaotic.cx/$repo/$arch\n# gasible end: chaotic-aur\n"
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "pacman-key", "--list-keys", "3056513887B78AEB"}, Sudo: true, ExitCode: 1,
+			Stderr: "==> ERROR: The key identified by 3056513887B78AEB doesn't exist\n"},
+		fakeCommand{Argv: []string{"sudo", "pacman-key", "--recv-keys", "3056513887B78AEB"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "pacman-key", "--lsign-key", "3056513887B78AEB"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "install", "-m", "644", "/dev/stdin", pacmanConfPath}, Sudo: true, Stdin: conf + section},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+
+	// The fake doesn't write files, so stand in for the section having been added, with something after it.
+	if err := os.WriteFile(pacmanConfPath, []byte(conf+section+"\n[extra]\n"), 0600); err != nil {
+		t.Fatalf("unable to write pacman.conf: %v", err)
+	}
+	fake.expect(
+		fakeCommand{Argv: []string{"sudo", "install", "-m", "644", "/dev/stdin", pacmanConfPath}, Sudo: true, Stdin: conf + "\n[extra]\n"},
+		fakeCommand{Argv: []string{"sudo", "pacman-key", "--delete", "3056513887B78AEB"}, Sudo: true},
+	)
+	if err := gpm.TearDown(); err != nil {
+		t.Fatalf("TearDown returned an error: %v", err)
+	}
+}
+
+func TestPacmanKeyTrustedBeforeIsKept(t *testing.T) {
+	stubRepositoryPaths(t)
+	const conf = "[options]\nArchitecture = auto\n"
+	if err := os.WriteFile(pacmanConfPath, []byte(conf), 0600); err != nil {
+		t.Fatalf("unable to write pacman.conf: %v", err)
+	}
+	settings := map[string]interface{}{
+		"manager": "pacman",
+		"repositories": []map[string]interface{}{
+			{"type": "pacman", "name": "chaotic-aur", "url": "https://cdn-mirror.chaotic.cx/$repo/$arch", "key": "3056513887B78AEB"},
+		},
+	}
+	section := "\n# gasible: chaotic-aur\n[chaotic-aur]\nServer = https://cdn-mirror.chaotic.cx/$repo/$arch\n# gasible end: chaotic-aur\n"
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "pacman-key", "--list-keys", "3056513887B78AEB"}, Sudo: true,
+			Stdout: "pub   rsa4096 2020-08-31 [SC]\n      EF925EA60F33D0CB85C44AD13056513887B78AEB\n"},
+		fakeCommand{Argv: []string{"sudo", "install", "-m", "644", "/dev/stdin", pacmanConfPath}, Sudo: true, Stdin: conf + section},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+
+	if err := os.WriteFile(pacmanConfPath, []byte(conf+section), 0600); err != nil {
+		t.Fatalf("unable to write pacman.conf: %v", err)
+	}
+	// Only the section comes out, the key was trusted before we added the repository.
+	fake.expect(fakeCommand{Argv: []string{"sudo", "install", "-m", "644", "/dev/stdin", pacmanConfPath}, Sudo: true, Stdin: conf})
+	if err := gpm.TearDown(); err != nil {
+		t.Fatalf("TearDown returned an error: %v", err)
+	}
+}
+
+func TestAptRepositoryNeedsASuite(t *testing.T) {
+	stubRepositoryPaths(t)
+	settings := map[string]interface{}{
+		"manager": "apt-get",
+		"repositories": []map[string]interface{}{
+			{"type": "apt", "name": "docker", "url": "https://download.docker.com/linux/debian", "components": []string{"stable"}},
+		},
+	}
+	gpm := newTestPackageManager(t, settings, newFakeRunner(t))
+	stubDetection(t, "ID=debian\nPRETTY_NAME=\"Debian GNU/Linux trixie/sid\"\n", "apt-get")
+
+	err := gpm.Setup()
+	if err == nil || !strings.Contains(err.Error(), "set its suite") {
+		t.Fatalf("got error %v, want one asking for the suite", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(aptSourcesDir, "docker.list")); !errors.Is(statErr, os.ErrNotExist) {
+		t.Fatalf("a sources file was written without a suite, stat error %v", statErr)
+	}
+}
+
+func TestRepositoriesAreValidated(t *testing.T) {
+	tests := []struct {
+		name string
+		repo Repository
+		want string
+	}{
+		{"unknown type", Repository{Type: "yast", Name: "x"}, "unknown type"},
+		{"no url", Repository{Type: "apt", Name: "docker"}, "has no url"},
+		{"path in name", Repository{Type: "dnf", Name: "../passwd", URL: "https://example.com"}, "bad name"},
+		{"ppa without owner", Repository{Type: "ppa", Name: "deadsnakes"}, "bad name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRepositories([]Repository{tt.repo})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
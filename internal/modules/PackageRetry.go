@@ -0,0 +1,171 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// PackageError is a package that failed by itself, once the batch it was in had failed.
+type PackageError struct {
+	Package string
+	Err     error
+}
+
+// PackageErrors is the packages that failed when a failed batch was retried one package at a time.
+type PackageErrors []PackageError
+
+// Error lists the packages that failed.
+func (pe PackageErrors) Error() string {
+	names := make([]string, len(pe))
+	for i, failure := range pe {
+		names[i] = failure.Package
+	}
+	return fmt.Sprintf("%d packages failed: %s", len(pe), strings.Join(names, ", "))
+}
+
+// Unwrap returns why each package failed, so errors.As still finds each one's CommandError.
+func (pe PackageErrors) Unwrap() []error {
+	errs := make([]error, len(pe))
+	for i, failure := range pe {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// exitedWithError returns the CommandError when the command ran and exited non-zero, the only failure a package
+// can cause by itself. A command that couldn't run, or couldn't get root, fails the same way for every package.
+func exitedWithError(err error) *CommandError {
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) && commandErr.Result.ExitCode > 0 {
+		return commandErr
+	}
+	return nil
+}
+
+// sameFailure reports whether a package failed by itself the way its batch did, without naming the package,
+// such as apt's lock being held or the network being down, so the rest of the packages would fail the same too.
+func sameFailure(batch *CommandError, single error, name string) bool {
+	retried := exitedWithError(single)
+	if retried == nil {
+		return true
+	}
+	stderr := strings.TrimSpace(retried.Result.Stderr)
+	return retried.Result.ExitCode == batch.Result.ExitCode && stderr == strings.TrimSpace(batch.Result.Stderr) &&
+		!strings.Contains(stderr, name)
+}
+
+// packageCommand is one command a manager runs for some of its packages.
+type packageCommand struct {
+	// args go before the packages' names on the command line, and suffix after them.
+	args   []string
+	suffix []string
+	// packages are the packages as written in the config, names what each one is called on the command line.
+	packages []string
+	names    []string
+	sudo     bool
+}
+
+// argv returns the command's args for the named packages.
+func (pc packageCommand) argv(names ...string) []string {
+	argv := append(append([]string{}, pc.args...), names...)
+	return append(argv, pc.suffix...)
+}
+
+// runPackageCommands runs a manager's commands one after the other, returning how long they took.
+// Managers like apt-get fail a whole batch over one bad name, so a batch that exits with an error is retried one
+// package at a time, and when there are other packages to do a package that fails by itself is set aside.
+// Those packages are returned as PackageErrors once the rest have run. A command that couldn't run at all,
+// or failed for every package, stops the rest and its error is returned as it is.
+func runPackageCommands(call *SysCall, executable string, operation string, commands []packageCommand) (time.Duration, error) {
+	total := 0
+	for _, command := range commands {
+		total += len(command.packages)
+	}
+	var duration time.Duration
+	var failed PackageErrors
+	for _, command := range commands {
+		result, err := call.Exec(executable, command.argv(command.names...), command.sudo)
+		if result != nil {
+			duration += result.Duration
+		}
+		commandErr := exitedWithError(err)
+		switch {
+		case err == nil:
+			continue
+		case commandErr == nil || total < 2:
+			return duration, err
+		case len(command.packages) > 1:
+			retryErr := retryEach(call, executable, operation, command, commandErr)
+			var retryFailed PackageErrors
+			if retryErr != nil && !errors.As(retryErr, &retryFailed) {
+				return duration, retryErr
+			}
+			failed = append(failed, retryFailed...)
+		default:
+			failed = append(failed, PackageError{Package: command.packages[0], Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return duration, failed
+	}
+	return duration, nil
+}
+
+// retryEach runs a batch that failed again for each package by itself. The packages that work are done,
+// and those that don't are returned. Only the first package is compared with the batch: when it fails just like
+// the batch did, the failure is one every package shares, such as apt's lock being held, so the batch's error is
+// returned without trying the rest. Once a package has worked, or failed over its own name, the batch's failure
+// wasn't shared, so the rest are each run whatever way they fail.
+func retryEach(call *SysCall, executable string, operation string, command packageCommand, batchErr *CommandError) error {
+	slog.Warn("batch failed, retrying each package by itself", "manager", executable, "operation", operation,
+		"packages", len(command.packages))
+	var failed PackageErrors
+	for i, name := range command.names {
+		_, err := call.Exec(executable, command.argv(name), command.sudo)
+		if err == nil {
+			continue
+		}
+		if i == 0 && sameFailure(batchErr, err, name) {
+			slog.Warn("package failed the same way as the batch, not retrying the rest", "manager", executable,
+				"package", command.packages[i])
+			return batchErr
+		}
+		failed = append(failed, PackageError{Package: command.packages[i], Err: err})
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// succeededPackages returns the packages the operation did, all of them when it succeeded,
+// those that didn't fail when the batch was retried one at a time, and none when it failed altogether.
+func succeededPackages(packages []string, err error) []string {
+	if err == nil {
+		return packages
+	}
+	var failed PackageErrors
+	if !errors.As(err, &failed) {
+		return nil
+	}
+	var succeeded []string
+	for _, name := range packages {
+		if !failed.contains(name) {
+			succeeded = append(succeeded, name)
+		}
+	}
+	return succeeded
+}
+
+// contains reports whether the package is one of those that failed.
+func (pe PackageErrors) contains(name string) bool {
+	for _, failure := range pe {
+		if failure.Package == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package modules
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFailedBatchIsRetriedPackageByPackage(t *testing.T) {
+	settings := map[string]interface{}{"manager": "apt-get", "packages": []string{"git", "not-a-package", "curl"}}
+	notFound := fakeCommand{Stderr: "E: Unable to locate package not-a-package\n", ExitCode: 100, Sudo: true}
+	batch, single := notFound, notFound
+	batch.Argv = []string{"sudo", "apt-get", "install", "-y", "-qq", "git", "not-a-package", "curl"}
+	single.Argv = []string{"sudo", "apt-get", "install", "-y", "-qq", "not-a-package"}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "apt-get", "update", "-y", "-qq"}, Sudo: true},
+		fakeCommand{Argv: append(append([]string{}, dpkgQuery.Command...), "git", "not-a-package", "curl"), ExitCode: 1},
+		batch,
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git"}, Sudo: true},
+		single,
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "curl"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+
+	err := gpm.Setup()
+	var failed PackageErrors
+	if !errors.As(err, &failed) {
+		t.Fatalf("got error %v, want the packages that failed", err)
+	}
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) || commandErr.Result.ExitCode != 100 {
+		t.Fatalf("got error %v, want the failed package's command error", err)
+	}
+	if got := gpm.Results[0].Failed; len(got) != 1 || got[0].Package != "not-a-package" {
+		t.Fatalf("got %+v, want only not-a-package to have failed", got)
+	}
+	if got := gpm.Results[0].Succeeded; !reflect.DeepEqual(got, []string{"git", "curl"}) {
+		t.Fatalf("got %v succeeded, want git and curl", got)
+	}
+}
+
+func TestBatchFailingForEveryPackageIsNotRetried(t *testing.T) {
+	locked := "E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (apt)\n"
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git", "curl", "vim"}, Sudo: true, ExitCode: 100, Stderr: locked},
+		fakeCommand{Argv: []string{"sudo", "apt-get", "install", "-y", "-qq", "git"}, Sudo: true, ExitCode: 100, Stderr: locked},
+	))
+	aptitudeWithoutQuery := aptitude
+	aptitudeWithoutQuery.Query = nil
+	err := aptitudeWithoutQuery.execute("install", []string{"git", "curl", "vim"}, *call)
+	var failed PackageErrors
+	if err == nil || errors.As(err, &failed) {
+		t.Fatalf("got error %v, want the batch's error rather than each package failing", err)
+	}
+}
+
+func TestBatchThatCouldNotRunIsNotRetried(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"brew", "list", "--versions", "jq", "yq"}, ExitCode: 1},
+		fakeCommand{Argv: []string{"brew", "install", "-q", "jq", "yq"}, Error: "executable file not found in $PATH"},
+	))
+	if err := brew.execute("install", []string{"jq", "yq"}, *call); err == nil {
+		t.Fatal("execute returned no error for a manager that couldn't run")
+	}
+}
+
+func TestFailedBatchThatWorksPackageByPackage(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"brew", "list", "--versions", "jq", "yq"}, ExitCode: 1},
+		fakeCommand{Argv: []string{"brew", "install", "-q", "jq", "yq"}, ExitCode: 1, Stderr: "Error: Connection reset\n"},
+		fakeCommand{Argv: []string{"brew", "install", "-q", "jq"}},
+		fakeCommand{Argv: []string{"brew", "install", "-q", "yq"}},
+	))
+	if err := brew.execute("install", []string{"jq", "yq"}, *call); err != nil {
+		t.Fatalf("execute returned an error: %v", err)
+	}
+}
+
+func TestPackageErrors(t *testing.T) {
+	reasons := []error{errors.New("not found"), errors.New("conflicts")}
+	failed := PackageErrors{{Package: "a", Err: reasons[0]}, {Package: "b", Err: reasons[1]}}
+	if got, want := failed.Error(), "2 packages failed: a, b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := failed.Unwrap(); !reflect.DeepEqual(got, reasons) {
+		t.Errorf("got %v, want %v", got, reasons)
+	}
+}
+
+func TestLanguageBatchIsRetriedPackageByPackage(t *testing.T) {
+	notFound := "error: could not find `not-a-crate` in registry `crates-io`\n"
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"cargo", "install", "ripgrep", "not-a-crate"}, ExitCode: 101, Stderr: notFound},
+		fakeCommand{Argv: []string{"cargo", "install", "ripgrep"}},
+		fakeCommand{Argv: []string{"cargo", "install", "not-a-crate"}, ExitCode: 101, Stderr: notFound},
+		fakeCommand{Argv: []string{"cargo", "install", "bat", "--version", "0.24.0"}},
+	))
+	err := cargo.Install([]string{"ripgrep", "not-a-crate", "bat@0.24.0"}, call)
+	var failed PackageErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || failed[0].Package != "not-a-crate" {
+		t.Fatalf("got error %v, want only not-a-crate to have failed", err)
+	}
+}
+
+func TestSnapAndFlatpakBatchesAreRetried(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "snap", "install", "htop", "not-a-snap"}, Sudo: true, ExitCode: 1,
+			Stderr: `error: snap "not-a-snap" not found` + "\n"},
+		fakeCommand{Argv: []string{"sudo", "snap", "install", "htop"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "snap", "install", "not-a-snap"}, Sudo: true, ExitCode: 1,
+			Stderr: `error: snap "not-a-snap" not found` + "\n"},
+		fakeCommand{Argv: []string{"sudo", "snap", "install", "node", "--channel=20/stable", "--classic"}, Sudo: true},
+
+		fakeCommand{Argv: []string{"sudo", "flatpak", "install", "--system", "-y", "--noninteractive", "org.mozilla.firefox", "org.not.anApp"},
+			Sudo: true, ExitCode: 1, Stderr: "error: Nothing matches org.not.anApp\n"},
+		fakeCommand{Argv: []string{"sudo", "flatpak", "install", "--system", "-y", "--noninteractive", "org.mozilla.firefox"}, Sudo: true},
+		fakeCommand{Argv: []string{"sudo", "flatpak", "install", "--system", "-y", "--noninteractive", "org.not.anApp"},
+			Sudo: true, ExitCode: 1, Stderr: "error: Nothing matches org.not.anApp\n"},
+	))
+	snaps := &snapManager{settings: testSnaps}
+	var failed PackageErrors
+	if err := snaps.Install([]string{"htop", "not-a-snap", "node"}, call); !errors.As(err, &failed) || !failed.contains("not-a-snap") {
+		t.Fatalf("got error %v, want only not-a-snap to have failed", err)
+	}
+	flatpaks, err := newFlatpakManager(FlatpakSettings{})
+	if err != nil {
+		t.Fatalf("newFlatpakManager returned an error: %v", err)
+	}
+	if err = flatpaks.Install([]string{"org.mozilla.firefox", "org.not.anApp"}, call); !errors.As(err, &failed) || !failed.contains("org.not.anApp") {
+		t.Fatalf("got error %v, want only org.not.anApp to have failed", err)
+	}
+}
+
+func TestSkippedPackagesAreNotReportedAsSucceeded(t *testing.T) {
+	settings := map[string]interface{}{"manager": "dnf", "packages": []string{"git", "curl"}}
+	fake := newFakeRunner(t).expect(
+		fakeCommand{Argv: append(append([]string{}, rpmQuery.Command...), "git", "curl"), Stdout: "git\t2.46.0-1.fc40\n", ExitCode: 1},
+		fakeCommand{Argv: []string{"sudo", "dnf", "install", "-y", "-q", "curl"}, Sudo: true},
+	)
+	gpm := newTestPackageManager(t, settings, fake)
+	if err := gpm.Setup(); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	if got := gpm.Results[0].Succeeded; !reflect.DeepEqual(got, []string{"curl"}) {
+		t.Fatalf("got %v succeeded, want only curl, git was already installed", got)
+	}
+}
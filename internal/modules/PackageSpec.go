@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"strings"
+	"unicode"
+)
+
+// packageSpec is a package as written in the config, with the version it is pinned to if any.
+// Packages are written as name@version whatever the manager, e.g. `golang.org/x/tools/gopls@latest` or `git@2.43.0`.
+type packageSpec struct {
+	Name    string
+	Version string
+}
+
+// parsePackageSpec splits a name@version spec, the @ leading a scoped npm name isn't a version.
+func parsePackageSpec(spec string) packageSpec {
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		return packageSpec{Name: spec[:i], Version: spec[i+1:]}
+	}
+	return packageSpec{Name: spec}
+}
+
+// String returns the spec as it is written in the config.
+func (s packageSpec) String() string {
+	if s.Version == "" {
+		return s.Name
+	}
+	return s.Name + "@" + s.Version
+}
+
+// parsePackageSpecs parses every spec.
+func parsePackageSpecs(specs []string) []packageSpec {
+	packages := make([]packageSpec, len(specs))
+	for i, spec := range specs {
+		packages[i] = parsePackageSpec(spec)
+	}
+	return packages
+}
+
+// matchesVersion reports whether an installed version is the pinned one, or a release of it,
+// so 1.2.3 matches 1.2.3 and 1.2.3-1.fc40 but not 1.2.30. A pin without an epoch matches any epoch,
+// so 2.43.0 matches Debian's 1:2.43.0-1ubuntu7.
+func matchesVersion(installed string, pinned string) bool {
+	if epoch, version, found := strings.Cut(installed, ":"); found && !strings.Contains(pinned, ":") && isDigits(epoch) {
+		installed = version
+	}
+	if !strings.HasPrefix(installed, pinned) {
+		return false
+	}
+	rest := strings.TrimPrefix(installed, pinned)
+	return rest == "" || !unicode.IsDigit(rune(rest[0]))
+}
+
+// isDigits reports whether text is a number, such as a version's epoch.
+func isDigits(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package modules
+
+import "testing"
+
+func TestParsePackageSpec(t *testing.T) {
+	tests := map[string]packageSpec{
+		"golang.org/x/tools/gopls@latest": {Name: "golang.org/x/tools/gopls", Version: "latest"},
+		"ripgrep":                         {Name: "ripgrep"},
+		"@biomejs/biome":                  {Name: "@biomejs/biome"},
+		"@biomejs/biome@1.8.3":            {Name: "@biomejs/biome", Version: "1.8.3"},
+	}
+	for spec, want := range tests {
+		if got := parsePackageSpec(spec); got != want {
+			t.Errorf("parsePackageSpec(%q) = %+v, want %+v", spec, got, want)
+		}
+	}
+}
+
+func TestMatchesVersion(t *testing.T) {
+	tests := []struct {
+		installed, pinned string
+		want              bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3-1.fc40", "1.2.3", true},
+		{"3.12.4", "3.12", true},
+		{"1.2.30", "1.2.3", false},
+		{"", "1.2.3", false},
+		{"1:2.43.0-1ubuntu7", "2.43.0", true},
+		{"1:2.43.0-1ubuntu7", "1:2.43.0", true},
+		{"1:2.43.0-1ubuntu7", "2:2.43.0", false},
+		{"1:2.43.1-1ubuntu7", "2.43.0", false},
+	}
+	for _, test := range tests {
+		if got := matchesVersion(test.installed, test.pinned); got != test.want {
+			t.Errorf("matchesVersion(%q, %q) = %t, want %t", test.installed, test.pinned, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package modules
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"time"
+)
+
+// SnapSettings are the snaps to install alongside the native packages.
+type SnapSettings struct {
+	Packages []SnapPackage `yaml:"packages,omitempty"`
+}
+
+// SnapPackage is a snap's name, optionally with the channel to track and whether it needs classic confinement.
+// In the config it can be just the name, e.g. `- code`.
+type SnapPackage struct {
+	Name    string `yaml:"name"`
+	Channel string `yaml:"channel,omitempty"`
+	Classic bool   `yaml:"classic,omitempty"`
+}
+
+// UnmarshalYAML accepts either a snap's name or a mapping with the name and its options.
+func (p *SnapPackage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		p.Name = node.Value
+		return nil
+	}
+	type plain SnapPackage
+	return node.Decode((*plain)(p))
+}
+
+// MarshalYAML writes a snap without options as just its name.
+func (p SnapPackage) MarshalYAML() (interface{}, error) {
+	if p.Channel == "" && !p.Classic {
+		return p.Name, nil
+	}
+	type plain SnapPackage
+	return plain(p), nil
+}
+
+// names returns the snap names to hand to the snap manager.
+func (s *SnapSettings) names() []string {
+	names := make([]string, len(s.Packages))
+	for i, pkg := range s.Packages {
+		names[i] = pkg.Name
+	}
+	return names
+}
+
+// snapManager implements packageManager for snaps, looking up each snap's options in its settings.
+// snapd always needs root, and it has nothing to confirm.
+type snapManager struct {
+	settings SnapSettings
+}
+
+// Install installs the snaps, those with a channel or classic confinement each get their own command.
+func (sm *snapManager) Install(names []string, call *SysCall) error {
+	return sm.execute("install", names, call)
+}
+
+// Uninstall removes the snaps.
+func (sm *snapManager) Uninstall(names []string, call *SysCall) error {
+	return sm.execute("remove", names, call)
+}
+
+// Update refreshes the snaps, moving those with a channel onto it.
+func (sm *snapManager) Update(names []string, call *SysCall) error {
+	return sm.execute("refresh", names, call)
+}
+
+// Upgrade refreshes every snap on the system.
+func (sm *snapManager) Upgrade(call *SysCall) error {
+	result, err := call.Exec("snap", []string{"refresh"}, true)
+	if err != nil {
+		return fmt.Errorf("snap upgrade failed: %w", err)
+	}
+	slog.Info("package manager finished running operation", "manager", "snap", "operation", "upgrade",
+		"duration", result.Duration.Round(time.Millisecond))
+	return nil
+}
+
+// execute runs the operation on the snaps without options together, then on each snap with options by itself,
+// since snap applies --channel and --classic to every snap on the command line.
+func (sm *snapManager) execute(operation string, names []string, call *SysCall) error {
+	plain := packageCommand{args: []string{operation}, sudo: true}
+	var commands []packageCommand
+	for _, name := range names {
+		options := sm.options(operation, sm.lookup(name))
+		if len(options) == 0 {
+			plain.packages = append(plain.packages, name)
+			plain.names = append(plain.names, name)
+			continue
+		}
+		commands = append(commands, packageCommand{args: []string{operation}, suffix: options,
+			packages: []string{name}, names: []string{name}, sudo: true})
+	}
+	if len(plain.packages) > 0 {
+		commands = append([]packageCommand{plain}, commands...)
+	}
+
+	duration, err := runPackageCommands(call, "snap", operation, commands)
+	if err != nil {
+		return fmt.Errorf("snap %s failed: %w", operation, err)
+	}
+	if len(commands) > 0 {
+		slog.Info("packages finished running operation", "manager", "snap", "operation", operation,
+			"packages", len(names), "duration", duration.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// options returns the snap's options that matter for the operation, removing a snap takes none.
+func (sm *snapManager) options(operation string, pkg SnapPackage) []string {
+	var options []string
+	if operation == "remove" {
+		return options
+	}
+	if pkg.Channel != "" {
+		options = append(options, "--channel="+pkg.Channel)
+	}
+	if pkg.Classic && operation == "install" {
+		options = append(options, "--classic")
+	}
+	return options
+}
+
+// lookup returns the configured options for a snap, a snap that isn't configured gets none.
+func (sm *snapManager) lookup(name string) SnapPackage {
+	for _, pkg := range sm.settings.Packages {
+		if pkg.Name == name {
+			return pkg
+		}
+	}
+	return SnapPackage{Name: name}
+}
@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+)
+
+// testSnaps is a snap without options, one tracking a channel and one needing classic confinement.
+var testSnaps = SnapSettings{Packages: []SnapPackage{
+	{Name: "htop"},
+	{Name: "node", Channel: "20/stable", Classic: true},
+	{Name: "spotify"},
+	{Name: "lxd", Channel: "5.21/stable"},
+}}
+
+func TestSnapSettingsUnmarshal(t *testing.T) {
+	raw := `
+packages:
+  - htop
+  - name: node
+    channel: 20/stable
+    classic: true
+`
+	var settings SnapSettings
+	if err := yaml.Unmarshal([]byte(raw), &settings); err != nil {
+		t.Fatalf("unable to parse settings: %v", err)
+	}
+	want := []SnapPackage{{Name: "htop"}, {Name: "node", Channel: "20/stable", Classic: true}}
+	if !reflect.DeepEqual(settings.Packages, want) {
+		t.Errorf("got packages %+v, want %+v", settings.Packages, want)
+	}
+}
+
+func TestSnapOperations(t *testing.T) {
+	tests := []struct {
+		operation string
+		run       func(*snapManager, []string, *SysCall) error
+		expected  []fakeCommand
+	}{
+		{"install", (*snapManager).Install, []fakeCommand{
+			{Argv: []string{"sudo", "snap", "install", "htop", "spotify"}, Sudo: true},
+			{Argv: []string{"sudo", "snap", "install", "node", "--channel=20/stable", "--classic"}, Sudo: true},
+			{Argv: []string{"sudo", "snap", "install", "lxd", "--channel=5.21/stable"}, Sudo: true},
+		}},
+		{"update", (*snapManager).Update, []fakeCommand{
+			{Argv: []string{"sudo", "snap", "refresh", "htop", "spotify"}, Sudo: true},
+			{Argv: []string{"sudo", "snap", "refresh", "node", "--channel=20/stable"}, Sudo: true},
+			{Argv: []string{"sudo", "snap", "refresh", "lxd", "--channel=5.21/stable"}, Sudo: true},
+		}},
+		{"uninstall", (*snapManager).Uninstall, []fakeCommand{
+			{Argv: []string{"sudo", "snap", "remove", "htop", "node", "spotify", "lxd"}, Sudo: true},
+		}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.operation, func(t *testing.T) {
+			sm := &snapManager{settings: testSnaps}
+			call := newFakeSysCall(t, newFakeRunner(t).expect(test.expected...))
+			if err := test.run(sm, testSnaps.names(), call); err != nil {
+				t.Fatalf("%s returned an error: %v", test.operation, err)
+			}
+		})
+	}
+}
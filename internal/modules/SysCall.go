@@ -1,12 +1,21 @@
 package modules
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"github.com/Linkinlog/gasible/internal"
 	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/journal"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"gopkg.in/yaml.v3"
 	"io"
-	"log"
+	"log/slog"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
+	"time"
 )
 
 // init
@@ -16,7 +25,8 @@ func init() {
 		name:    "SysCall",
 		Enabled: true,
 		Settings: sysCallSettings{
-			CurrentOS: runtime.GOOS,
+			CurrentOS:           runtime.GOOS,
+			PrivilegeEscalation: autoEscalation,
 		},
 		sysCommand: cmdRunner{},
 	})
@@ -28,23 +38,103 @@ type SysCall struct {
 	Enabled     bool
 	Settings    sysCallSettings
 	application *app.App
+	escalator   *privilegeEscalator
+	module      string
 	sysCommand
 }
 
 // sysCommand is what executes commands on the system.
+// The command is run exactly as given, sudo only tells the runner it has already been escalated by SysCall.
+// A command that fails to start or exits non-zero returns its result along with a *CommandError.
 type sysCommand interface {
-	Exec(command string, args []string, sudo bool) ([]byte, error)
-	ExecWithInput(command string, args []string, stdinInput string, sudo bool) ([]byte, error)
+	Exec(command string, args []string, sudo bool) (*CommandResult, error)
+	ExecWithInput(command string, args []string, stdinInput string, sudo bool) (*CommandResult, error)
+	ExecPipeline(stages []PipelineStage) (*CommandResult, error)
+	// ExecInteractive runs a command on the terminal, for when it may prompt, such as asking for a password.
+	ExecInteractive(command string, args []string) error
 }
 
-// sysCallSettings allows us to keep track of the running OS.
+// sysCallSettings allows us to keep track of the running OS and how to become root.
 type sysCallSettings struct {
-	CurrentOS string `yaml:"-"`
+	CurrentOS           string `yaml:"-"`
+	PrivilegeEscalation string `yaml:"privilege-escalation"`
 }
 
-// ParseConfig does nothing as there is no config for this module.
-func (s *SysCall) ParseConfig(_ map[string]interface{}) error {
-	return nil // No config for this module
+// ParseConfig takes in a map that ideally contains a YAML structure, to be marshalled into the config.
+func (s *SysCall) ParseConfig(rawConfig map[string]interface{}) error {
+	configBytes, err := yaml.Marshal(rawConfig)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.Unmarshal(configBytes, s)
+	if err != nil {
+		return err
+	}
+
+	name, escalator, err := resolveEscalator(s.Settings.PrivilegeEscalation)
+	if err != nil {
+		return internal.ErrorAs("SysCall.ParseConfig", err)
+	}
+	if name != s.Settings.PrivilegeEscalation {
+		slog.Debug("detected privilege escalation", "module", s.name, "escalation", name)
+	}
+	s.escalator = escalator
+	return nil
+}
+
+// Exec runs a command on the host system, as root when sudo is set, and records it in the journal.
+func (s *SysCall) Exec(command string, args []string, sudo bool) (*CommandResult, error) {
+	if sudo {
+		command, args = s.escalate(command, args)
+	}
+	started := time.Now()
+	result, err := s.sysCommand.Exec(command, args, sudo)
+	s.record(started, result, sudo, false, err)
+	return result, err
+}
+
+// ExecWithInput runs a command with stdinInput piped into it, as root when sudo is set, and records it in the journal.
+// The input is usually a secret, so it is never recorded and is redacted from anything that is.
+func (s *SysCall) ExecWithInput(command string, args []string, stdinInput string, sudo bool) (*CommandResult, error) {
+	if sudo {
+		command, args = s.escalate(command, args)
+	}
+	secrets.Remember(stdinInput)
+	started := time.Now()
+	result, err := s.sysCommand.ExecWithInput(command, args, stdinInput, sudo)
+	s.record(started, result, sudo, true, err)
+	return result, err
+}
+
+// record appends the command to this run's journal.
+func (s *SysCall) record(started time.Time, result *CommandResult, sudo bool, stdin bool, err error) {
+	if s.application == nil || result == nil {
+		return
+	}
+	history := s.application.Journal()
+	if history == nil {
+		return
+	}
+	entry := journal.Entry{
+		Time:     started,
+		Module:   s.module,
+		Action:   s.application.Action,
+		Argv:     result.Argv,
+		Sudo:     sudo,
+		Stdin:    stdin,
+		ExitCode: result.ExitCode,
+		Duration: result.Duration,
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+	}
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) && result.ExitCode < 0 {
+		entry.Error = commandErr.Err.Error()
+	}
+	if appendErr := history.Append(entry); appendErr != nil {
+		slog.Warn("unable to record command in history", "error", appendErr)
+	}
 }
 
 // Config returns the shallow-copied module config from our module's config.
@@ -80,46 +170,135 @@ func (s *SysCall) SetApp(app *app.App) {
 	s.application = app
 }
 
+// labeller is implemented by runners that can show which module a command is running for.
+type labeller interface {
+	withLabel(label string, mode outputMode, logger *slog.Logger) sysCommand
+}
+
+// environer is implemented by runners that can run commands with an environment other than our own.
+type environer interface {
+	withEnv(env []string) sysCommand
+}
+
+// ForModule returns a copy of the SysCall whose command output is labelled with the module's name,
+// and whose commands are logged with the module and the action being run.
+// When the app is sandboxed, its commands get the sandbox as their home directory too.
+func (s *SysCall) ForModule(name string) *SysCall {
+	labelled := *s
+	labelled.module = name
+	if runner, ok := s.sysCommand.(environer); ok && s.application != nil {
+		if env := s.application.CommandEnv(); env != nil {
+			labelled.sysCommand = runner.withEnv(env)
+		}
+	}
+	if runner, ok := labelled.sysCommand.(labeller); ok {
+		verbose := false
+		logger := slog.With("module", name)
+		if s.application != nil {
+			verbose = s.application.Config.Verbose
+			logger = logger.With("action", s.application.Action)
+		}
+		labelled.sysCommand = runner.withLabel(name, detectOutputMode(verbose), logger)
+	}
+	return &labelled
+}
+
 // cmdRunner implements SysCommand as a base command runner.
-type cmdRunner struct{}
+type cmdRunner struct {
+	label  string
+	mode   outputMode
+	logger *slog.Logger
+	// env is the environment commands run with, nil for our own.
+	env []string
+}
+
+// withLabel returns a runner that shows its output labelled with the module name.
+func (r cmdRunner) withLabel(label string, mode outputMode, logger *slog.Logger) sysCommand {
+	return cmdRunner{label: label, mode: mode, logger: logger, env: r.env}
+}
+
+// withEnv returns a runner whose commands run with env rather than our own environment.
+func (r cmdRunner) withEnv(env []string) sysCommand {
+	r.env = env
+	return r
+}
+
+// command returns the command to run with the runner's environment.
+func (r cmdRunner) command(name string, args []string) *exec.Cmd {
+	execCmd := exec.Command(name, args...)
+	execCmd.Env = r.env
+	return execCmd
+}
 
 // Exec for when we need to execute a command on the host system.
-func (r cmdRunner) Exec(command string, args []string, sudo bool) ([]byte, error) {
-	if sudo {
-		args = append([]string{command}, args...)
-		command = "sudo"
-	}
-	execCmd := exec.Command(command, args...)
-	log.Println("Executing: " + execCmd.String())
-	output, err := execCmd.CombinedOutput()
+func (r cmdRunner) Exec(command string, args []string, _ bool) (*CommandResult, error) {
+	execCmd := r.command(command, args)
+	result, err := r.run(execCmd)
 	if err != nil {
-		return nil, internal.ErrorAs("cmdRunner.Exec", err)
+		return result, internal.ErrorAs("cmdRunner.Exec", err)
 	}
-	return output, nil
+	return result, nil
 }
 
 // ExecWithInput for instances where we need to simulate piping something into a command.
-func (r cmdRunner) ExecWithInput(command string, args []string, stdinInput string, sudo bool) ([]byte, error) {
-	if sudo {
-		args = append([]string{command}, args...)
-		command = "sudo"
-	}
-	execCmd := exec.Command(command, args...)
+func (r cmdRunner) ExecWithInput(command string, args []string, stdinInput string, _ bool) (*CommandResult, error) {
+	execCmd := r.command(command, args)
+	execCmd.Stdin = strings.NewReader(stdinInput)
 
-	stdin, err := execCmd.StdinPipe()
+	result, err := r.run(execCmd)
 	if err != nil {
-		return []byte{}, internal.ErrorAs("ExecWithInput", err)
+		return result, internal.ErrorAs("ExecWithInput", err)
 	}
+	return result, nil
+}
 
-	_, writeErr := io.WriteString(stdin, stdinInput)
-	if writeErr != nil {
-		return []byte{}, internal.ErrorAs("ExecWithInput", writeErr)
+// ExecInteractive hands the terminal to the command, so it can prompt, and captures nothing.
+func (r cmdRunner) ExecInteractive(command string, args []string) error {
+	execCmd := r.command(command, args)
+	execCmd.Stdin, execCmd.Stdout, execCmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := execCmd.Run(); err != nil {
+		return internal.ErrorAs("cmdRunner.ExecInteractive", fmt.Errorf("%s failed: %w", execCmd, err))
 	}
+	return nil
+}
 
-	if closeErr := stdin.Close(); closeErr != nil {
-		return []byte{}, internal.ErrorAs("ExecWithInput", closeErr)
+// run executes the command, or pipeline of commands, showing its output as it runs and capturing stdout and
+// stderr separately. Every stage's stderr is captured, but only the last stage's stdout.
+func (r cmdRunner) run(execCmds ...*exec.Cmd) (*CommandResult, error) {
+	logger := r.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	argv := pipelineArgv(execCmds)
+	display := newLoggedDisplay(newCommandDisplay(r.mode, r.label, strings.Join(argv, " ")), logger, argv)
+	// Each stream gets its own lineWriter, as exec copies them in separate goroutines.
+	stdoutLines := &lineWriter{display: display}
+	stderrLines := &lineWriter{display: display}
+	var stdout, stderr bytes.Buffer
+	// Stages share stderr and write to it from their own goroutines.
+	stderrWriter := &lockedWriter{writer: io.MultiWriter(&stderr, stderrLines)}
+	last := execCmds[len(execCmds)-1]
+	last.Stdout = io.MultiWriter(&stdout, stdoutLines)
+	for _, execCmd := range execCmds {
+		execCmd.Stderr = stderrWriter
 	}
 
-	log.Println("Executing: " + execCmd.String())
-	return execCmd.CombinedOutput()
+	started := time.Now()
+	err := runPipeline(execCmds)
+	stdoutLines.flush()
+	stderrLines.flush()
+
+	result := &CommandResult{
+		Argv:     argv,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(started),
+	}
+	if err != nil {
+		commandErr := newCommandError(result, err)
+		display.finish(commandErr)
+		return result, commandErr
+	}
+	display.finish(nil)
+	return result, nil
 }
@@ -0,0 +1,280 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// record makes fixture-backed tests run the real commands and save what happened as their fixture,
+// e.g. `go test ./internal/modules -run TestGitHubSetup -record`. It runs real commands, so mind what they do.
+var record = flag.Bool("record", false, "run real commands and record them into the test fixtures")
+
+// fixtureDir is where recorded sessions are kept.
+// testdata/github-setup.json and github-teardown.json were written by hand, in the shape -record saves,
+// from what the commands print on Ubuntu. Recording them for real installs and removes gh with apt.
+const fixtureDir = "testdata"
+
+// fakeCommand is one command we expect to run and what it does when it is run.
+type fakeCommand struct {
+	Argv     []string `json:"argv"`
+	Sudo     bool     `json:"sudo,omitempty"`
+	Stdin    string   `json:"stdin,omitempty"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exit_code,omitempty"`
+	// Error is set when the command couldn't be started at all.
+	Error string `json:"error,omitempty"`
+}
+
+// session is a fixture file, the commands run in order.
+type session struct {
+	Commands []fakeCommand `json:"commands"`
+}
+
+// fakeRunner implements sysCommand by matching each command against the expected ones, in order,
+// and returning their scripted output. In record mode it runs real commands and remembers them instead.
+type fakeRunner struct {
+	t        *testing.T
+	mu       sync.Mutex
+	expected []fakeCommand
+	ran      int
+	// recorder is the real runner used in record mode, nil when replaying.
+	recorder sysCommand
+}
+
+// newFakeRunner returns a pointer to a fakeRunner expecting nothing, script it with expect.
+// Any expected commands that haven't run by the end of the test fail it.
+func newFakeRunner(t *testing.T) *fakeRunner {
+	t.Helper()
+	fake := &fakeRunner{t: t}
+	t.Cleanup(func() {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if fake.recorder == nil && fake.ran < len(fake.expected) {
+			t.Errorf("%d expected commands never ran, the first being `%s`",
+				len(fake.expected)-fake.ran, strings.Join(fake.expected[fake.ran].Argv, " "))
+		}
+	})
+	return fake
+}
+
+// replayFixture returns a pointer to a fakeRunner that replays testdata/<name>.json,
+// or with -record runs the real commands and writes them to that file once the test passes.
+func replayFixture(t *testing.T, name string) *fakeRunner {
+	t.Helper()
+	path := filepath.Join(fixtureDir, name+".json")
+	fake := newFakeRunner(t)
+	if *record {
+		fake.recorder = cmdRunner{}
+		t.Cleanup(func() {
+			if t.Failed() {
+				return
+			}
+			if err := fake.save(path); err != nil {
+				t.Errorf("unable to save fixture %s: %v", path, err)
+			}
+		})
+		return fake
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read fixture, record it with -record: %v", err)
+	}
+	var recorded session
+	if err = json.Unmarshal(contents, &recorded); err != nil {
+		t.Fatalf("unable to parse fixture %s: %v", path, err)
+	}
+	return fake.expect(recorded.Commands...)
+}
+
+// expect adds commands to the end of what we expect to run.
+func (f *fakeRunner) expect(commands ...fakeCommand) *fakeRunner {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expected = append(f.expected, commands...)
+	return f
+}
+
+// Exec matches the command against the next expected one.
+func (f *fakeRunner) Exec(command string, args []string, sudo bool) (*CommandResult, error) {
+	return f.run(command, args, "", sudo)
+}
+
+// ExecWithInput matches the command and its input against the next expected one.
+func (f *fakeRunner) ExecWithInput(command string, args []string, stdinInput string, sudo bool) (*CommandResult, error) {
+	return f.run(command, args, stdinInput, sudo)
+}
+
+// ExecPipeline matches the pipeline against the next expected command, its stages joined by pipeSeparator.
+func (f *fakeRunner) ExecPipeline(stages []PipelineStage) (*CommandResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sudo := false
+	for _, stage := range stages {
+		sudo = sudo || stage.Sudo
+	}
+	if f.recorder != nil {
+		result, err := f.recorder.ExecPipeline(stages)
+		f.remember(stagesArgv(stages), "", sudo, result, err)
+		return result, err
+	}
+	return f.replay(stagesArgv(stages), "", sudo)
+}
+
+// ExecInteractive matches the command against the next expected one, its scripted output is never shown.
+func (f *fakeRunner) ExecInteractive(command string, args []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	argv := append([]string{command}, args...)
+	if f.recorder != nil {
+		err := f.recorder.ExecInteractive(command, args)
+		result := &CommandResult{Argv: argv}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		f.remember(argv, "", false, result, err)
+		return err
+	}
+	_, err := f.replay(argv, "", false)
+	return err
+}
+
+// run replays, or records, a single command.
+func (f *fakeRunner) run(command string, args []string, stdinInput string, sudo bool) (*CommandResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	argv := append([]string{command}, args...)
+	if f.recorder != nil {
+		return f.recordCommand(argv, stdinInput, sudo)
+	}
+	return f.replay(argv, stdinInput, sudo)
+}
+
+// replay checks the command against the next expected one and returns its scripted result.
+func (f *fakeRunner) replay(argv []string, stdinInput string, sudo bool) (*CommandResult, error) {
+	if f.ran >= len(f.expected) {
+		f.t.Errorf("unexpected command `%s`", strings.Join(argv, " "))
+		return nil, &CommandError{Result: &CommandResult{Argv: argv, ExitCode: -1}, Err: errors.New("unexpected command")}
+	}
+	expected := f.expected[f.ran]
+	f.ran++
+	if !reflect.DeepEqual(argv, expected.Argv) {
+		f.t.Errorf("command %d:\n got `%s`\nwant `%s`", f.ran, strings.Join(argv, " "), strings.Join(expected.Argv, " "))
+	}
+	if sudo != expected.Sudo {
+		f.t.Errorf("command %d `%s`: got sudo %t, want %t", f.ran, strings.Join(argv, " "), sudo, expected.Sudo)
+	}
+	// Recorded sessions have their secrets and binary input redacted, so those match any input.
+	if expected.Stdin != secrets.Redacted && stdinInput != expected.Stdin {
+		f.t.Errorf("command %d `%s`: got stdin %q, want %q", f.ran, strings.Join(argv, " "), stdinInput, expected.Stdin)
+	}
+
+	result := &CommandResult{
+		Argv:     argv,
+		Stdout:   expected.Stdout,
+		Stderr:   expected.Stderr,
+		ExitCode: expected.ExitCode,
+		Duration: time.Millisecond,
+	}
+	switch {
+	case expected.Error != "":
+		result.ExitCode = -1
+		return result, &CommandError{Result: result, Err: errors.New(expected.Error)}
+	case expected.ExitCode != 0:
+		return result, &CommandError{Result: result, Err: fmt.Errorf("exit status %d", expected.ExitCode)}
+	}
+	return result, nil
+}
+
+// recordCommand runs the command for real and remembers it.
+func (f *fakeRunner) recordCommand(argv []string, stdinInput string, sudo bool) (*CommandResult, error) {
+	var result *CommandResult
+	var err error
+	if stdinInput != "" {
+		result, err = f.recorder.ExecWithInput(argv[0], argv[1:], stdinInput, sudo)
+	} else {
+		result, err = f.recorder.Exec(argv[0], argv[1:], sudo)
+	}
+	f.remember(argv, stdinInput, sudo, result, err)
+	return result, err
+}
+
+// remember adds a command that really ran to the session, with any known secrets redacted.
+func (f *fakeRunner) remember(argv []string, stdinInput string, sudo bool, result *CommandResult, err error) {
+	recorded := fakeCommand{Sudo: sudo, Stdin: secrets.Redact(stdinInput)}
+	// JSON can't hold binary input, such as a downloaded key, so it is left to match anything.
+	if !utf8.ValidString(stdinInput) {
+		recorded.Stdin = secrets.Redacted
+	}
+	for _, arg := range argv {
+		recorded.Argv = append(recorded.Argv, secrets.Redact(arg))
+	}
+	if result != nil {
+		recorded.Stdout = secrets.Redact(result.Stdout)
+		recorded.Stderr = secrets.Redact(result.Stderr)
+		recorded.ExitCode = result.ExitCode
+	}
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) && commandErr.Result.ExitCode < 0 {
+		recorded.Error = commandErr.Err.Error()
+	}
+	f.expected = append(f.expected, recorded)
+	f.ran++
+}
+
+// save writes every command that ran to a fixture file.
+func (f *fakeRunner) save(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	contents, err := json.MarshalIndent(session{Commands: f.expected[:f.ran]}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(contents, '\n'), 0644)
+}
+
+// newFakeSysCall returns a pointer to a SysCall that runs its commands through fake,
+// escalating with sudo as a regular user would.
+func newFakeSysCall(t *testing.T, fake sysCommand) *SysCall {
+	t.Helper()
+	realGeteuid := geteuid
+	geteuid = func() int { return 1000 }
+	t.Cleanup(func() { geteuid = realGeteuid })
+	return &SysCall{
+		name:       "SysCall",
+		Enabled:    true,
+		Settings:   sysCallSettings{PrivilegeEscalation: "sudo"},
+		escalator:  privilegeEscalators["sudo"],
+		sysCommand: fake,
+	}
+}
+
+// stagesArgv joins the command and args of each stage with pipeSeparator, as cmdRunner does in its results.
+func stagesArgv(stages []PipelineStage) []string {
+	var argv []string
+	for i, stage := range stages {
+		if i > 0 {
+			argv = append(argv, pipeSeparator)
+		}
+		argv = append(argv, stage.Command)
+		argv = append(argv, stage.Args...)
+	}
+	return argv
+}
@@ -0,0 +1,248 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/app"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"golang.org/x/term"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputMode decides how the output of a running command is shown.
+type outputMode int
+
+const (
+	// outputStream prints every line as it arrives, prefixed with the module name.
+	outputStream outputMode = iota
+	// outputProgress keeps a single spinner line per command, showing the latest line of output.
+	outputProgress
+)
+
+// spinnerFrames are drawn in turn while a command is running in progress mode.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spinnerInterval is how often the spinner moves on.
+const spinnerInterval = 100 * time.Millisecond
+
+// defaultTerminalWidth is used when we can't ask the terminal how wide it is.
+const defaultTerminalWidth = 80
+
+// terminalOutput is where command output is shown.
+var terminalOutput io.Writer = os.Stdout
+
+// detectOutputMode shows progress lines on an interactive terminal, and streams everything when verbose
+// or when stdout is redirected, where redrawing a line would only make a mess of the log.
+func detectOutputMode(verbose bool) outputMode {
+	if verbose {
+		return outputStream
+	}
+	if file, ok := terminalOutput.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		return outputProgress
+	}
+	return outputStream
+}
+
+// commandDisplay receives the output of a single command, line by line.
+type commandDisplay interface {
+	line(text string)
+	finish(err error)
+}
+
+// newCommandDisplay starts showing a command in the given mode, labelled with the module running it.
+func newCommandDisplay(mode outputMode, label string, command string) commandDisplay {
+	if label == "" {
+		label = "SysCall"
+	}
+	if mode == outputProgress {
+		return newProgressDisplay(terminalOutput, label, command)
+	}
+	return newStreamDisplay(terminalOutput, label, command)
+}
+
+// streamDisplay prints each line as it comes in.
+type streamDisplay struct {
+	out   io.Writer
+	label string
+}
+
+// newStreamDisplay returns a pointer to a streamDisplay, announcing the command first.
+func newStreamDisplay(out io.Writer, label string, command string) *streamDisplay {
+	_, _ = fmt.Fprintf(out, "[%s] Executing: %s\n", label, command)
+	return &streamDisplay{out: out, label: label}
+}
+
+// line prints a line of output with the module prefix.
+func (d *streamDisplay) line(text string) {
+	_, _ = fmt.Fprintf(d.out, "[%s] %s\n", d.label, text)
+}
+
+// finish does nothing, each line has already been printed.
+func (d *streamDisplay) finish(_ error) {}
+
+// progressDisplay redraws one line with a spinner, the command and its latest output.
+type progressDisplay struct {
+	mu      sync.Mutex
+	out     io.Writer
+	label   string
+	command string
+	latest  string
+	frame   int
+	started time.Time
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// newProgressDisplay returns a pointer to a progressDisplay and starts spinning.
+func newProgressDisplay(out io.Writer, label string, command string) *progressDisplay {
+	d := &progressDisplay{
+		out:     out,
+		label:   label,
+		command: command,
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	d.draw()
+	d.stopped.Add(1)
+	go d.spin()
+	return d
+}
+
+// spin moves the spinner along until the command finishes, so a quiet command still looks alive.
+func (d *progressDisplay) spin() {
+	defer d.stopped.Done()
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.frame = (d.frame + 1) % len(spinnerFrames)
+			d.mu.Unlock()
+			d.draw()
+		}
+	}
+}
+
+// line remembers the latest line of output to show next to the spinner.
+func (d *progressDisplay) line(text string) {
+	d.mu.Lock()
+	d.latest = strings.TrimSpace(text)
+	d.mu.Unlock()
+	d.draw()
+}
+
+// draw redraws the progress line, cut to the width of the terminal.
+func (d *progressDisplay) draw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	width := terminalWidth() - 1
+	// Leave room for the latest output, which says more about where we are than the command does.
+	status := fmt.Sprintf("[%s] %s %s", d.label, spinnerFrames[d.frame], truncate(d.command, width/2))
+	if d.latest != "" {
+		status += " | " + d.latest
+	}
+	_, _ = fmt.Fprintf(d.out, "\r\033[K%s", truncate(status, width))
+}
+
+// finish stops the spinner and replaces the progress line with the result.
+func (d *progressDisplay) finish(err error) {
+	close(d.done)
+	d.stopped.Wait()
+	mark := "✓"
+	if err != nil {
+		mark = "✗"
+	}
+	elapsed := time.Since(d.started).Round(time.Millisecond)
+	status := fmt.Sprintf("[%s] %s %s (%s)", d.label, mark, d.command, elapsed)
+	_, _ = fmt.Fprintf(d.out, "\r\033[K%s\n", truncate(status, terminalWidth()-1))
+}
+
+// terminalWidth returns how many columns the terminal has.
+func terminalWidth() int {
+	if file, ok := terminalOutput.(*os.File); ok {
+		if width, _, err := term.GetSize(int(file.Fd())); err == nil && width > 0 {
+			return width
+		}
+	}
+	return defaultTerminalWidth
+}
+
+// truncate cuts text down to width runes, marking that it was cut.
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width || width < 1 {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// loggedDisplay records a command and its output in the log, as well as showing it.
+type loggedDisplay struct {
+	commandDisplay
+	logger  *slog.Logger
+	started time.Time
+}
+
+// newLoggedDisplay logs the command starting and returns a display that logs everything it shows.
+// Known secrets are redacted from the command and its output before they are logged, as they are in the journal.
+func newLoggedDisplay(display commandDisplay, logger *slog.Logger, argv []string) *loggedDisplay {
+	logger = logger.With("command", secrets.RedactArgs(argv))
+	logger.Debug("running command")
+	return &loggedDisplay{commandDisplay: display, logger: logger, started: time.Now()}
+}
+
+// line logs the line of output, then shows it.
+func (d *loggedDisplay) line(text string) {
+	d.logger.Debug(app.CommandOutputMsg, "line", secrets.Redact(text))
+	d.commandDisplay.line(text)
+}
+
+// finish logs how the command went, then shows it.
+func (d *loggedDisplay) finish(err error) {
+	duration := time.Since(d.started).Round(time.Millisecond)
+	if err != nil {
+		d.logger.Debug("command failed", "duration", duration, "error", secrets.Redact(err.Error()))
+	} else {
+		d.logger.Debug("command finished", "duration", duration)
+	}
+	d.commandDisplay.finish(err)
+}
+
+// lineWriter splits whatever is written to it into lines for a commandDisplay.
+// Carriage returns count as line breaks too, so progress bars from apt and curl show up as they update.
+type lineWriter struct {
+	display commandDisplay
+	partial bytes.Buffer
+}
+
+// Write hands every complete line to the display and keeps the rest for the next write.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial.Write(p)
+	for {
+		buffered := w.partial.Bytes()
+		end := bytes.IndexAny(buffered, "\r\n")
+		if end < 0 {
+			return len(p), nil
+		}
+		if text := string(buffered[:end]); strings.TrimSpace(text) != "" {
+			w.display.line(text)
+		}
+		w.partial.Next(end + 1)
+	}
+}
+
+// flush hands over whatever is left once the command has exited.
+func (w *lineWriter) flush() {
+	if text := w.partial.String(); strings.TrimSpace(text) != "" {
+		w.display.line(text)
+	}
+	w.partial.Reset()
+}
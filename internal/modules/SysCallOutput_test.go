@@ -0,0 +1,33 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Linkinlog/gasible/internal/secrets"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// discardDisplay shows nothing.
+type discardDisplay struct{}
+
+func (discardDisplay) line(string)  {}
+func (discardDisplay) finish(error) {}
+
+func TestLoggedDisplayRedactsSecrets(t *testing.T) {
+	secrets.Remember("ghp_loggedDisplayToken")
+	var logged bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	display := newLoggedDisplay(discardDisplay{}, logger, []string{"gh", "auth", "login", "--with-token=ghp_loggedDisplayToken"})
+	display.line("logged in with ghp_loggedDisplayToken")
+	display.finish(errors.New("ghp_loggedDisplayToken expired"))
+
+	if strings.Contains(logged.String(), "ghp_loggedDisplayToken") {
+		t.Fatalf("the log has the token in it:\n%s", logged.String())
+	}
+	if count := strings.Count(logged.String(), secrets.Redacted); count < 4 {
+		t.Fatalf("got %d redactions, want the command, the line and the error redacted:\n%s", count, logged.String())
+	}
+}
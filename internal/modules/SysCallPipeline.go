@@ -0,0 +1,168 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// pipeSeparator goes between the stages of a pipeline's argv, the way it would in a shell.
+const pipeSeparator = "|"
+
+// PipelineStage is one command in a pipeline, its stdout feeds the next stage's stdin.
+type PipelineStage struct {
+	Command string
+	Args    []string
+	Sudo    bool
+}
+
+// Pipeline runs commands with each one's stdout piped into the next, like `a | b` in a shell but without one,
+// and records it in the journal. It fails if any stage fails, reporting the last stage that did, like pipefail.
+func (s *SysCall) Pipeline(stages ...PipelineStage) (*CommandResult, error) {
+	var emptyPipelineErr = errors.New("pipeline has no commands")
+	if len(stages) == 0 {
+		return nil, internal.ErrorAs("SysCall.Pipeline", emptyPipelineErr)
+	}
+	escalated := make([]PipelineStage, len(stages))
+	sudo := false
+	for i, stage := range stages {
+		escalated[i] = stage
+		if stage.Sudo {
+			escalated[i].Command, escalated[i].Args = s.escalate(stage.Command, stage.Args)
+			sudo = true
+		}
+	}
+	started := time.Now()
+	result, err := s.sysCommand.ExecPipeline(escalated)
+	s.record(started, result, sudo, false, err)
+	return result, err
+}
+
+// ExecWithData runs a command with data piped into it, as root when sudo is set, and records it in the journal.
+// Unlike ExecWithInput the data isn't treated as a secret, use that for tokens and passwords.
+func (s *SysCall) ExecWithData(command string, args []string, data []byte, sudo bool) (*CommandResult, error) {
+	if sudo {
+		command, args = s.escalate(command, args)
+	}
+	started := time.Now()
+	result, err := s.sysCommand.ExecWithInput(command, args, string(data), sudo)
+	s.record(started, result, sudo, true, err)
+	return result, err
+}
+
+// ExecWithFile runs a command with the contents of a file piped into it, as root when sudo is set.
+// The file is read as the current user, so it can come from the home directory even when the command runs as root.
+func (s *SysCall) ExecWithFile(command string, args []string, path string, sudo bool) (*CommandResult, error) {
+	data, err := s.filesystem().ReadFile(path)
+	if err != nil {
+		return nil, internal.ErrorAs("SysCall.ExecWithFile", err)
+	}
+	return s.ExecWithData(command, args, data, sudo)
+}
+
+// WriteFileAsRoot writes data to a file only root can write to, creating or replacing it with perm.
+func (s *SysCall) WriteFileAsRoot(path string, data []byte, perm fs.FileMode) error {
+	// install reads the data from its stdin, which keeps it off the command line and out of any shell.
+	args := []string{"-m", fmt.Sprintf("%o", perm.Perm()), "/dev/stdin", path}
+	if _, err := s.ExecWithData("install", args, data, true); err != nil {
+		return internal.ErrorAs("SysCall.WriteFileAsRoot", err)
+	}
+	return nil
+}
+
+// filesystem returns the application's filesystem, or the host's when there is no application.
+func (s *SysCall) filesystem() filesystem.FS {
+	if s.application == nil || s.application.FS == nil {
+		return filesystem.Host()
+	}
+	return s.application.FS
+}
+
+// ExecPipeline runs the stages with each one's stdout connected to the next one's stdin.
+func (r cmdRunner) ExecPipeline(stages []PipelineStage) (*CommandResult, error) {
+	execCmds := make([]*exec.Cmd, len(stages))
+	for i, stage := range stages {
+		execCmds[i] = r.command(stage.Command, stage.Args)
+	}
+	result, err := r.run(execCmds...)
+	if err != nil {
+		return result, internal.ErrorAs("cmdRunner.ExecPipeline", err)
+	}
+	return result, nil
+}
+
+// runPipeline connects the commands with pipes, starts them all and waits for them all to exit.
+// The error is from the last command that failed, so a failing download isn't hidden by what it was piped into.
+func runPipeline(execCmds []*exec.Cmd) error {
+	// Our copies of the pipe ends have to be closed once the commands have them, or readers never see EOF.
+	var parentEnds []io.Closer
+	closeParentEnds := func() {
+		for _, end := range parentEnds {
+			_ = end.Close()
+		}
+		parentEnds = nil
+	}
+	for i := 0; i < len(execCmds)-1; i++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			closeParentEnds()
+			return err
+		}
+		execCmds[i].Stdout = writer
+		execCmds[i+1].Stdin = reader
+		parentEnds = append(parentEnds, reader, writer)
+	}
+
+	var started []*exec.Cmd
+	var startErr error
+	for _, execCmd := range execCmds {
+		if startErr = execCmd.Start(); startErr != nil {
+			break
+		}
+		started = append(started, execCmd)
+	}
+	closeParentEnds()
+
+	var waitErr error
+	for _, execCmd := range started {
+		if err := execCmd.Wait(); err != nil {
+			waitErr = err
+		}
+	}
+	if startErr != nil {
+		return startErr
+	}
+	return waitErr
+}
+
+// pipelineArgv joins the argv of each command with pipeSeparator, a single command's argv is left as is.
+func pipelineArgv(execCmds []*exec.Cmd) []string {
+	var argv []string
+	for i, execCmd := range execCmds {
+		if i > 0 {
+			argv = append(argv, pipeSeparator)
+		}
+		argv = append(argv, execCmd.Args...)
+	}
+	return argv
+}
+
+// lockedWriter serializes writes from several goroutines, such as every stage of a pipeline writing to stderr.
+type lockedWriter struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// Write writes p while holding the lock.
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Write(p)
+}
@@ -0,0 +1,74 @@
+package modules
+
+import (
+	"errors"
+	"github.com/Linkinlog/gasible/internal/app"
+	"reflect"
+	"testing"
+)
+
+func TestCmdRunnerExecPipeline(t *testing.T) {
+	result, err := cmdRunner{}.ExecPipeline([]PipelineStage{
+		{Command: "printf", Args: []string{"gasible\n"}},
+		{Command: "tr", Args: []string{"a-z", "A-Z"}},
+	})
+	if err != nil {
+		t.Fatalf("ExecPipeline returned an error: %v", err)
+	}
+	if result.Stdout != "GASIBLE\n" {
+		t.Errorf("got stdout %q, want %q", result.Stdout, "GASIBLE\n")
+	}
+	wantArgv := []string{"printf", "gasible\n", "|", "tr", "a-z", "A-Z"}
+	if !reflect.DeepEqual(result.Argv, wantArgv) {
+		t.Errorf("got argv %q, want %q", result.Argv, wantArgv)
+	}
+}
+
+func TestCmdRunnerExecPipelineFailingStage(t *testing.T) {
+	_, err := cmdRunner{}.ExecPipeline([]PipelineStage{
+		{Command: "false"},
+		{Command: "cat"},
+	})
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) || commandErr.Result.ExitCode != 1 {
+		t.Fatalf("got error %v, want the first stage's exit status 1", err)
+	}
+
+	_, err = cmdRunner{}.ExecPipeline([]PipelineStage{
+		{Command: "printf", Args: []string{"gasible"}},
+		{Command: "not-a-real-command-gasible"},
+	})
+	if !errors.As(err, &commandErr) || commandErr.Result.ExitCode != -1 {
+		t.Fatalf("got error %v, want a command that failed to start", err)
+	}
+}
+
+func TestSysCallWriteFileAsRoot(t *testing.T) {
+	fake := newFakeRunner(t).expect(fakeCommand{
+		Argv:  []string{"sudo", "install", "-m", "644", "/dev/stdin", "/etc/gasible.conf"},
+		Sudo:  true,
+		Stdin: "managed = true\n",
+	})
+	if err := newFakeSysCall(t, fake).WriteFileAsRoot("/etc/gasible.conf", []byte("managed = true\n"), 0644); err != nil {
+		t.Fatalf("WriteFileAsRoot returned an error: %v", err)
+	}
+}
+
+func TestSandboxedCommandsUseTheSandboxAsHome(t *testing.T) {
+	application := app.New()
+	root := t.TempDir()
+	if err := application.UseRoot(root); err != nil {
+		t.Fatalf("unable to sandbox the app: %v", err)
+	}
+	t.Cleanup(func() { _ = application.Close() })
+	call := &SysCall{name: "SysCall", Enabled: true, sysCommand: cmdRunner{}}
+	call.SetApp(application)
+
+	result, err := call.ForModule("Test").Exec("sh", []string{"-c", `printf %s "$HOME"`}, false)
+	if err != nil {
+		t.Fatalf("Exec returned an error: %v", err)
+	}
+	if result.Stdout != root {
+		t.Fatalf("got HOME %q, want the sandbox root %q", result.Stdout, root)
+	}
+}
@@ -0,0 +1,152 @@
+package modules
+
+import (
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// autoEscalation is the privilege-escalation setting that picks the first escalator found on the PATH.
+const autoEscalation = "auto"
+
+// noEscalation is the privilege-escalation setting that never escalates.
+const noEscalation = "none"
+
+// keepAliveInterval is how often we refresh cached credentials, well inside sudo's default 5 minute timeout.
+const keepAliveInterval = time.Minute
+
+// geteuid is os.Geteuid, kept as a variable so we can pretend to be root or not.
+var geteuid = os.Geteuid
+
+// privilegeEscalator describes a tool that runs commands as root.
+type privilegeEscalator struct {
+	// Prefix goes in front of the command to run it as root.
+	Prefix []string
+	// Validate asks for credentials up front, so we don't prompt halfway through a run. Empty if the tool can't.
+	Validate []string
+	// KeepAlive refreshes cached credentials without prompting. Empty if the tool doesn't cache them.
+	KeepAlive []string
+}
+
+// privilegeEscalators
+// Give it a string, get a privilegeEscalator.
+var privilegeEscalators = map[string]*privilegeEscalator{
+	"sudo": {
+		Prefix:    []string{"sudo"},
+		Validate:  []string{"sudo", "-v"},
+		KeepAlive: []string{"sudo", "-n", "-v"},
+	},
+	// doas only remembers credentials with `persist` in doas.conf, otherwise every command prompts.
+	"doas": {
+		Prefix:    []string{"doas"},
+		Validate:  []string{"doas", "true"},
+		KeepAlive: []string{"doas", "-n", "true"},
+	},
+	// pkexec and run0 ask polkit for each command, so there is nothing to validate or keep alive.
+	"pkexec": {
+		Prefix: []string{"pkexec"},
+	},
+	"run0": {
+		Prefix: []string{"run0"},
+	},
+	noEscalation: {},
+}
+
+// escalationDetectionOrder is the order we search the PATH in for the auto setting.
+var escalationDetectionOrder = []string{"sudo", "doas", "run0", "pkexec"}
+
+// resolveEscalator turns the privilege-escalation setting into an escalator, detecting one when asked to.
+func resolveEscalator(configured string) (string, *privilegeEscalator, error) {
+	if configured == "" || configured == autoEscalation {
+		for _, name := range escalationDetectionOrder {
+			if _, err := lookPath(name); err == nil {
+				return name, privilegeEscalators[name], nil
+			}
+		}
+		if geteuid() != 0 {
+			slog.Warn("no privilege escalation tool found, commands that need root will run as the current user",
+				"looked-for", strings.Join(escalationDetectionOrder, ", "))
+		}
+		return noEscalation, privilegeEscalators[noEscalation], nil
+	}
+
+	escalator, ok := privilegeEscalators[configured]
+	if !ok {
+		var unsupportedEscalatorErr = fmt.Errorf("unsupported privilege-escalation %s, use one of: %s or %s",
+			configured, strings.Join(escalatorNames(), ", "), autoEscalation)
+		return "", nil, internal.ErrorAs("resolveEscalator", unsupportedEscalatorErr)
+	}
+	return configured, escalator, nil
+}
+
+// escalatorNames returns the sorted names that can be used for the privilege-escalation setting.
+func escalatorNames() []string {
+	names := make([]string, 0, len(privilegeEscalators))
+	for name := range privilegeEscalators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// escalationPrefix returns what goes in front of a command to run it as root,
+// nothing when we already are root or escalation is turned off.
+func (s *SysCall) escalationPrefix() []string {
+	if s.escalator == nil || geteuid() == 0 {
+		return nil
+	}
+	return s.escalator.Prefix
+}
+
+// escalate rewrites a command to run as root when sudo is set.
+func (s *SysCall) escalate(command string, args []string) (string, []string) {
+	prefix := s.escalationPrefix()
+	if len(prefix) == 0 {
+		return command, args
+	}
+	escalatedArgs := make([]string, 0, len(prefix)+len(args))
+	escalatedArgs = append(escalatedArgs, prefix[1:]...)
+	escalatedArgs = append(escalatedArgs, command)
+	escalatedArgs = append(escalatedArgs, args...)
+	return prefix[0], escalatedArgs
+}
+
+// AcquirePrivileges asks for credentials once before a run and keeps them fresh until release is called,
+// so long runs don't stop halfway to prompt for a password. It does nothing when no escalation is needed.
+func (s *SysCall) AcquirePrivileges() (release func(), err error) {
+	release = func() {}
+	if len(s.escalationPrefix()) == 0 || len(s.escalator.Validate) == 0 {
+		return release, nil
+	}
+
+	// Validation may prompt, so it gets the terminal rather than having its output captured.
+	if err = s.sysCommand.ExecInteractive(s.escalator.Validate[0], s.escalator.Validate[1:]); err != nil {
+		return release, internal.ErrorAs("SysCall.AcquirePrivileges", err)
+	}
+	if len(s.escalator.KeepAlive) == 0 {
+		return release, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				keepAliveCmd := exec.Command(s.escalator.KeepAlive[0], s.escalator.KeepAlive[1:]...)
+				if keepAliveErr := keepAliveCmd.Run(); keepAliveErr != nil {
+					slog.Debug("unable to refresh credentials", "command", keepAliveCmd.Args, "error", keepAliveErr)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }, nil
+}
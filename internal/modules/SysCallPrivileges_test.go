@@ -0,0 +1,113 @@
+package modules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveEscalator(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		onPath     []string
+		want       string
+	}{
+		{"auto prefers sudo", autoEscalation, []string{"doas", "sudo"}, "sudo"},
+		{"auto falls back to doas", "", []string{"doas", "pkexec"}, "doas"},
+		{"auto finds nothing", autoEscalation, nil, noEscalation},
+		{"configured is used as is", "doas", []string{"sudo"}, "doas"},
+		{"turned off", noEscalation, []string{"sudo"}, noEscalation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubDetection(t, "", tt.onPath...)
+			got, escalator, err := resolveEscalator(tt.configured)
+			if err != nil {
+				t.Fatalf("resolveEscalator returned an error: %v", err)
+			}
+			if got != tt.want || escalator != privilegeEscalators[tt.want] {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := resolveEscalator("su"); err == nil || !strings.Contains(err.Error(), "unsupported privilege-escalation su") {
+		t.Fatalf("got error %v, want one naming the unsupported setting", err)
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	tests := []struct {
+		name      string
+		escalator string
+		euid      int
+		want      []string
+	}{
+		{"sudo", "sudo", 1000, []string{"sudo", "dnf", "install", "-y", "git"}},
+		{"doas", "doas", 1000, []string{"doas", "dnf", "install", "-y", "git"}},
+		{"none", noEscalation, 1000, []string{"dnf", "install", "-y", "git"}},
+		{"already root", "sudo", 0, []string{"dnf", "install", "-y", "git"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := newFakeSysCall(t, newFakeRunner(t))
+			call.escalator = privilegeEscalators[tt.escalator]
+			geteuid = func() int { return tt.euid }
+
+			command, args := call.escalate("dnf", []string{"install", "-y", "git"})
+			if got := append([]string{command}, args...); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got `%s`, want `%s`", strings.Join(got, " "), strings.Join(tt.want, " "))
+			}
+		})
+	}
+}
+
+func TestAcquirePrivileges(t *testing.T) {
+	tests := []struct {
+		name      string
+		escalator string
+		expected  []fakeCommand
+	}{
+		{"sudo", "sudo", []fakeCommand{{Argv: []string{"sudo", "-v"}}}},
+		{"doas", "doas", []fakeCommand{{Argv: []string{"doas", "true"}}}},
+		{"pkexec has nothing to validate", "pkexec", nil},
+		{"none", noEscalation, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := newFakeSysCall(t, newFakeRunner(t).expect(tt.expected...))
+			call.escalator = privilegeEscalators[tt.escalator]
+
+			release, err := call.AcquirePrivileges()
+			if err != nil {
+				t.Fatalf("AcquirePrivileges returned an error: %v", err)
+			}
+			release()
+		})
+	}
+}
+
+func TestAcquirePrivilegesAsRootDoesNothing(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t))
+	geteuid = func() int { return 0 }
+	release, err := call.AcquirePrivileges()
+	if err != nil {
+		t.Fatalf("AcquirePrivileges returned an error: %v", err)
+	}
+	release()
+}
+
+func TestAcquirePrivilegesFailedCredentials(t *testing.T) {
+	call := newFakeSysCall(t, newFakeRunner(t).expect(
+		fakeCommand{Argv: []string{"sudo", "-v"}, ExitCode: 1, Stderr: "sudo: 3 incorrect password attempts\n"},
+	))
+	release, err := call.AcquirePrivileges()
+	if err == nil {
+		t.Fatal("AcquirePrivileges returned no error when the credentials were refused")
+	}
+	if release == nil {
+		t.Fatal("AcquirePrivileges returned no release func alongside its error")
+	}
+	release()
+}
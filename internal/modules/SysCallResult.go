@@ -0,0 +1,82 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// stderrLinesInErrors is how many of the last lines of stderr we put in a CommandError's message.
+const stderrLinesInErrors = 10
+
+// CommandResult is everything we know about a command once it has run.
+type CommandResult struct {
+	Argv     []string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// String returns the command line that was run.
+func (r *CommandResult) String() string {
+	return strings.Join(r.Argv, " ")
+}
+
+// Output returns stdout followed by stderr, for the odd place where the two don't need telling apart.
+func (r *CommandResult) Output() string {
+	if r.Stderr == "" {
+		return r.Stdout
+	}
+	if r.Stdout == "" {
+		return r.Stderr
+	}
+	return strings.TrimRight(r.Stdout, "\n") + "\n" + r.Stderr
+}
+
+// CommandError is returned when a command couldn't be started or exited with a non-zero status.
+type CommandError struct {
+	Result *CommandResult
+	Err    error
+}
+
+// Error describes the command, its exit code and the end of its stderr.
+func (e *CommandError) Error() string {
+	var message string
+	if e.Result.ExitCode < 0 {
+		message = fmt.Sprintf("command `%s` failed to run: %v", e.Result, e.Err)
+	} else {
+		message = fmt.Sprintf("command `%s` exited with status %d", e.Result, e.Result.ExitCode)
+	}
+	if stderr := lastLines(e.Result.Stderr, stderrLinesInErrors); stderr != "" {
+		message += ": " + stderr
+	}
+	return message
+}
+
+// Unwrap returns the underlying error, such as an *exec.ExitError.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// newCommandError wraps the error from running a command, working out its exit code.
+func newCommandError(result *CommandResult, err error) *CommandError {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else {
+		result.ExitCode = -1
+	}
+	return &CommandError{Result: result, Err: err}
+}
+
+// lastLines returns up to n of the last non-empty lines of text.
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) > n {
+		lines = append([]string{"..."}, lines[len(lines)-n:]...)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,207 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"sync"
+)
+
+// EncryptedTag marks a YAML scalar as encrypted, e.g. `token: !encrypted gasible:v1:...`.
+const EncryptedTag = "!encrypted"
+
+// encryptedPrefix versions the format so we can change the KDF or cipher later on.
+const encryptedPrefix = "gasible:v1:"
+
+// Parameters for deriving a key from the passphrase, these are the argon2id defaults recommended by RFC 9106.
+const (
+	saltSize      = 16
+	argonTime     = 1
+	argonMemory   = 64 * 1024
+	argonThreads  = 4
+	keyFileLength = 32
+)
+
+// ErrDecrypt is returned when a value can't be decrypted, usually because the passphrase or key file is wrong.
+var ErrDecrypt = errors.New("unable to decrypt value, wrong passphrase or key file?")
+
+// Cipher encrypts and decrypts config values with XChaCha20-Poly1305,
+// using a key derived with argon2id from the passphrase and a per-value salt.
+type Cipher struct {
+	passphrase []byte
+}
+
+// NewCipher returns a pointer to a Cipher for the passphrase, which can also be the contents of a key file.
+func NewCipher(passphrase string) *Cipher {
+	return &Cipher{passphrase: []byte(passphrase)}
+}
+
+// Encrypt returns the value in the form that goes after the !encrypted tag.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	salt := make([]byte, saltSize, saltSize+chacha20poly1305.NonceSizeX+len(plaintext)+chacha20poly1305.Overhead)
+	if _, err := rand.Read(salt); err != nil {
+		return "", internal.ErrorAs("Cipher.Encrypt", err)
+	}
+	aead, err := chacha20poly1305.NewX(c.key(salt))
+	if err != nil {
+		return "", internal.ErrorAs("Cipher.Encrypt", err)
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err = rand.Read(nonce); err != nil {
+		return "", internal.ErrorAs("Cipher.Encrypt", err)
+	}
+
+	sealed := append(salt, nonce...)
+	sealed = aead.Seal(sealed, nonce, []byte(plaintext), []byte(encryptedPrefix))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	var malformedErr = fmt.Errorf("encrypted value must start with %q", encryptedPrefix)
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(value), encryptedPrefix)
+	if !ok {
+		return "", internal.ErrorAs("Cipher.Decrypt", malformedErr)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", internal.ErrorAs("Cipher.Decrypt", err)
+	}
+	if len(sealed) < saltSize+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return "", internal.ErrorAs("Cipher.Decrypt", ErrDecrypt)
+	}
+
+	salt := sealed[:saltSize]
+	nonce := sealed[saltSize : saltSize+chacha20poly1305.NonceSizeX]
+	aead, err := chacha20poly1305.NewX(c.key(salt))
+	if err != nil {
+		return "", internal.ErrorAs("Cipher.Decrypt", err)
+	}
+	plaintext, err := aead.Open(nil, nonce, sealed[saltSize+chacha20poly1305.NonceSizeX:], []byte(encryptedPrefix))
+	if err != nil {
+		return "", internal.ErrorAs("Cipher.Decrypt", ErrDecrypt)
+	}
+	return string(plaintext), nil
+}
+
+// key derives the encryption key for a given salt.
+func (c *Cipher) key(salt []byte) []byte {
+	return argon2.IDKey(c.passphrase, salt, argonTime, argonMemory, argonThreads, chacha20poly1305.KeySize)
+}
+
+// GenerateKey returns the contents for a new random key file.
+func GenerateKey() (string, error) {
+	key := make([]byte, keyFileLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", internal.ErrorAs("GenerateKey", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// LazyCipher returns a function that resolves the passphrase from the source the first time it is called,
+// so users are only prompted when the config actually contains encrypted values.
+func LazyCipher(passphrase Source, fsys filesystem.FS) func() (*Cipher, error) {
+	var once sync.Once
+	var cipher *Cipher
+	var err error
+	return func() (*Cipher, error) {
+		once.Do(func() {
+			var value string
+			value, err = passphrase.Resolve(fsys)
+			if err == nil {
+				cipher = NewCipher(value)
+			}
+		})
+		return cipher, err
+	}
+}
+
+// DecryptNode walks a YAML document and replaces every !encrypted scalar with its plaintext string.
+// It returns how many values were decrypted.
+func DecryptNode(node *yaml.Node, cipher func() (*Cipher, error)) (int, error) {
+	return walkEncrypted(node, func(value string) (string, error) {
+		c, err := cipher()
+		if err != nil {
+			return "", err
+		}
+		return c.Decrypt(value)
+	}, "!!str")
+}
+
+// RekeyNode walks a YAML document and re-encrypts every !encrypted scalar from one cipher to another.
+// It returns how many values were re-encrypted.
+func RekeyNode(node *yaml.Node, from *Cipher, to *Cipher) (int, error) {
+	return walkEncrypted(node, func(value string) (string, error) {
+		plaintext, err := from.Decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return to.Encrypt(plaintext)
+	}, EncryptedTag)
+}
+
+// walkEncrypted applies transform to every !encrypted scalar below node, setting the resulting tag on it.
+func walkEncrypted(node *yaml.Node, transform func(string) (string, error), tag string) (int, error) {
+	if node == nil {
+		return 0, nil
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == EncryptedTag {
+		value, err := transform(node.Value)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+		node.Tag = tag
+		return 1, nil
+	}
+
+	count := 0
+	for _, child := range node.Content {
+		childCount, err := walkEncrypted(child, transform, tag)
+		if err != nil {
+			return count, err
+		}
+		count += childCount
+	}
+	return count, nil
+}
+
+// TagEncryptedStrings turns "!encrypted gasible:v1:..." strings into !encrypted scalars,
+// which is how encrypted values are written in formats without tags, like JSON and TOML.
+func TagEncryptedStrings(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.ScalarNode {
+		if value, ok := strings.CutPrefix(node.Value, EncryptedTag+" "); ok {
+			node.Tag = EncryptedTag
+			node.Value = strings.TrimSpace(value)
+		}
+		return
+	}
+	for _, child := range node.Content {
+		TagEncryptedStrings(child)
+	}
+}
+
+// UntagEncrypted is the reverse of TagEncryptedStrings, for writing a document out to JSON or TOML.
+func UntagEncrypted(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == EncryptedTag {
+		node.Tag = "!!str"
+		node.Value = EncryptedTag + " " + node.Value
+		return
+	}
+	for _, child := range node.Content {
+		UntagEncrypted(child)
+	}
+}
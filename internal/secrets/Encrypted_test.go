@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	cipher := NewCipher("correct horse battery staple")
+	encrypted, err := cipher.Encrypt("ghp_secret")
+	if err != nil {
+		t.Fatalf("Encrypt returned an error: %v", err)
+	}
+	if !strings.HasPrefix(encrypted, encryptedPrefix) || strings.Contains(encrypted, "ghp_secret") {
+		t.Fatalf("got %q, want an opaque value starting with %q", encrypted, encryptedPrefix)
+	}
+	again, err := cipher.Encrypt("ghp_secret")
+	if err != nil || again == encrypted {
+		t.Fatalf("got %q and error %v, want a fresh salt and nonce for every value", again, err)
+	}
+
+	decrypted, err := cipher.Decrypt(encrypted)
+	if err != nil || decrypted != "ghp_secret" {
+		t.Fatalf("got %q and error %v, want the plaintext back", decrypted, err)
+	}
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	encrypted, err := NewCipher("right").Encrypt("ghp_secret")
+	if err != nil {
+		t.Fatalf("Encrypt returned an error: %v", err)
+	}
+	if _, err = NewCipher("wrong").Decrypt(encrypted); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("got error %v, want ErrDecrypt", err)
+	}
+}
+
+func TestDecryptTamperedValue(t *testing.T) {
+	cipher := NewCipher("passphrase")
+	encrypted, err := cipher.Encrypt("ghp_secret")
+	if err != nil {
+		t.Fatalf("Encrypt returned an error: %v", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedPrefix))
+	if err != nil {
+		t.Fatalf("unable to decode the value: %v", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	tampered := encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+	if _, err = cipher.Decrypt(tampered); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("got error %v, want ErrDecrypt for a modified ciphertext", err)
+	}
+
+	truncated := encryptedPrefix + base64.StdEncoding.EncodeToString(sealed[:saltSize])
+	if _, err = cipher.Decrypt(truncated); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("got error %v, want ErrDecrypt for a truncated value", err)
+	}
+	if _, err = cipher.Decrypt("ghp_secret"); err == nil {
+		t.Fatal("got no error for a value without the prefix")
+	}
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"golang.org/x/term"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// valueProvider is a secret written straight into the config, normally as an !encrypted value
+// that has already been decrypted while loading the config.
+type valueProvider struct {
+	value string
+}
+
+// Name describes the provider for error messages.
+func (p valueProvider) Name() string { return "value" }
+
+// Lookup returns the value as is.
+func (p valueProvider) Lookup() (string, error) {
+	return p.value, nil
+}
+
+// envProvider reads the secret from an environment variable.
+type envProvider struct {
+	key string
+}
+
+// Name describes the provider for error messages.
+func (p envProvider) Name() string { return "env " + p.key }
+
+// Lookup returns the variable's value, or ErrNotFound when it isn't set.
+func (p envProvider) Lookup() (string, error) {
+	value, ok := os.LookupEnv(p.key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// fileProvider reads the secret from a file, a leading ~ is expanded to the home directory of fsys.
+type fileProvider struct {
+	path string
+	fsys filesystem.FS
+}
+
+// Name describes the provider for error messages.
+func (p fileProvider) Name() string { return "file " + p.path }
+
+// Lookup returns the file contents without the trailing newline.
+func (p fileProvider) Lookup() (string, error) {
+	path, err := filesystem.ExpandHome(p.fsys, p.path)
+	if err != nil {
+		return "", err
+	}
+	info, err := p.fsys.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		slog.Warn("secret file is readable by other users, consider chmod 600", "path", path)
+	}
+	contents, err := p.fsys.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+// commandProvider runs a command such as `pass show` or `op read` and uses its output as the secret.
+// It runs outside SysCall on purpose, so the secret never ends up in command logs.
+type commandProvider struct {
+	argv []string
+}
+
+// Name describes the provider for error messages.
+func (p commandProvider) Name() string { return "command " + p.argv[0] }
+
+// Lookup runs the command and returns the first line of its stdout.
+func (p commandProvider) Lookup() (string, error) {
+	var stderr bytes.Buffer
+	// #nosec G204 -- the command comes from the user's own config.
+	execCmd := exec.Command(p.argv[0], p.argv[1:]...)
+	execCmd.Stderr = &stderr
+	execCmd.Stdin = os.Stdin
+	out, err := execCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// pass and friends store metadata after the first line.
+	firstLine, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimRight(firstLine, "\r"), nil
+}
+
+// promptProvider asks the user for the secret without echoing it back.
+type promptProvider struct {
+	message string
+}
+
+// Name describes the provider for error messages.
+func (p promptProvider) Name() string { return "prompt" }
+
+// Lookup prompts on stderr and reads the secret from stdin.
+// When stdin isn't a terminal (e.g. piped in) we read a plain line instead.
+func (p promptProvider) Lookup() (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", strings.TrimSuffix(p.message, ": "))
+	stdinFd := int(os.Stdin.Fd())
+	if term.IsTerminal(stdinFd) {
+		secret, err := term.ReadPassword(stdinFd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(secret), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
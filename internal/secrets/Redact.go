@@ -0,0 +1,57 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces a known secret wherever we record what we ran.
+const Redacted = "[REDACTED]"
+
+// minRedactLength stops short values, which are unlikely to be real secrets, from redacting half a log.
+const minRedactLength = 4
+
+// known holds every secret value seen during this run.
+var known = struct {
+	sync.Mutex
+	values []string
+}{}
+
+// Remember marks a value as secret, so Redact will hide it from now on.
+// Every value returned by Source.Resolve is remembered automatically.
+func Remember(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minRedactLength {
+		return
+	}
+	known.Lock()
+	defer known.Unlock()
+	for _, existing := range known.values {
+		if existing == value {
+			return
+		}
+	}
+	known.values = append(known.values, value)
+	// Longest first, so a secret that contains another is replaced whole.
+	sort.Slice(known.values, func(i, j int) bool { return len(known.values[i]) > len(known.values[j]) })
+}
+
+// RedactArgs returns a copy of a command's arguments with every remembered secret replaced.
+func RedactArgs(argv []string) []string {
+	redacted := make([]string, len(argv))
+	for i, arg := range argv {
+		redacted[i] = Redact(arg)
+	}
+	return redacted
+}
+
+// Redact replaces every remembered secret in text.
+func Redact(text string) string {
+	known.Lock()
+	defer known.Unlock()
+	for _, value := range known.values {
+		text = strings.ReplaceAll(text, value, Redacted)
+	}
+	return text
+}
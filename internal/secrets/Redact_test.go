@@ -0,0 +1,25 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	Remember("ghp_redactTestToken")
+	Remember("ghp_redactTestToken_longer")
+	Remember("abc")
+
+	got := Redact("token ghp_redactTestToken_longer and ghp_redactTestToken, abc")
+	if want := "token " + Redacted + " and " + Redacted + ", abc"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	argv := []string{"gh", "auth", "login", "--with-token=ghp_redactTestToken"}
+	want := []string{"gh", "auth", "login", "--with-token=" + Redacted}
+	if got := RedactArgs(argv); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if argv[3] != "--with-token=ghp_redactTestToken" {
+		t.Fatal("RedactArgs changed the arguments it was given")
+	}
+}
@@ -0,0 +1,139 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The freedesktop Secret Service API, implemented by gnome-keyring, KeePassXC and KWallet.
+// https://specifications.freedesktop.org/secret-service/latest/
+const (
+	secretServiceName = "org.freedesktop.secrets"
+	secretServicePath = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretInterface   = "org.freedesktop.Secret"
+	noPromptPath      = dbus.ObjectPath("/")
+)
+
+// unlockTimeout is how long we wait for the keyring's unlock prompt to be answered.
+var unlockTimeout = 2 * time.Minute
+
+// secretServiceProvider looks up an item in the user's keyring by its attributes,
+// the same attributes you would hand to `secret-tool store`.
+type secretServiceProvider struct {
+	attributes map[string]string
+}
+
+// secretServiceSecret is the (oayays) struct the Secret Service hands back.
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Name describes the provider for error messages.
+func (p secretServiceProvider) Name() string {
+	pairs := make([]string, 0, len(p.attributes))
+	for key, value := range p.attributes {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return "keyring " + strings.Join(pairs, ",")
+}
+
+// Lookup opens a plain session on the session bus, unlocks the matching item if needed and reads its secret.
+func (p secretServiceProvider) Lookup() (string, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return "", fmt.Errorf("connecting to the session bus: %w", err)
+	}
+	service := conn.Object(secretServiceName, secretServicePath)
+
+	var sessionOutput dbus.Variant
+	var session dbus.ObjectPath
+	err = service.Call(secretInterface+".Service.OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&sessionOutput, &session)
+	if err != nil {
+		return "", fmt.Errorf("opening secret service session: %w", err)
+	}
+	defer conn.Object(secretServiceName, session).Call(secretInterface+".Session.Close", 0)
+
+	var unlocked, locked []dbus.ObjectPath
+	err = service.Call(secretInterface+".Service.SearchItems", 0, p.attributes).Store(&unlocked, &locked)
+	if err != nil {
+		return "", fmt.Errorf("searching secret service: %w", err)
+	}
+	if len(unlocked) == 0 && len(locked) > 0 {
+		unlocked, err = unlockItems(conn, service, locked[:1])
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(unlocked) == 0 {
+		return "", ErrNotFound
+	}
+
+	var secret secretServiceSecret
+	err = conn.Object(secretServiceName, unlocked[0]).
+		Call(secretInterface+".Item.GetSecret", 0, session).Store(&secret)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+	return string(secret.Value), nil
+}
+
+// unlockItems asks the Secret Service to unlock the items, following the prompt if the keyring wants one.
+func unlockItems(conn *dbus.Conn, service dbus.BusObject, items []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var unlockDismissedErr = errors.New("keyring unlock was dismissed")
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := service.Call(secretInterface+".Service.Unlock", 0, items).Store(&unlocked, &prompt)
+	if err != nil {
+		return nil, fmt.Errorf("unlocking secret: %w", err)
+	}
+	if prompt == noPromptPath {
+		return unlocked, nil
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+	matchOpts := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(secretInterface + ".Prompt"),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err = conn.AddMatchSignal(matchOpts...); err != nil {
+		return nil, fmt.Errorf("waiting for unlock prompt: %w", err)
+	}
+	defer func() { _ = conn.RemoveMatchSignal(matchOpts...) }()
+
+	if err = conn.Object(secretServiceName, prompt).Call(secretInterface+".Prompt.Prompt", 0, "").Err; err != nil {
+		return nil, fmt.Errorf("showing unlock prompt: %w", err)
+	}
+	timeout := time.NewTimer(unlockTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case signal, ok := <-signals:
+			if !ok {
+				return nil, errors.New("secret service went away while waiting for the keyring to be unlocked")
+			}
+			if signal.Path != prompt || len(signal.Body) < 2 {
+				continue
+			}
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return nil, unlockDismissedErr
+			}
+			result, _ := signal.Body[1].(dbus.Variant)
+			paths, _ := result.Value().([]dbus.ObjectPath)
+			return paths, nil
+		case <-timeout.C:
+			return nil, fmt.Errorf("keyring unlock prompt wasn't answered within %s", unlockTimeout)
+		}
+	}
+}
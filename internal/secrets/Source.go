@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+)
+
+// ErrNotFound is returned by a provider that is configured but has nothing to give us,
+// such as an unset environment variable. It lets Resolve move on to the next provider quietly.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider is anything that can look up a secret value.
+type Provider interface {
+	Name() string
+	Lookup() (string, error)
+}
+
+// Source is a secret as it appears in a module's settings.
+// Any combination of providers can be set, they are tried in the order the fields are declared
+// and the first one to return a value wins.
+//
+//	token:
+//	  value: !encrypted gasible:v1:...
+//	  env: GASIBLE_GH
+//	  command: ["pass", "show", "github/token"]
+//	  prompt: "Enter GitHub token"
+type Source struct {
+	Value   string            `yaml:"value,omitempty"`
+	Env     string            `yaml:"env,omitempty"`
+	File    string            `yaml:"file,omitempty"`
+	Command []string          `yaml:"command,omitempty"`
+	Keyring map[string]string `yaml:"keyring,omitempty"`
+	Prompt  string            `yaml:"prompt,omitempty"`
+}
+
+// IsZero reports whether no provider has been configured, this also lets yaml omit an empty Source.
+func (s Source) IsZero() bool {
+	return s.Value == "" && s.Env == "" && s.File == "" && len(s.Command) == 0 && len(s.Keyring) == 0 && s.Prompt == ""
+}
+
+// Providers returns the configured providers in the order they should be tried, files are read through fsys.
+func (s Source) Providers(fsys filesystem.FS) []Provider {
+	var providers []Provider
+	if s.Value != "" {
+		providers = append(providers, valueProvider{value: s.Value})
+	}
+	if s.Env != "" {
+		providers = append(providers, envProvider{key: s.Env})
+	}
+	if s.File != "" {
+		providers = append(providers, fileProvider{path: s.File, fsys: fsys})
+	}
+	if len(s.Command) > 0 {
+		providers = append(providers, commandProvider{argv: s.Command})
+	}
+	if len(s.Keyring) > 0 {
+		providers = append(providers, secretServiceProvider{attributes: s.Keyring})
+	}
+	if s.Prompt != "" {
+		providers = append(providers, promptProvider{message: s.Prompt})
+	}
+	return providers
+}
+
+// Resolve tries each configured provider until one of them returns the secret, which is then remembered for Redact.
+func (s Source) Resolve(fsys filesystem.FS) (string, error) {
+	var noProvidersErr = errors.New("no secret source configured")
+	providers := s.Providers(fsys)
+	if len(providers) == 0 {
+		return "", internal.ErrorAs("Source.Resolve", noProvidersErr)
+	}
+
+	var errs []error
+	for _, provider := range providers {
+		value, err := provider.Lookup()
+		if err == nil && value != "" {
+			Remember(value)
+			return value, nil
+		}
+		if err == nil {
+			err = ErrNotFound
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+	}
+	return "", internal.ErrorAs("Source.Resolve", errors.Join(errs...))
+}
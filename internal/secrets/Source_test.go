@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceResolve(t *testing.T) {
+	fsys, err := filesystem.Sandbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to sandbox: %v", err)
+	}
+	if err = os.WriteFile(filepath.Join(fsys.Root(), "token"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("unable to write the secret file: %v", err)
+	}
+	t.Setenv("GASIBLE_TEST_TOKEN", "from-env")
+	t.Setenv("GASIBLE_TEST_EMPTY", "")
+
+	tests := []struct {
+		name   string
+		source Source
+		want   string
+	}{
+		{"value comes first", Source{Value: "from-value", Env: "GASIBLE_TEST_TOKEN", File: "~/token"}, "from-value"},
+		{"env before file", Source{Env: "GASIBLE_TEST_TOKEN", File: "~/token"}, "from-env"},
+		{"unset env falls through", Source{Env: "GASIBLE_TEST_UNSET", File: "~/token"}, "from-file"},
+		{"empty env falls through", Source{Env: "GASIBLE_TEST_EMPTY", File: "~/token"}, "from-file"},
+		{"file before command", Source{File: "~/token", Command: []string{"echo", "from-command"}}, "from-file"},
+		{"missing file falls through", Source{File: "~/missing", Command: []string{"printf", "from-command\\nmetadata\\n"}}, "from-command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.Resolve(fsys)
+			if err != nil {
+				t.Fatalf("Resolve returned an error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceResolveErrors(t *testing.T) {
+	fsys, err := filesystem.Sandbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to sandbox: %v", err)
+	}
+
+	_, err = Source{Env: "GASIBLE_TEST_UNSET"}.Resolve(fsys)
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "env GASIBLE_TEST_UNSET") {
+		t.Errorf("got error %v, want the unset variable not found", err)
+	}
+
+	_, err = Source{File: "~/missing"}.Resolve(fsys)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "file ~/missing") {
+		t.Errorf("got error %v, want the missing file", err)
+	}
+
+	_, err = Source{Env: "GASIBLE_TEST_UNSET", File: "~/missing"}.Resolve(fsys)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want every provider's error", err)
+	}
+
+	_, err = Source{Command: []string{"false"}}.Resolve(fsys)
+	if err == nil || !strings.Contains(err.Error(), "command false") {
+		t.Errorf("got error %v, want the failed command", err)
+	}
+
+	if _, err = (Source{}).Resolve(fsys); err == nil || !strings.Contains(err.Error(), "no secret source configured") {
+		t.Errorf("got error %v, want no source configured", err)
+	}
+}
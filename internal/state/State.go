@@ -0,0 +1,66 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Linkinlog/gasible/internal"
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+// fileExtension is the extension of each module's state file.
+const fileExtension = ".json"
+
+// Store keeps what modules have changed on the machine, such as repositories they added,
+// so a later teardown can undo exactly that and nothing else. Each module's state is its own JSON file.
+type Store struct {
+	mu   sync.Mutex
+	fsys filesystem.FS
+	dir  string
+}
+
+// New returns a pointer to a Store keeping its files in dir.
+func New(fsys filesystem.FS, dir string) *Store {
+	return &Store{fsys: fsys, dir: dir}
+}
+
+// Load reads the module's state into v, leaving v as it is when the module has none yet.
+func (s *Store) Load(module string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.fsys.ReadFile(s.path(module))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return internal.ErrorAs("Store.Load", err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return internal.ErrorAs("Store.Load", err)
+	}
+	return nil
+}
+
+// Save replaces the module's state with v.
+func (s *Store) Save(module string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return internal.ErrorAs("Store.Save", err)
+	}
+	if err = s.fsys.MkdirAll(s.dir, 0700); err != nil {
+		return internal.ErrorAs("Store.Save", err)
+	}
+	if err = s.fsys.WriteFile(s.path(module), append(data, '\n'), 0600); err != nil {
+		return internal.ErrorAs("Store.Save", err)
+	}
+	return nil
+}
+
+// path returns the module's state file.
+func (s *Store) path(module string) string {
+	return filepath.Join(s.dir, module+fileExtension)
+}
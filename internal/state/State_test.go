@@ -0,0 +1,31 @@
+package state
+
+import (
+	"github.com/Linkinlog/gasible/internal/filesystem"
+	"reflect"
+	"testing"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	fsys, err := filesystem.Sandbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create sandbox: %v", err)
+	}
+	home, _ := fsys.HomeDir()
+	store := New(fsys, home+"/.gas/state")
+
+	type repositories struct{ Added []string }
+	loaded := repositories{Added: []string{"untouched"}}
+	if err = store.Load("GenericPackageManager", &loaded); err != nil || loaded.Added[0] != "untouched" {
+		t.Fatalf("got %+v and error %v, want nothing loaded for a module without state", loaded, err)
+	}
+
+	saved := repositories{Added: []string{"ppa:deadsnakes/ppa", "copr:atim/lazygit"}}
+	if err = store.Save("GenericPackageManager", saved); err != nil {
+		t.Fatalf("Save returned an error: %v", err)
+	}
+	loaded = repositories{}
+	if err = store.Load("GenericPackageManager", &loaded); err != nil || !reflect.DeepEqual(loaded, saved) {
+		t.Fatalf("got %+v and error %v, want %+v", loaded, err, saved)
+	}
+}